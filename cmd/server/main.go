@@ -2,22 +2,45 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"image"
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"example.com/image-factory/pkg/actors"
 	"example.com/image-factory/pkg/api"
-	_ "example.com/image-factory/pkg/messages" // ensure protobuf Struct is registered
+	"example.com/image-factory/pkg/imageops"
+	_ "example.com/image-factory/pkg/messages" // ensure message types are registered
+	"example.com/image-factory/pkg/ops"
 	"example.com/image-factory/pkg/storage"
+	"example.com/image-factory/pkg/tracing"
 	"github.com/lytics/grid/v3"
 	etcd "go.etcd.io/etcd/client/v3"
 )
 
+// Build info, set with -ldflags "-X main.version=... -X main.commit=...
+// -X main.buildTime=...".
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildTime = "unknown"
+)
+
 func main() {
+	setupLogging()
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		log.Printf("tracing disabled: %v", err)
+		shutdownTracing = func(context.Context) error { return nil }
+	}
+
 	endpoints := []string{os.Getenv("ETCD_ENDPOINT")}
 	if endpoints[0] == "" {
 		endpoints = []string{"localhost:2379"}
@@ -29,29 +52,124 @@ func main() {
 	}
 	defer cli.Close()
 
-	namespace := "imgsvc"
+	namespace := os.Getenv("GRID_NAMESPACE")
+	if namespace == "" {
+		namespace = "imgsvc"
+	}
 
 	server, err := grid.NewServer(cli, grid.ServerCfg{Namespace: namespace})
 	if err != nil {
 		log.Fatalf("grid server: %v", err)
 	}
 
+	// Optional persistent store
+	persistWorkers := envInt("PERSIST_WORKERS", 4)
+	store := openStore(context.Background(), persistWorkers)
+
+	animated := os.Getenv("ANIMATED_WEBP") // "first" (default) or "reject"
+	matchQuality := envBool("MATCH_SOURCE_QUALITY")
+	unsupported := os.Getenv("UNSUPPORTED_OPS") // "fail" (default) or "requeue"
+	concurrency := envInt("WORKER_CONCURRENCY", 1)
+	workerMailbox := envInt("WORKER_MAILBOX_SIZE", 100)
+	leaseTTL := envDuration("WORKER_LEASE_TTL", 10*time.Second)
+	processTimeout := envDuration("WORKER_PROCESS_TIMEOUT", 2*time.Minute)
+	// one limit for every worker this process runs
+	transformLimit := actors.NewTransformLimit(envInt("MAX_CONCURRENT_TRANSFORMS", 0))
+	var metadata map[string]string
+	if v := os.Getenv("OUTPUT_METADATA"); v != "" {
+		if err := json.Unmarshal([]byte(v), &metadata); err != nil {
+			log.Printf("invalid OUTPUT_METADATA: %v; ignoring", err)
+		}
+	}
+	var logo image.Image
+	if path := os.Getenv("WATERMARK_LOGO"); path != "" {
+		if logo, err = imageops.LoadWatermark(path); err != nil {
+			log.Printf("invalid WATERMARK_LOGO: %v; text watermarks only", err)
+		}
+	}
+	newWorker := func(op string) *actors.Worker {
+		return &actors.Worker{
+			Server:             server,
+			Etcd:               cli,
+			Namespace:          namespace,
+			SupportedOp:        op,
+			AnimatedWebP:       animated,
+			MatchSourceQuality: matchQuality,
+			Unsupported:        unsupported,
+			Metadata:           metadata,
+			Background:         os.Getenv("BACKGROUND_COLOR"),
+			PreserveAlpha:      envBool("PRESERVE_ALPHA"),
+			WatermarkLogo:      logo,
+			Concurrency:        concurrency,
+			MailboxSize:        workerMailbox,
+			LeaseTTL:           leaseTTL,
+			ProcessTimeout:     processTimeout,
+			Store:              store,
+			Limit:              transformLimit,
+		}
+	}
+	worker := func(op string) grid.MakeActor {
+		return func(_ []byte) (grid.Actor, error) {
+			return newWorker(op), nil
+		}
+	}
+
 	// Register actor definitions
+	jitterMax := envDuration("DISPATCH_JITTER", 0)
+	jitterBacklog := envInt("DISPATCH_JITTER_BACKLOG", 10)
+	inlineMax := int64(envInt("INLINE_MAX_BYTES", 256<<10))
+	retries := envInt("TRANSFORM_RETRIES", 2)
+	retryBackoff := envDuration("TRANSFORM_RETRY_BACKOFF", 500*time.Millisecond)
+	dispatchTimeout := envDuration("DISPATCH_TIMEOUT", 5*time.Second)
+	transformTimeout := envDuration("TRANSFORM_TIMEOUT", 2*time.Minute+15*time.Second)
+	if processTimeout > 0 && transformTimeout <= processTimeout {
+		log.Printf("TRANSFORM_TIMEOUT %s is not above WORKER_PROCESS_TIMEOUT %s; transforms still running will be retried", transformTimeout, processTimeout)
+	}
+	thumbnailSizes := envIntList("THUMBNAIL_SIZES")
+	uploadsMailbox := envInt("COORDINATOR_MAILBOX_SIZE", 100)
+	localFallback := envBool("LOCAL_TRANSFORM_FALLBACK")
+	idempotencyTTL := envDuration("IDEMPOTENCY_TTL", 10*time.Minute)
+	workerSelection := os.Getenv("WORKER_SELECTION") // "fastest" (default), "round_robin" or "fewest_outstanding"
+	var opDefaults ops.Defaults
+	if path := os.Getenv("OP_DEFAULTS_FILE"); path != "" {
+		validate := func(op string, params map[string]any) error {
+			if !imageops.Has(op) {
+				return nil // e.g. inspect, which reads no params
+			}
+			return imageops.Validate(op, params)
+		}
+		if opDefaults, err = ops.LoadDefaults(path, validate); err != nil {
+			log.Fatalf("op defaults: %v", err)
+		}
+	}
 	server.RegisterDef("leader", func(_ []byte) (grid.Actor, error) {
-		return &actors.Coordinator{Server: server, Etcd: cli, Namespace: namespace}, nil
-	})
-	server.RegisterDef("worker-thumb", func(_ []byte) (grid.Actor, error) {
-		return &actors.Worker{Server: server, Etcd: cli, Namespace: namespace, SupportedOp: "thumbnail"}, nil
-	})
-	server.RegisterDef("worker-gray", func(_ []byte) (grid.Actor, error) {
-		return &actors.Worker{Server: server, Etcd: cli, Namespace: namespace, SupportedOp: "grayscale"}, nil
-	})
-	server.RegisterDef("worker-blur", func(_ []byte) (grid.Actor, error) {
-		return &actors.Worker{Server: server, Etcd: cli, Namespace: namespace, SupportedOp: "blur"}, nil
-	})
-	server.RegisterDef("worker-rot", func(_ []byte) (grid.Actor, error) {
-		return &actors.Worker{Server: server, Etcd: cli, Namespace: namespace, SupportedOp: "rotate90"}, nil
+		var local *actors.Worker
+		if localFallback {
+			local = newWorker("")
+		}
+		return &actors.Coordinator{
+			Server:           server,
+			Etcd:             cli,
+			Namespace:        namespace,
+			JitterMax:        jitterMax,
+			JitterBacklog:    jitterBacklog,
+			InlineMaxBytes:   inlineMax,
+			MaxRetries:       retries,
+			RetryBackoff:     retryBackoff,
+			DispatchTimeout:  dispatchTimeout,
+			TransformTimeout: transformTimeout,
+			ThumbnailSizes:   thumbnailSizes,
+			MailboxSize:      uploadsMailbox,
+			IdempotencyTTL:   idempotencyTTL,
+			DefaultParams:    opDefaults,
+			WorkerSelection:  workerSelection,
+			Local:            local,
+		}, nil
 	})
+	// one worker definition per registered op
+	for _, spec := range ops.All() {
+		server.RegisterDef(spec.Worker, worker(spec.Name))
+	}
 
 	// Listen and serve grid
 	addr := os.Getenv("GRID_BIND")
@@ -79,36 +197,158 @@ func main() {
 		log.Fatalf("grid start error: %v", err)
 	}
 
-	// Optional Spanner store
-	var store *storage.SpannerStore
-	if dsn := os.Getenv("SPANNER_DSN"); dsn != "" {
-		st, err := storage.NewSpannerStore(context.Background(), dsn)
-		if err != nil {
-			log.Printf("spanner init error: %v", err)
-		} else {
-			store = st
-			log.Printf("spanner store initialized: %s", dsn)
-		}
+	imgsDir := "./data"
+	_ = os.MkdirAll(imgsDir, 0755)
+
+	// Verify the environment before serving; STRICT_STARTUP=1 makes any
+	// failed check fatal.
+	if failed := selfCheck(context.Background(), cli, store, imgsDir); failed > 0 && envBool("STRICT_STARTUP") {
+		log.Fatalf("startup self-check: %d check(s) failed", failed)
 	}
 
 	// Start HTTP API
-	imgsDir := "./data"
-	_ = os.MkdirAll(imgsDir, 0755)
-	go func() {
-		apiSrv := api.New(cli, namespace, server, imgsDir, store)
-		apiSrv.Listen(":8080")
-	}()
+	apiSrv := api.New(cli, namespace, server, imgsDir, store, api.BuildInfo{Version: version, Commit: commit, BuildTime: buildTime})
+	apiSrv.APIKeys = envList("API_KEYS")
+	apiSrv.ReadKeys = envList("READ_API_KEYS")
+	if len(apiSrv.APIKeys) == 0 {
+		log.Printf("API_KEYS not set; uploads and admin endpoints are unauthenticated")
+	}
+	apiSrv.CORSOrigins = envList("CORS_ORIGINS")
+	if len(apiSrv.CORSOrigins) == 0 && envBool("DEV_MODE") {
+		apiSrv.CORSOrigins = []string{"*"}
+	}
+	apiSrv.CORSMethods = envList("CORS_METHODS")
+	apiSrv.CORSHeaders = envList("CORS_HEADERS")
+	apiSrv.WaitForOp = os.Getenv("FAST_SERVE_OP")
+	apiSrv.WaitTimeout = envDuration("FAST_SERVE_TIMEOUT", 5*time.Second)
+	apiSrv.UploadRate = envFloat("UPLOAD_RATE", 0)
+	apiSrv.UploadBurst = envInt("UPLOAD_BURST", 0)
+	apiSrv.MaxUploadBytes = int64(envInt("MAX_UPLOAD_BYTES", 20<<20))
+	apiSrv.IngestMaxEdge = envInt("INGEST_MAX_EDGE", 0)
+	apiSrv.Dedup = envBool("DEDUP_UPLOADS")
+	apiSrv.PathTemplate = os.Getenv("IMAGE_PATH_TEMPLATE")
+	if err := api.ValidatePathTemplate(apiSrv.PathTemplate); err != nil {
+		log.Fatalf("IMAGE_PATH_TEMPLATE %q: %v", apiSrv.PathTemplate, err)
+	}
+	apiSrv.IdempotencyKeyTTL = envDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour)
+	apiSrv.ThumbnailSizes = envIntList("THUMBNAIL_SIZES")
+	apiSrv.TransformSizes = envIntList("TRANSFORM_SIZES")
+	apiSrv.StoreReadRetries = envInt("STORE_READ_RETRIES", 2)
+	apiSrv.PersistWorkers = persistWorkers
+	apiSrv.PersistQueue = envInt("PERSIST_QUEUE", 256)
+	apiSrv.StoreBreakerThreshold = envInt("STORE_BREAKER_THRESHOLD", 5)
+	apiSrv.StoreBreakerCooldown = envDuration("STORE_BREAKER_COOLDOWN", 30*time.Second)
+	apiSrv.StoreReadBackoff = envDuration("STORE_READ_BACKOFF", 50*time.Millisecond)
+	apiSrv.DispatchRetries = envInt("UPLOAD_DISPATCH_RETRIES", 3)
+	apiSrv.DispatchBackoff = envDuration("UPLOAD_DISPATCH_BACKOFF", 200*time.Millisecond)
+	apiSrv.UnconfirmedTimeout = envDuration("UPLOAD_UNCONFIRMED_TIMEOUT", 10*time.Minute)
+	apiSrv.SrcsetBaseWidth = envInt("SRCSET_BASE_WIDTH", 0)
+	apiSrv.RenderConcurrency = envInt("RENDER_CONCURRENCY", 0)
+	apiSrv.RenderQueue = envInt("RENDER_QUEUE", 16)
+	apiSrv.VariantCacheBytes = envInt("VARIANT_CACHE_BYTES", 64<<20)
+	apiSrv.VariantMaxAge = envDuration("VARIANT_MAX_AGE", 0)
+	apiSrv.RenderCacheBytes = envInt("RENDER_CACHE_BYTES", 64<<20)
+	apiSrv.PrefetchDepth = envInt("PREFETCH_DEPTH", 8)
+	apiSrv.PrefetchMaxBytes = int64(envInt("PREFETCH_MAX_BYTES", 256<<20))
+	go apiSrv.Listen(":8080")
+
+	// Optional store/disk reconciliation, e.g. RECONCILE_INTERVAL=10m
+	if every := envDuration("RECONCILE_INTERVAL", 0); every > 0 && store != nil {
+		rate := envFloat("RECONCILE_RATE", 5)
+		log.Printf("reconciler enabled: every %s at %.1f images/s", every, rate)
+		go apiSrv.RunReconciler(context.Background(), every, rate)
+	}
 
 	// Start local per-op workers with unique names
-	if v := os.Getenv("AUTO_START_LOCAL_WORKERS"); v == "1" || strings.ToLower(v) == "true" {
-		go startWorker(clientConfig{cli, namespace}, server.Name(), "worker-thumb")
-		go startWorker(clientConfig{cli, namespace}, server.Name(), "worker-gray")
-		go startWorker(clientConfig{cli, namespace}, server.Name(), "worker-blur")
-		go startWorker(clientConfig{cli, namespace}, server.Name(), "worker-rot")
+	if envBool("AUTO_START_LOCAL_WORKERS") {
+		for _, spec := range ops.All() {
+			go startWorker(clientConfig{cli, namespace}, server.Name(), spec.Worker)
+		}
 	}
 
-	// Block forever
-	select {}
+	// Run until SIGINT/SIGTERM, then shut down in dependency order: stop
+	// taking requests, let actors finish their current task, flush the
+	// store writes their results triggered, and close clients.
+	sigCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-sigCtx.Done()
+	log.Printf("shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), envDuration("SHUTDOWN_TIMEOUT", 30*time.Second))
+	defer cancel()
+	if err := apiSrv.Shutdown(ctx); err != nil {
+		log.Printf("api shutdown: %v", err)
+	}
+	server.Stop()
+	if _, err := apiSrv.Flush(ctx); err != nil {
+		log.Printf("flush store writes: %v", err)
+	}
+	if store != nil {
+		store.Close()
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("flush traces: %v", err)
+	}
+	log.Printf("shutdown complete")
+}
+
+// openStore opens the backend named by STORE_BACKEND ("spanner", "gcs" or
+// "disk"), defaulting to Spanner when SPANNER_DSN is set. It returns nil,
+// running without a store, when none is configured or it fails to open.
+// persistWorkers, the API's concurrent variant saves, sizes Spanner's
+// write batches.
+func openStore(ctx context.Context, persistWorkers int) storage.Store {
+	backend := os.Getenv("STORE_BACKEND")
+	if backend == "" && os.Getenv("SPANNER_DSN") != "" {
+		backend = "spanner"
+	}
+	switch backend {
+	case "":
+		return nil
+	case "spanner":
+		dsn := os.Getenv("SPANNER_DSN")
+		st, err := storage.NewSpannerStore(ctx, dsn)
+		if err != nil {
+			log.Printf("spanner init error: %v", err)
+			return nil
+		}
+		st.WriteAttempts = envInt("SPANNER_WRITE_ATTEMPTS", 5)
+		st.WriteBackoff = envDuration("SPANNER_WRITE_BACKOFF", 100*time.Millisecond)
+		// A batch holds at most one save per persist worker, since each
+		// waits for its batch to commit; a bigger one could only fill by
+		// waiting out the interval.
+		st.BatchSize = envInt("SPANNER_BATCH_SIZE", persistWorkers)
+		if st.BatchSize > persistWorkers && persistWorkers > 0 {
+			log.Printf("SPANNER_BATCH_SIZE %d is above PERSIST_WORKERS %d; using %d", st.BatchSize, persistWorkers, persistWorkers)
+			st.BatchSize = persistWorkers
+		}
+		st.BatchInterval = envDuration("SPANNER_BATCH_INTERVAL", 50*time.Millisecond)
+		log.Printf("spanner store initialized: %s", dsn)
+		return st
+	case "gcs":
+		bucket := os.Getenv("GCS_BUCKET")
+		st, err := storage.NewGCSStore(ctx, bucket, os.Getenv("GCS_PREFIX"))
+		if err != nil {
+			log.Printf("gcs init error: %v", err)
+			return nil
+		}
+		log.Printf("gcs store initialized: gs://%s", bucket)
+		return st
+	case "disk":
+		dir := os.Getenv("STORE_DIR")
+		if dir == "" {
+			dir = "./store"
+		}
+		st, err := storage.NewDiskStore(dir)
+		if err != nil {
+			log.Printf("disk store init error: %v", err)
+			return nil
+		}
+		log.Printf("disk store initialized: %s", dir)
+		return st
+	}
+	log.Printf("unknown STORE_BACKEND %q; running without a store", backend)
+	return nil
 }
 
 type clientConfig struct {
@@ -130,7 +370,7 @@ func startWorker(cfg clientConfig, serverName, actorType string) {
 	}
 
 	name := fmt.Sprintf("%s-%d", actorType, time.Now().UnixNano())
-	start := grid.NewActorStart(name)
+	start := grid.NewActorStart("%s", name)
 	start.Type = actorType
 	if _, err := client.RequestC(context.Background(), serverName, start); err != nil {
 		log.Printf("start %s error: %v", actorType, err)
@@ -139,6 +379,80 @@ func startWorker(cfg clientConfig, serverName, actorType string) {
 	}
 }
 
+// envBool reports whether the named env var is "1" or "true".
+func envBool(name string) bool {
+	v := os.Getenv(name)
+	return v == "1" || strings.ToLower(v) == "true"
+}
+
+// envList splits the named env var on commas, dropping empty entries.
+func envList(name string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// envIntList parses the named env var as a comma-separated list of positive
+// integers, skipping (and logging) entries that aren't.
+func envIntList(name string) []int {
+	var out []int
+	for _, v := range envList(name) {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Printf("invalid %s entry %q; skipping", name, v)
+			continue
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// envDuration parses a Go duration from the named env var, or returns def.
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("invalid %s=%q: %v; using %s", name, v, err, def)
+		return def
+	}
+	return d
+}
+
+// envInt parses an integer from the named env var, or returns def.
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("invalid %s=%q: %v; using %d", name, v, err, def)
+		return def
+	}
+	return n
+}
+
+// envFloat parses a float from the named env var, or returns def.
+func envFloat(name string, def float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Printf("invalid %s=%q: %v; using %v", name, v, err, def)
+		return def
+	}
+	return f
+}
+
 // firstPrivateIPv4 returns the first non-loopback IPv4 address of the host.
 func firstPrivateIPv4() string {
 	ifs, err := net.Interfaces()
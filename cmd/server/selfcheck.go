@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"example.com/image-factory/pkg/ops"
+	"example.com/image-factory/pkg/storage"
+	etcd "go.etcd.io/etcd/client/v3"
+)
+
+// check is one named startup check; a nil err means it passed.
+type check struct {
+	name string
+	err  error
+}
+
+// selfCheck verifies the dependencies and wiring the server needs, logs a
+// PASS/FAIL line per check plus a summary, and returns the failure count.
+// The grid server is checked by the caller's WaitUntilStarted.
+func selfCheck(ctx context.Context, cli *etcd.Client, store storage.Store, imgsDir string) int {
+	checks := []check{
+		{"etcd reachable", checkEtcd(ctx, cli)},
+		{"data dir writable", checkWritable(imgsDir)},
+	}
+	if store != nil {
+		checks = append(checks, check{"store healthy", store.HealthCheck(ctx)})
+	}
+	checks = append(checks, checkOps()...)
+
+	failed := 0
+	for _, c := range checks {
+		if c.err != nil {
+			failed++
+			log.Printf("self-check FAIL %s: %v", c.name, c.err)
+		} else {
+			log.Printf("self-check PASS %s", c.name)
+		}
+	}
+	log.Printf("self-check: %d passed, %d failed", len(checks)-failed, failed)
+	return failed
+}
+
+func checkEtcd(ctx context.Context, cli *etcd.Client) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	for _, ep := range cli.Endpoints() {
+		if _, err := cli.Status(ctx, ep); err == nil {
+			return nil
+		}
+	}
+	_, err := cli.Get(ctx, "/")
+	return err
+}
+
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".selfcheck-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+// checkOps checks that every op in the registry names a worker actor type
+// of its own, since the grid definitions and /admin/scale come from it.
+func checkOps() []check {
+	owner := map[string]string{}
+	var checks []check
+	for _, spec := range ops.All() {
+		var err error
+		if spec.Worker == "" {
+			err = errors.New("no worker type")
+		} else if other, ok := owner[spec.Worker]; ok {
+			err = fmt.Errorf("worker type %q is also %s's", spec.Worker, other)
+		} else {
+			owner[spec.Worker] = spec.Name
+		}
+		checks = append(checks, check{"op " + spec.Name, err})
+	}
+	return checks
+}
@@ -20,12 +20,18 @@ CREATE TABLE Variants (
   Op STRING(MAX) NOT NULL,
   Data BYTES(MAX),
   ContentType STRING(64),
+  -- Hex SHA-256 of Data, for content-addressed URLs (/cas/{hash}.{ext}).
+  -- Existing databases: ALTER TABLE Variants ADD COLUMN ContentHash STRING(64);
+  ContentHash STRING(64),
   CreatedAt TIMESTAMP OPTIONS (allow_commit_timestamp=true)
 ) PRIMARY KEY (ImageID, Op);
 
 -- Helpful index to list images by creation time (optional)
 CREATE INDEX ImagesByCreatedAt ON Images (CreatedAt DESC);
 
+-- Resolves content-addressed URLs to variant bytes
+CREATE INDEX VariantsByContentHash ON Variants (ContentHash);
+
 -- Helpful index to list recent variants (optional)
 CREATE INDEX VariantsByCreatedAt ON Variants (CreatedAt DESC);
 
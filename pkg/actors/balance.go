@@ -0,0 +1,66 @@
+package actors
+
+import "sync"
+
+// Worker selection strategies for the coordinator's dispatches.
+const (
+	// SelectFastest broadcasts each task to every worker of its op and
+	// keeps the first answer. With many workers the quickest responder
+	// tends to win every race and take most of the load.
+	SelectFastest = "fastest"
+	// SelectRoundRobin sends to the op's workers in turn.
+	SelectRoundRobin = "round_robin"
+	// SelectFewestOutstanding sends to the worker with the fewest tasks
+	// this coordinator has sent it and not yet had answered. It knows
+	// nothing of other coordinators' tasks or of the worker's own queue,
+	// so it only evens out what this coordinator sends.
+	SelectFewestOutstanding = "fewest_outstanding"
+)
+
+// ValidSelection reports whether s names a worker selection strategy.
+func ValidSelection(s string) bool {
+	switch s {
+	case SelectFastest, SelectRoundRobin, SelectFewestOutstanding:
+		return true
+	}
+	return false
+}
+
+// balancer picks one worker mailbox per task for the round-robin and
+// fewest-outstanding strategies.
+type balancer struct {
+	mu   sync.Mutex
+	next map[string]int // op -> rotation index
+	load map[string]int // mailbox -> tasks outstanding
+}
+
+// pick chooses a mailbox among members, the op's registered workers in a
+// stable order, and counts the task against it until done is called.
+// Fewest-outstanding breaks ties in rotation, so idle workers share the work.
+func (b *balancer) pick(strategy, op string, members []string) (mailbox string, done func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.next == nil {
+		b.next = make(map[string]int)
+		b.load = make(map[string]int)
+	}
+	start := b.next[op] % len(members)
+	b.next[op] = start + 1
+	mailbox = members[start]
+	if strategy == SelectFewestOutstanding {
+		for i := 1; i < len(members); i++ {
+			m := members[(start+i)%len(members)]
+			if b.load[m] < b.load[mailbox] {
+				mailbox = m
+			}
+		}
+	}
+	b.load[mailbox]++
+	return mailbox, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.load[mailbox]--; b.load[mailbox] <= 0 {
+			delete(b.load, mailbox)
+		}
+	}
+}
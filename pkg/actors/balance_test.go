@@ -0,0 +1,39 @@
+package actors
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBalancerRoundRobin(t *testing.T) {
+	var b balancer
+	members := []string{"a", "b", "c"}
+	var got []string
+	for i := 0; i < 4; i++ {
+		m, done := b.pick(SelectRoundRobin, "blur", members)
+		done()
+		got = append(got, m)
+	}
+	if want := []string{"a", "b", "c", "a"}; !slices.Equal(got, want) {
+		t.Fatalf("picked %v, want %v", got, want)
+	}
+	// each op keeps its own rotation
+	if m, _ := b.pick(SelectRoundRobin, "thumbnail", members); m != "a" {
+		t.Fatalf("first thumbnail pick = %q, want a", m)
+	}
+}
+
+func TestBalancerFewestOutstanding(t *testing.T) {
+	var b balancer
+	members := []string{"a", "b"}
+	first, doneFirst := b.pick(SelectFewestOutstanding, "blur", members)
+	second, _ := b.pick(SelectFewestOutstanding, "blur", members)
+	if first == second {
+		t.Fatalf("both tasks went to %q while the other worker was idle", first)
+	}
+	doneFirst()
+	// first has answered, second still has a task outstanding
+	if m, _ := b.pick(SelectFewestOutstanding, "blur", members); m != first {
+		t.Fatalf("picked %q, want the idle %q", m, first)
+	}
+}
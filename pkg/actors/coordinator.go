@@ -2,17 +2,25 @@ package actors
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"sync/atomic"
 	"time"
 
+	"example.com/image-factory/pkg/messages"
+	"example.com/image-factory/pkg/ops"
+	"example.com/image-factory/pkg/tracing"
 	"github.com/lytics/grid/v3"
 	etcdv3 "go.etcd.io/etcd/client/v3"
 	"google.golang.org/protobuf/types/known/structpb"
 )
 
 const (
-	uploadsMailbox = "uploads"
+	uploadsMailbox      = "uploads"
+	systemEventsMailbox = "system-events"
+	backlogInterval     = time.Second
 )
 
 // Coordinator receives image upload events and fans out transform tasks to workers.
@@ -20,74 +28,237 @@ type Coordinator struct {
 	Server    *grid.Server
 	Etcd      *etcdv3.Client
 	Namespace string
+
+	// JitterMax spreads dispatches by a random delay of up to this much
+	// once at least JitterBacklog uploads are queued or dispatches held
+	// back; 0 disables it.
+	JitterMax     time.Duration
+	JitterBacklog int
+
+	// InlineMaxBytes is the largest original sent inline with each task;
+	// bigger ones travel by path/store reference. 0 disables inlining.
+	InlineMaxBytes int64
+
+	// MaxRetries is how many times a failed or timed-out task is retried,
+	// waiting RetryBackoff, then twice that, and so on, between attempts.
+	MaxRetries   int
+	RetryBackoff time.Duration
+
+	// DispatchTimeout bounds looking up an op's workers, and
+	// TransformTimeout how long a worker has to return its result before
+	// the attempt counts as failed and is retried; 0 means 5s and 2m15s.
+	// Keep TransformTimeout above the workers' ProcessTimeout, or attempts
+	// are retried while the first is still running.
+	DispatchTimeout  time.Duration
+	TransformTimeout time.Duration
+
+	// Local, when set, transforms tasks for ops no worker serves right
+	// here in the coordinator, instead of failing them. It defeats the
+	// point of the worker pool, so it is meant for single-node setups and
+	// as a last resort while a worker type is down.
+	Local *Worker
+
+	// MailboxSize is how many upload events the mailbox buffers before
+	// the API blocks sending more; 0 means 100.
+	MailboxSize int
+
+	// ThumbnailSizes, when set, renders a thumbnail per size (a square box
+	// of that many pixels) as variants named thumbnail_<size> in place of
+	// the single default thumbnail.
+	ThumbnailSizes []int
+
+	// IdempotencyTTL is how long a handled upload event's id is
+	// remembered, so a redelivery or resend of it within that window is
+	// acked and dropped instead of dispatched again; 0 means 10m.
+	IdempotencyTTL time.Duration
+
+	// DefaultParams fills in each op's params for keys an upload leaves
+	// unset, ahead of the workers' built-in defaults.
+	DefaultParams ops.Defaults
+
+	// WorkerSelection picks how each task's worker is chosen:
+	// SelectFastest (default), SelectRoundRobin or SelectFewestOutstanding.
+	WorkerSelection string
+
+	inflight dispatches
+	balance  balancer
+	held     atomic.Int64 // dispatches waiting out their jitter
+	log      *slog.Logger
 }
 
 func (c *Coordinator) Act(ctx context.Context) {
 	name, _ := grid.ContextActorName(ctx)
-	log.Printf("[coordinator %s] starting", name)
+	c.log = slog.With("component", "coordinator", "actor", name)
+	if c.Local != nil {
+		c.Local.log = c.log.With("local", true)
+	}
+	c.log.Info("starting")
+	if c.WorkerSelection != "" && !ValidSelection(c.WorkerSelection) {
+		c.log.Warn("unknown worker selection, using fastest", "selection", c.WorkerSelection)
+		c.WorkerSelection = SelectFastest
+	}
 
-	mb, err := c.Server.NewMailbox(uploadsMailbox, 100)
+	mb, err := c.Server.NewMailbox(uploadsMailbox, mailboxSize(c.MailboxSize))
 	if err != nil {
-		log.Printf("coordinator: cannot create mailbox: %v", err)
+		c.log.Error("cannot create mailbox", "err", err)
 		return
 	}
 	defer mb.Close()
 
+	client, err := grid.NewClient(c.Etcd, grid.ClientCfg{Namespace: c.Namespace})
+	if err != nil {
+		c.log.Error("grid client", "err", err)
+		return
+	}
+	defer client.Close()
+
+	go c.publishBacklog(ctx, client, name)
+
+	seen := newSeenEvents(c.IdempotencyTTL)
+
 	for {
 		select {
 		case <-ctx.Done():
-			log.Printf("coordinator exiting")
+			c.log.Info("exiting")
 			return
 		case req := <-mb.C():
-			msg, ok := req.Msg().(*structpb.Struct)
+			msg, ok := messages.AsUploadEvent(req.Msg())
 			if !ok {
 				_ = req.Ack()
 				continue
 			}
-			imageID := msg.GetFields()["image_id"].GetStringValue()
-			log.Printf("coordinator received upload for image %s", imageID)
+			imageID := msg.GetImageId()
+			c.log.Info("received upload", "image_id", imageID)
 
 			// Acknowledge to unblock sender (HTTP API)
 			_ = req.Ack()
 
-			ops := []string{"thumbnail", "grayscale", "blur", "rotate90"}
-
-			client, err := grid.NewClient(c.Etcd, grid.ClientCfg{Namespace: c.Namespace})
-			if err != nil {
-				log.Printf("coordinator grid client error: %v", err)
+			if ev := msg.GetEventId(); ev != "" && !seen.first(imageID+"/"+ev, time.Now()) {
+				c.log.Info("dropping repeated upload event", "image_id", imageID, "event_id", ev)
 				continue
 			}
 
-			for _, op := range ops {
-				task, _ := structpb.NewStruct(map[string]any{
-					"image_id": imageID,
-					"op":       op,
-					"path":     msg.GetFields()["path"].GetStringValue(),
-				})
-				ctxb, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-				// Discover worker mailboxes for this op from etcd
-				prefix := fmt.Sprintf("/%s/workers/%s/", c.Namespace, op)
-				resp, err := c.Etcd.Get(ctxb, prefix, etcdv3.WithPrefix())
-				members := []string{}
-				if err == nil {
-					for _, kv := range resp.Kvs {
-						mbox := string(kv.Key)
-						// Extract mailbox name from key suffix
-						if idx := len(prefix); idx <= len(mbox) {
-							members = append(members, mbox[idx:])
-						}
-					}
+			selected := c.selectOps(imageID, msg)
+			inline := c.inlineOriginal(msg.GetPath())
+
+			for _, v := range c.variants(selected, msg) {
+				op := v.Op
+				task := &messages.TransformTask{
+					ImageId:    imageID,
+					Op:         op,
+					Path:       msg.GetPath(),
+					Format:     msg.GetFormat(),
+					Params:     c.DefaultParams.Merge(op, msg.GetParams()[op]),
+					Original:   inline,
+					Quality:    msg.GetQuality(),
+					Metadata:   msg.GetMetadata(),
+					Background: msg.GetBackground(),
+					Effort:     msg.GetEffort(),
+					AutoOrient: msg.AutoOrient,
 				}
-				if len(members) == 0 {
-					cancel()
-					continue
+				if v.Size > 0 {
+					task.Variant = v.Name
+					task.Params, _ = structpb.NewStruct(map[string]any{"width": v.Size, "height": v.Size})
 				}
-				grp := grid.NewListGroup(members...)
-				_, _ = client.BroadcastC(ctxb, grp.Fastest(), task)
-				cancel()
+				tctx := tracing.Remote(ctx, msg.GetTraceId(), msg.GetSpanId())
+				c.schedule(len(mb.C()), func() { c.dispatch(tctx, client, task) })
 			}
+		}
+	}
+}
 
-			client.Close()
+// publishBacklog reports the in-flight dispatch count per op to the API
+// whenever it changes, and an empty backlog once the coordinator stops.
+func (c *Coordinator) publishBacklog(ctx context.Context, client *grid.Client, name string) {
+	t := time.NewTicker(backlogInterval)
+	defer t.Stop()
+	last := map[string]int32{}
+	for {
+		select {
+		case <-ctx.Done():
+			if len(last) > 0 {
+				c.sendBacklog(client, name, nil)
+			}
+			return
+		case <-t.C:
+			cur := c.inflight.pending()
+			if maps.Equal(cur, last) {
+				continue
+			}
+			if c.sendBacklog(client, name, cur) {
+				last = cur
+			}
 		}
 	}
 }
+
+func (c *Coordinator) sendBacklog(client *grid.Client, name string, pending map[string]int32) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), dispatchTimeout)
+	defer cancel()
+	_, err := client.RequestC(ctx, systemEventsMailbox, &messages.SystemEvent{
+		Event:   "backlog",
+		Name:    name,
+		Pending: pending,
+	})
+	if err != nil {
+		c.log.Warn("publish backlog", "err", err)
+		return false
+	}
+	return true
+}
+
+// selectOps returns the ops to dispatch for an upload, logging any it asked
+// for that the registry doesn't know.
+func (c *Coordinator) selectOps(imageID string, msg *messages.UploadEvent) []string {
+	selected, unknown := ops.Select(msg.GetOps(), func(op string) bool {
+		_, ok := msg.GetParams()[op]
+		return ok
+	})
+	for _, op := range unknown {
+		c.log.Warn("dropping unknown op", "image_id", imageID, "op", op)
+	}
+	return selected
+}
+
+// variants expands the selected ops into the variants to dispatch, one
+// per ThumbnailSizes entry for a thumbnail without params of its own.
+func (c *Coordinator) variants(selected []string, msg *messages.UploadEvent) []ops.Variant {
+	return ops.Variants(selected, c.ThumbnailSizes, func(op string) bool {
+		_, ok := msg.GetParams()[op]
+		return ok
+	})
+}
+
+// inlineOriginal reads the original when it is small enough to ride along
+// with the task, sparing workers a disk or store read.
+func (c *Coordinator) inlineOriginal(path string) []byte {
+	if c.InlineMaxBytes <= 0 {
+		return nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil || fi.Size() > c.InlineMaxBytes {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// schedule starts dispatch, first holding it back a random slice of
+// JitterMax once JitterBacklog uploads are queued or dispatches held back,
+// so a burst doesn't hit discovery and worker mailboxes at once. A held
+// dispatch waits on a timer, not in the receive loop, which keeps
+// draining the mailbox meanwhile.
+func (c *Coordinator) schedule(queued int, dispatch func()) {
+	if c.JitterMax <= 0 || queued+int(c.held.Load()) < c.JitterBacklog {
+		go dispatch()
+		return
+	}
+	c.held.Add(1)
+	time.AfterFunc(rand.N(c.JitterMax), func() {
+		c.held.Add(-1)
+		dispatch()
+	})
+}
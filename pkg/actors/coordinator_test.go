@@ -0,0 +1,111 @@
+package actors
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInlineOriginal(t *testing.T) {
+	dir := t.TempDir()
+	small, large := filepath.Join(dir, "small.jpg"), filepath.Join(dir, "large.jpg")
+	if err := os.WriteFile(small, make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(large, make([]byte, 101), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := &Coordinator{InlineMaxBytes: 100}
+	if got := c.inlineOriginal(small); !bytes.Equal(got, make([]byte, 100)) {
+		t.Errorf("original at the limit: inlined %d bytes, want all 100", len(got))
+	}
+	if got := c.inlineOriginal(large); got != nil {
+		t.Errorf("original over the limit inlined (%d bytes)", len(got))
+	}
+	if got := c.inlineOriginal(filepath.Join(dir, "missing.jpg")); got != nil {
+		t.Errorf("missing original inlined (%d bytes)", len(got))
+	}
+	c.InlineMaxBytes = 0
+	if got := c.inlineOriginal(small); got != nil {
+		t.Errorf("inlining disabled, still inlined %d bytes", len(got))
+	}
+}
+
+func TestScheduleJitter(t *testing.T) {
+	c := &Coordinator{JitterMax: 50 * time.Millisecond, JitterBacklog: 10}
+	var wg sync.WaitGroup
+	var ran atomic.Int32
+	run := func() {
+		ran.Add(1)
+		wg.Done()
+	}
+
+	// a shallow backlog dispatches straight away
+	wg.Add(1)
+	c.schedule(0, run)
+	wg.Wait()
+
+	// a deep one holds dispatches back without holding up the caller
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		c.schedule(20-i, run)
+	}
+	if d := time.Since(start); d > 10*time.Millisecond {
+		t.Errorf("scheduling 20 dispatches blocked for %v", d)
+	}
+	if held := c.held.Load(); held == 0 {
+		t.Error("no dispatch held back under a deep backlog")
+	}
+	wg.Wait()
+	if ran.Load() != 21 || c.held.Load() != 0 {
+		t.Fatalf("ran %d dispatches with %d still held, want 21 and 0", ran.Load(), c.held.Load())
+	}
+}
+
+// BenchmarkDispatchJitter measures the p99 latency, arrival to finish, of
+// a burst of dispatches against a lookup whose cost grows with the
+// lookups running alongside it, as worker discovery's does under a herd.
+func BenchmarkDispatchJitter(b *testing.B) {
+	const burst = 200
+	const lookup = 50 * time.Microsecond
+	for _, tt := range []struct {
+		name   string
+		jitter time.Duration
+	}{
+		{"off", 0},
+		{"2ms", 2 * time.Millisecond},
+		{"10ms", 10 * time.Millisecond},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			var p99s []time.Duration
+			for i := 0; i < b.N; i++ {
+				c := &Coordinator{JitterMax: tt.jitter, JitterBacklog: 10}
+				var running atomic.Int64
+				lat := make([]time.Duration, burst)
+				var wg sync.WaitGroup
+				wg.Add(burst)
+				start := time.Now()
+				for j := 0; j < burst; j++ {
+					c.schedule(burst-j, func() {
+						defer wg.Done()
+						n := running.Add(1)
+						time.Sleep(time.Duration(n) * lookup)
+						running.Add(-1)
+						lat[j] = time.Since(start)
+					})
+				}
+				wg.Wait()
+				slices.Sort(lat)
+				p99s = append(p99s, lat[burst*99/100])
+			}
+			slices.Sort(p99s)
+			b.ReportMetric(float64(p99s[len(p99s)/2].Microseconds())/1000, "p99-ms")
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package actors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // register decoders used by image.Decode
+	_ "image/jpeg"
+	_ "image/png"
+
+	"example.com/image-factory/pkg/ops"
+	"github.com/disintegration/imaging"
+	_ "golang.org/x/image/webp"
+)
+
+// Animated WebP handling policies.
+const (
+	AnimatedFirstFrame = "first"
+	AnimatedReject     = "reject"
+)
+
+var errAnimatedWebP = errors.New("animated webp is not supported")
+
+// decodeSource decodes an original, normalizing inputs that the plain
+// imaging.Open path decodes oddly: CMYK JPEGs are converted to RGB and
+// animated WebPs are reduced to their first frame (or rejected, per policy).
+// With autoOrient, a JPEG's EXIF orientation is applied to the pixels so
+// phone portraits don't come out sideways.
+func decodeSource(data []byte, animated string, autoOrient bool) (image.Image, error) {
+	var err error
+	if isAnimatedWebP(data) {
+		if animated == AnimatedReject {
+			return nil, errAnimatedWebP
+		}
+		if data, err = firstWebPFrame(data); err != nil {
+			return nil, fmt.Errorf("animated webp: %w", err)
+		}
+	}
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(autoOrient))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported or corrupt image: %w", err)
+	}
+	if _, ok := img.(*image.CMYK); ok {
+		// Ops operate on RGB; convert explicitly so ink values never leak through.
+		return imaging.Clone(img), nil
+	}
+	return img, nil
+}
+
+// errTooLarge rejects an input bigger than its op's registered MaxArea.
+type errTooLarge struct {
+	op      string
+	size    image.Point
+	maxArea int64
+}
+
+func (e *errTooLarge) Error() string {
+	return fmt.Sprintf("%dx%d image exceeds the %d pixel limit for %s", e.size.X, e.size.Y, e.maxArea, e.op)
+}
+
+// checkArea rejects data when its dimensions exceed op's MaxArea. It reads
+// only the header, so oversized inputs are refused before a full decode;
+// headers it can't parse are left for decodeSource to report.
+func checkArea(data []byte, op string) error {
+	maxArea := ops.MaxArea(op)
+	if maxArea <= 0 {
+		return nil
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxArea {
+		return &errTooLarge{op: op, size: image.Pt(cfg.Width, cfg.Height), maxArea: maxArea}
+	}
+	return nil
+}
+
+// webpChunk is a single RIFF chunk inside a WebP container.
+type webpChunk struct {
+	fourCC string
+	data   []byte
+}
+
+// webpChunks splits a WebP file into its top-level chunks.
+func webpChunks(data []byte) ([]webpChunk, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errors.New("not a webp container")
+	}
+	return riffChunks(data[12:])
+}
+
+func riffChunks(b []byte) ([]webpChunk, error) {
+	var out []webpChunk
+	for len(b) >= 8 {
+		size := int(binary.LittleEndian.Uint32(b[4:8]))
+		if size < 0 || 8+size > len(b) {
+			return nil, errors.New("truncated chunk")
+		}
+		out = append(out, webpChunk{fourCC: string(b[0:4]), data: b[8 : 8+size]})
+		b = b[8+size:]
+		if size%2 == 1 && len(b) > 0 { // chunks are padded to even sizes
+			b = b[1:]
+		}
+	}
+	return out, nil
+}
+
+// isAnimatedWebP reports whether data is a WebP with the VP8X animation flag.
+func isAnimatedWebP(data []byte) bool {
+	chunks, err := webpChunks(data)
+	if err != nil || len(chunks) == 0 || chunks[0].fourCC != "VP8X" || len(chunks[0].data) < 1 {
+		return false
+	}
+	return chunks[0].data[0]&0x02 != 0
+}
+
+// firstWebPFrame rebuilds a still WebP from the first ANMF frame of an
+// animated one so the standard decoder can read it.
+func firstWebPFrame(data []byte) ([]byte, error) {
+	chunks, err := webpChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range chunks {
+		if c.fourCC != "ANMF" {
+			continue
+		}
+		if len(c.data) < 16 {
+			return nil, errors.New("short frame header")
+		}
+		// frame width/height are stored minus one, as is the VP8X canvas
+		w := int(c.data[6]) | int(c.data[7])<<8 | int(c.data[8])<<16
+		h := int(c.data[9]) | int(c.data[10])<<8 | int(c.data[11])<<16
+		frame, err := riffChunks(c.data[16:])
+		if err != nil {
+			return nil, err
+		}
+		var body bytes.Buffer
+		hasAlpha := false
+		for _, f := range frame {
+			if f.fourCC == "ALPH" {
+				hasAlpha = true
+			}
+		}
+		if hasAlpha {
+			// ALPH requires an extended header describing the canvas.
+			vp8x := make([]byte, 10)
+			vp8x[0] = 0x10
+			putUint24(vp8x[4:], w)
+			putUint24(vp8x[7:], h)
+			writeChunk(&body, "VP8X", vp8x)
+		}
+		for _, f := range frame {
+			writeChunk(&body, f.fourCC, f.data)
+		}
+		var out bytes.Buffer
+		out.WriteString("RIFF")
+		_ = binary.Write(&out, binary.LittleEndian, uint32(4+body.Len()))
+		out.WriteString("WEBP")
+		out.Write(body.Bytes())
+		return out.Bytes(), nil
+	}
+	return nil, errors.New("no frames")
+}
+
+func writeChunk(b *bytes.Buffer, fourCC string, data []byte) {
+	b.WriteString(fourCC)
+	_ = binary.Write(b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+	if len(data)%2 == 1 {
+		b.WriteByte(0)
+	}
+}
+
+func putUint24(b []byte, v int) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
@@ -0,0 +1,92 @@
+package actors
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"testing"
+
+	"example.com/image-factory/pkg/messages"
+)
+
+// testdata/cmyk.jpg is a four-channel Adobe JPEG and cmyk.png a reference
+// RGB decode of it, both from the Go tree's image/testdata. The reference
+// came from a different IDCT, so pixels are compared with some slack.
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func decodeTestdata(t *testing.T, name string) image.Image {
+	t.Helper()
+	img, err := decodeSource(readTestdata(t, name), AnimatedFirstFrame, false)
+	if err != nil {
+		t.Fatalf("decode %s: %v", name, err)
+	}
+	return img
+}
+
+// assertSameRGB fails unless got(x, y) matches want(at(x, y)) to within a
+// few levels on every channel.
+func assertSameRGB(t *testing.T, got, want image.Image, at func(x, y int) (int, int)) {
+	t.Helper()
+	b := got.Bounds()
+	if b.Size() != want.Bounds().Size() {
+		t.Fatalf("size %v, want %v", b.Size(), want.Bounds().Size())
+	}
+	near := func(a, b uint32) bool { return a>>8 <= b>>8+8 && b>>8 <= a>>8+8 }
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			wx, wy := at(x, y)
+			r, g, bl, _ := got.At(x, y).RGBA()
+			wr, wg, wb, _ := want.At(wx, wy).RGBA()
+			if !near(r, wr) || !near(g, wg) || !near(bl, wb) {
+				t.Fatalf("pixel (%d,%d) = %v, want %v", x, y, got.At(x, y), want.At(wx, wy))
+			}
+		}
+	}
+}
+
+func TestDecodeSourceCMYK(t *testing.T) {
+	if _, ok := decodeTestdata(t, "cmyk.png").(*image.CMYK); ok {
+		t.Fatal("reference decoded as CMYK")
+	}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(readTestdata(t, "cmyk.jpg"))); err != nil || cfg.ColorModel != color.CMYKModel {
+		t.Fatalf("fixture is not a CMYK JPEG: %v", err)
+	}
+	got := decodeTestdata(t, "cmyk.jpg")
+	if got.ColorModel() != color.NRGBAModel {
+		t.Fatalf("decoded to %T, want RGB", got)
+	}
+	assertSameRGB(t, got, decodeTestdata(t, "cmyk.png"), func(x, y int) (int, int) { return x, y })
+}
+
+func TestDoTransformCMYK(t *testing.T) {
+	// PNG output keeps the comparison lossless.
+	path, _, err := transform(t, &Worker{}, readTestdata(t, "cmyk.jpg"),
+		&messages.TransformTask{Op: "flip_h", Format: "png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, _, err := image.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got.(*image.CMYK); ok {
+		t.Fatal("output is CMYK")
+	}
+	want := decodeTestdata(t, "cmyk.png")
+	w := want.Bounds().Dx()
+	assertSameRGB(t, got, want, func(x, y int) (int, int) { return w - 1 - x, y })
+}
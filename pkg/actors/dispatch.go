@@ -0,0 +1,299 @@
+package actors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"example.com/image-factory/pkg/messages"
+	"example.com/image-factory/pkg/tracing"
+	"github.com/lytics/grid/v3"
+	etcdv3 "go.etcd.io/etcd/client/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	transformUpdatesMailbox = "transform-updates"
+	deadLetterMailbox       = "dead-letter"
+	dispatchTimeout         = 10 * time.Second
+	defaultDiscoveryTimeout = 5 * time.Second
+	// defaultTransformTimeout outlasts a worker's default ProcessTimeout
+	// (2m), so the worker's own timeout answers first.
+	defaultTransformTimeout = 2*time.Minute + 15*time.Second
+	defaultRetryBackoff     = 500 * time.Millisecond
+	maxRetryBackoff         = 30 * time.Second
+)
+
+// dispatches tracks the in-flight dispatch for each (image, variant).
+// Starting a new one for the same key cancels the old, so a re-upload or
+// reprocess doesn't race a stale retry loop.
+type dispatches struct {
+	mu sync.Mutex
+	m  map[string]*dispatchEntry
+}
+
+type dispatchEntry struct {
+	op     string
+	cancel context.CancelFunc
+}
+
+func (d *dispatches) start(ctx context.Context, imageID, op, variant string) (context.Context, func()) {
+	key := imageID + "/" + variant
+	ctx, cancel := context.WithCancel(ctx)
+	e := &dispatchEntry{op: op, cancel: cancel}
+	d.mu.Lock()
+	if d.m == nil {
+		d.m = make(map[string]*dispatchEntry)
+	}
+	if old, ok := d.m[key]; ok {
+		old.cancel()
+	}
+	d.m[key] = e
+	d.mu.Unlock()
+	return ctx, func() {
+		cancel()
+		d.mu.Lock()
+		if d.m[key] == e {
+			delete(d.m, key)
+		}
+		d.mu.Unlock()
+	}
+}
+
+// pending counts the in-flight dispatches by op.
+func (d *dispatches) pending() map[string]int32 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make(map[string]int32)
+	for _, e := range d.m {
+		out[e.op]++
+	}
+	return out
+}
+
+// ErrNoWorkers means discovery found no mailbox for the op.
+var ErrNoWorkers = errors.New("no workers registered")
+
+// Timeouts SendTask distinguishes: looking up the op's workers in etcd, and
+// waiting for the chosen worker to return its result.
+var (
+	ErrDispatchTimeout  = errors.New("dispatch timed out")
+	ErrTransformTimeout = errors.New("transform timed out")
+)
+
+// dispatch sends task to one of its op's workers, retrying failures and
+// timeouts with exponential backoff. Workers report successes and final
+// failures to the API themselves; dispatch only reports when the last
+// attempt got no answer at all.
+func (c *Coordinator) dispatch(ctx context.Context, client *grid.Client, task *messages.TransformTask) {
+	variant := task.GetOp()
+	if v := task.GetVariant(); v != "" {
+		variant = v
+	}
+	ctx, done := c.inflight.start(ctx, task.GetImageId(), task.GetOp(), variant)
+	defer done()
+	ctx, span := tracing.Start(ctx, "dispatch", trace.WithAttributes(
+		attribute.String("image_id", task.GetImageId()),
+		attribute.String("op", task.GetOp()),
+	))
+	defer func() {
+		span.SetAttributes(attribute.Int("attempts", int(task.GetAttempt())))
+		span.End()
+	}()
+	task.TraceId, task.SpanId = tracing.IDs(ctx)
+
+	maxAttempts := int32(c.MaxRetries) + 1
+	backoff := c.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	for attempt := int32(1); ; attempt++ {
+		task.Attempt = attempt
+		task.MaxAttempts = maxAttempts
+		res, err := c.send(ctx, client, task)
+		if errors.Is(err, ErrNoWorkers) && c.Local != nil {
+			c.log.Warn("no workers, transforming locally", "image_id", task.GetImageId(), "op", task.GetOp(), "attempt", attempt)
+			res, err = c.Local.transformLocal(ctx, task), nil
+		}
+		switch {
+		case errors.Is(err, ErrNoWorkers):
+			c.log.Warn("no workers, failing task", "image_id", task.GetImageId(), "op", task.GetOp())
+			span.SetStatus(codes.Error, err.Error())
+			c.reportExhausted(client, task, err)
+			c.deadLetter(client, task, err)
+			return
+		case err == nil && res.GetSuccess():
+			return
+		case err == nil && permanent(res):
+			// retrying can't help, and the worker already reported it
+			return
+		case err == nil && attempt >= maxAttempts:
+			// the worker already reported the final failure
+			span.SetStatus(codes.Error, res.GetError())
+			c.deadLetter(client, task, errors.New(res.GetError()))
+			return
+		}
+		if ctx.Err() != nil {
+			// superseded by a newer dispatch, or shutting down
+			return
+		}
+		if err == nil {
+			err = errors.New(res.GetError())
+		}
+		if errors.Is(err, ErrDispatchTimeout) || errors.Is(err, ErrTransformTimeout) {
+			c.log.Warn("timed out", "image_id", task.GetImageId(), "op", task.GetOp(), "attempt", attempt, "err", err)
+		}
+		if attempt >= maxAttempts {
+			c.log.Error("task exhausted retries", "image_id", task.GetImageId(), "op", task.GetOp(), "attempts", attempt, "err", err)
+			span.SetStatus(codes.Error, err.Error())
+			c.reportExhausted(client, task, err)
+			c.deadLetter(client, task, err)
+			return
+		}
+		c.log.Warn("attempt failed, retrying", "image_id", task.GetImageId(), "op", task.GetOp(), "attempt", attempt, "backoff", backoff, "err", err)
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+}
+
+// send discovers the op's workers and sends task to one of them, chosen
+// by WorkerSelection.
+func (c *Coordinator) send(ctx context.Context, client *grid.Client, task *messages.TransformTask) (*messages.TransformResult, error) {
+	discovery := c.DispatchTimeout
+	if discovery <= 0 {
+		discovery = defaultDiscoveryTimeout
+	}
+	transform := c.TransformTimeout
+	if transform <= 0 {
+		transform = defaultTransformTimeout
+	}
+	var pick picker
+	switch c.WorkerSelection {
+	case SelectRoundRobin, SelectFewestOutstanding:
+		pick = func(op string, members []string) (string, func()) {
+			return c.balance.pick(c.WorkerSelection, op, members)
+		}
+	}
+	return sendTask(ctx, c.Etcd, c.Namespace, client, task, discovery, transform, pick)
+}
+
+// picker chooses the mailbox a task goes to among its op's workers, and
+// returns a func to call once the worker has answered.
+type picker func(op string, members []string) (mailbox string, done func())
+
+// SendTask discovers the workers registered for task's op and broadcasts it
+// to the fastest one, returning that worker's result. Besides the
+// coordinator, requeueing workers and the API's on-the-fly renders use it,
+// with the default timeouts.
+func SendTask(ctx context.Context, etcd *etcdv3.Client, namespace string, client *grid.Client, task *messages.TransformTask) (*messages.TransformResult, error) {
+	return sendTask(ctx, etcd, namespace, client, task, defaultDiscoveryTimeout, defaultTransformTimeout, nil)
+}
+
+// sendTask is SendTask with discovery bounded by discovery and the wait for
+// the worker's result by transform. Running out of either returns
+// ErrDispatchTimeout or ErrTransformTimeout. With pick set, the task goes
+// to the one worker it picks instead of racing all of them.
+func sendTask(ctx context.Context, etcd *etcdv3.Client, namespace string, client *grid.Client, task *messages.TransformTask, discovery, transform time.Duration, pick picker) (*messages.TransformResult, error) {
+	ctxd, cancel := context.WithTimeout(ctx, discovery)
+	defer cancel()
+	// Discover worker mailboxes for this op from etcd
+	prefix := fmt.Sprintf("/%s/workers/%s/", namespace, task.GetOp())
+	resp, err := etcd.Get(ctxd, prefix, etcdv3.WithPrefix())
+	if err != nil && ctx.Err() == nil && errors.Is(ctxd.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("%w: discovery took over %s", ErrDispatchTimeout, discovery)
+	}
+	members := []string{}
+	if err == nil {
+		for _, kv := range resp.Kvs {
+			mbox := string(kv.Key)
+			// Extract mailbox name from key suffix
+			if idx := len(prefix); idx <= len(mbox) {
+				members = append(members, mbox[idx:])
+			}
+		}
+	}
+	if len(members) == 0 {
+		return nil, ErrNoWorkers
+	}
+	ctxb, cancelb := context.WithTimeout(ctx, transform)
+	defer cancelb()
+	timedOut := func(err error) error {
+		if ctx.Err() == nil && errors.Is(ctxb.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w: no result within %s", ErrTransformTimeout, transform)
+		}
+		return err
+	}
+	if pick != nil {
+		mailbox, done := pick(task.GetOp(), members)
+		defer done()
+		resp, err := client.RequestC(ctxb, mailbox, task)
+		if err != nil {
+			return nil, timedOut(err)
+		}
+		if res, ok := messages.AsTransformResult(resp); ok {
+			return res, nil
+		}
+		return nil, timedOut(errors.New("no result from worker"))
+	}
+	grp := grid.NewListGroup(members...)
+	results, err := client.BroadcastC(ctxb, grp.Fastest(), task)
+	if err != nil {
+		return nil, timedOut(err)
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			return nil, timedOut(r.Err)
+		}
+		if res, ok := messages.AsTransformResult(r.Val); ok {
+			return res, nil
+		}
+	}
+	return nil, timedOut(errors.New("no result from worker"))
+}
+
+// reportExhausted tells the API a task gave up without a final worker
+// result, e.g. because every attempt timed out or no worker serves the op.
+func (c *Coordinator) reportExhausted(client *grid.Client, task *messages.TransformTask, cause error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dispatchTimeout)
+	defer cancel()
+	_, err := client.RequestC(ctx, transformUpdatesMailbox, &messages.TransformResult{
+		ImageId:   task.GetImageId(),
+		Op:        task.GetOp(),
+		Variant:   task.GetVariant(),
+		Error:     cause.Error(),
+		Exhausted: true,
+		Attempts:  task.GetAttempt(),
+	})
+	if err != nil {
+		c.log.Warn("report exhausted", "image_id", task.GetImageId(), "op", task.GetOp(), "err", err)
+	}
+}
+
+// deadLetter parks a task that used up its retries where operators can
+// inspect and re-dispatch it.
+func (c *Coordinator) deadLetter(client *grid.Client, task *messages.TransformTask, cause error) {
+	parked := proto.Clone(task).(*messages.TransformTask)
+	parked.Original = nil
+	ctx, cancel := context.WithTimeout(context.Background(), dispatchTimeout)
+	defer cancel()
+	_, err := client.RequestC(ctx, deadLetterMailbox, &messages.DeadLetter{
+		Task:           parked,
+		Error:          cause.Error(),
+		Attempts:       task.GetAttempt(),
+		FailedAtUnixMs: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		c.log.Warn("dead-letter", "image_id", task.GetImageId(), "op", task.GetOp(), "err", err)
+	}
+}
@@ -0,0 +1,102 @@
+package actors
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chai2010/webp"
+	"github.com/disintegration/imaging"
+)
+
+// flatten composites img over a solid bg so transparent areas don't turn
+// black in formats without alpha. imaging.Paste would copy the alpha
+// channel verbatim, so this blends with Overlay instead.
+func flatten(img *image.NRGBA, bg color.Color) *image.NRGBA {
+	if img.Opaque() {
+		return img
+	}
+	b := img.Bounds()
+	dst := imaging.New(b.Dx(), b.Dy(), bg)
+	return imaging.Overlay(dst, img, image.Pt(0, 0), 1.0)
+}
+
+// hasAlpha reports whether the format at path keeps an alpha channel.
+func hasAlpha(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg":
+		return false
+	}
+	return true
+}
+
+// saveImage encodes img to dst, choosing the encoder from dst's extension,
+// and embeds xmp when given and the format carries it. imaging covers
+// jpeg/png/gif/tiff/bmp; WebP goes through libwebp. effort picks the PNG
+// compression level; the libwebp binding has no method knob and JPEG's
+// cost doesn't vary, so other formats ignore it.
+func saveImage(img image.Image, dst string, quality, effort int, xmp []byte) error {
+	ext := strings.ToLower(filepath.Ext(dst))
+	opts := []imaging.EncodeOption{imaging.JPEGQuality(quality), imaging.PNGCompressionLevel(pngCompression(effort))}
+	var buf bytes.Buffer
+	format := "webp"
+	if ext == ".webp" {
+		if err := webp.Encode(&buf, img, &webp.Options{Quality: float32(quality)}); err != nil {
+			return err
+		}
+	} else {
+		f, err := imaging.FormatFromExtension(ext)
+		if err != nil {
+			return err
+		}
+		if err := imaging.Encode(&buf, img, f, opts...); err != nil {
+			return err
+		}
+		format = strings.ToLower(f.String())
+	}
+	data, err := embedXMP(buf.Bytes(), format, img.Bounds().Size(), xmp)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dst, data)
+}
+
+// writeFileAtomic writes data to a temp file beside dst and renames it into
+// place, so readers never see a half-written variant even if the process
+// dies mid-write.
+func writeFileAtomic(dst string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// pngCompression maps an effort level onto zlib's speed/size presets.
+func pngCompression(effort int) png.CompressionLevel {
+	switch {
+	case effort <= 3:
+		return png.BestSpeed
+	case effort >= 7:
+		return png.BestCompression
+	}
+	return png.DefaultCompression
+}
@@ -0,0 +1,44 @@
+package actors
+
+import "time"
+
+// defaultIdempotencyTTL is how long the coordinator remembers an upload
+// event when IdempotencyTTL is unset.
+const defaultIdempotencyTTL = 10 * time.Minute
+
+// seenEvents remembers the upload events the coordinator has dispatched
+// for ttl, so a redelivered or resent event doesn't run its ops twice.
+// Every entry lives the same ttl, so they expire in the order they were
+// added. It is only used from the Act loop and isn't safe for concurrent
+// use.
+type seenEvents struct {
+	ttl   time.Duration
+	at    map[string]time.Time
+	order []string
+}
+
+func newSeenEvents(ttl time.Duration) *seenEvents {
+	if ttl <= 0 {
+		ttl = defaultIdempotencyTTL
+	}
+	return &seenEvents{ttl: ttl, at: make(map[string]time.Time)}
+}
+
+// first records key and reports whether it is new, i.e. not seen within
+// the last ttl.
+func (s *seenEvents) first(key string, now time.Time) bool {
+	for len(s.order) > 0 {
+		k := s.order[0]
+		if now.Sub(s.at[k]) < s.ttl {
+			break
+		}
+		delete(s.at, k)
+		s.order = s.order[1:]
+	}
+	if _, ok := s.at[key]; ok {
+		return false
+	}
+	s.at[key] = now
+	s.order = append(s.order, key)
+	return true
+}
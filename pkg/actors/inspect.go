@@ -0,0 +1,157 @@
+package actors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// inspection is the JSON the inspect op stores for an original.
+type inspection struct {
+	Width    int               `json:"width"`
+	Height   int               `json:"height"`
+	Format   string            `json:"format"`
+	HasAlpha bool              `json:"has_alpha"`
+	Bytes    int               `json:"bytes"`
+	EXIF     map[string]string `json:"exif,omitempty"`
+}
+
+// inspect describes an original from its header alone, without decoding
+// the pixels, and returns the JSON.
+func inspect(data []byte) ([]byte, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported or corrupt image: %w", err)
+	}
+	return json.Marshal(inspection{
+		Width:    cfg.Width,
+		Height:   cfg.Height,
+		Format:   format,
+		HasAlpha: modelHasAlpha(cfg.ColorModel),
+		Bytes:    len(data),
+		EXIF:     jpegEXIF(data),
+	})
+}
+
+// modelHasAlpha reports whether pixels in color model m can be translucent.
+func modelHasAlpha(m color.Model) bool {
+	switch m {
+	case color.GrayModel, color.Gray16Model, color.YCbCrModel, color.CMYKModel:
+		return false
+	}
+	if p, ok := m.(color.Palette); ok {
+		for _, c := range p {
+			if _, _, _, a := c.RGBA(); a != 0xffff {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// exifTags are the EXIF fields inspect reports, by tag number.
+var exifTags = map[uint16]string{
+	0x010f: "make",
+	0x0110: "model",
+	0x0112: "orientation",
+	0x0131: "software",
+	0x0132: "date_time",
+	0x9003: "date_time_original",
+}
+
+// exifIFDPointer is the IFD0 tag holding the offset of the Exif sub-IFD.
+const exifIFDPointer = 0x8769
+
+// jpegEXIF reads the exifTags from a JPEG's APP1 segment, or returns nil
+// when it has none it can parse.
+func jpegEXIF(data []byte) map[string]string {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xda || n < 2 || i+2+n > len(data) {
+			break // start of scan: no more metadata
+		}
+		seg := data[i+4 : i+2+n]
+		if marker == 0xe1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return parseTIFF(seg[6:])
+		}
+		i += 2 + n
+	}
+	return nil
+}
+
+// parseTIFF walks IFD0 and the Exif sub-IFD of a TIFF-structured block.
+func parseTIFF(b []byte) map[string]string {
+	if len(b) < 8 {
+		return nil
+	}
+	var bo binary.ByteOrder
+	switch string(b[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return nil
+	}
+	out := map[string]string{}
+	sub := readIFD(b, bo, bo.Uint32(b[4:]), out)
+	if sub != 0 {
+		readIFD(b, bo, sub, out)
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// readIFD adds the known ASCII and SHORT entries of the IFD at off to out,
+// returning the Exif sub-IFD offset when the IFD points to one.
+func readIFD(b []byte, bo binary.ByteOrder, off uint32, out map[string]string) (sub uint32) {
+	if int(off)+2 > len(b) {
+		return 0
+	}
+	count := int(bo.Uint16(b[off:]))
+	for i := 0; i < count; i++ {
+		e := int(off) + 2 + i*12
+		if e+12 > len(b) {
+			break
+		}
+		tag, typ, n := bo.Uint16(b[e:]), bo.Uint16(b[e+2:]), bo.Uint32(b[e+4:])
+		val := b[e+8 : e+12]
+		if tag == exifIFDPointer && typ == 4 {
+			sub = bo.Uint32(val)
+			continue
+		}
+		name, ok := exifTags[tag]
+		if !ok {
+			continue
+		}
+		switch typ {
+		case 2: // ASCII, inline when it fits in 4 bytes
+			if n > 4 {
+				p := bo.Uint32(val)
+				if uint64(p)+uint64(n) > uint64(len(b)) {
+					continue
+				}
+				val = b[p : p+n]
+			} else {
+				val = val[:n]
+			}
+			if s := strings.TrimSpace(strings.TrimRight(string(val), "\x00")); s != "" {
+				out[name] = s
+			}
+		case 3: // SHORT
+			out[name] = fmt.Sprint(bo.Uint16(val))
+		}
+	}
+	return sub
+}
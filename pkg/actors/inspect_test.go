@@ -0,0 +1,198 @@
+package actors
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"maps"
+	"testing"
+)
+
+// exifField is one IFD entry for buildTIFF: an ASCII value when s is set,
+// else a SHORT.
+type exifField struct {
+	tag   uint16
+	s     string
+	short uint16
+}
+
+// buildTIFF lays out a TIFF block the way cameras write it: the header,
+// IFD0, then the Exif sub-IFD when sub is non-empty, then the ASCII values
+// too long to sit inline.
+func buildTIFF(bo binary.AppendByteOrder, ifd0, sub []exifField) []byte {
+	b := []byte("II")
+	if bo == binary.BigEndian {
+		b = []byte("MM")
+	}
+	b = bo.AppendUint16(b, 42)
+	b = bo.AppendUint32(b, 8)
+
+	ifdSize := func(n int) int { return 2 + 12*n + 4 }
+	n0 := len(ifd0)
+	if len(sub) > 0 {
+		n0++
+	}
+	subOff := 8 + ifdSize(n0)
+	dataOff := subOff
+	if len(sub) > 0 {
+		dataOff += ifdSize(len(sub))
+	}
+	var data []byte
+	entry := func(b []byte, f exifField) []byte {
+		b = bo.AppendUint16(b, f.tag)
+		if f.s == "" {
+			b = bo.AppendUint16(b, 3)
+			b = bo.AppendUint32(b, 1)
+			b = bo.AppendUint16(b, f.short)
+			return append(b, 0, 0)
+		}
+		v := f.s + "\x00"
+		b = bo.AppendUint16(b, 2)
+		b = bo.AppendUint32(b, uint32(len(v)))
+		if len(v) <= 4 {
+			return append(b, (v + "\x00\x00\x00")[:4]...)
+		}
+		b = bo.AppendUint32(b, uint32(dataOff+len(data)))
+		data = append(data, v...)
+		return b
+	}
+
+	b = bo.AppendUint16(b, uint16(n0))
+	for _, f := range ifd0 {
+		b = entry(b, f)
+	}
+	if len(sub) > 0 {
+		b = bo.AppendUint16(b, exifIFDPointer)
+		b = bo.AppendUint16(b, 4)
+		b = bo.AppendUint32(b, 1)
+		b = bo.AppendUint32(b, uint32(subOff))
+	}
+	b = bo.AppendUint32(b, 0) // no next IFD
+	if len(sub) > 0 {
+		b = bo.AppendUint16(b, uint16(len(sub)))
+		for _, f := range sub {
+			b = entry(b, f)
+		}
+		b = bo.AppendUint32(b, 0)
+	}
+	return append(b, data...)
+}
+
+// withEXIF returns jpg with an APP0 segment and then an EXIF APP1 segment
+// holding tiff inserted after its SOI, as a camera lays them out.
+func withEXIF(jpg, tiff []byte) []byte {
+	app0 := []byte{0xff, 0xe0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 1, 0, 0, 1, 0, 1, 0, 0}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := binary.BigEndian.AppendUint16([]byte{0xff, 0xe1}, uint16(2+len(payload)))
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, app0...)
+	out = append(out, app1...)
+	out = append(out, payload...)
+	return append(out, jpg[2:]...)
+}
+
+var cameraIFD0 = []exifField{
+	{tag: 0x010f, s: "Canon"},
+	{tag: 0x0110, s: "Canon EOS 5D Mark IV"},
+	{tag: 0x0112, short: 6},
+	{tag: 0x0132, s: "2026:03:01 10:20:30"},
+}
+
+var cameraSub = []exifField{
+	{tag: 0x9003, s: "2026:03:01 10:20:29"},
+	{tag: 0x829a, s: "1/250"}, // exposure time: not reported
+}
+
+var cameraEXIF = map[string]string{
+	"make":               "Canon",
+	"model":              "Canon EOS 5D Mark IV",
+	"orientation":        "6",
+	"date_time":          "2026:03:01 10:20:30",
+	"date_time_original": "2026:03:01 10:20:29",
+}
+
+func TestJPEGEXIF(t *testing.T) {
+	jpg := encodeFixture(t, 16, 16, false)
+	le := buildTIFF(binary.LittleEndian, cameraIFD0, cameraSub)
+	be := buildTIFF(binary.BigEndian, cameraIFD0, cameraSub)
+
+	// badASCII points model's value past the end of the block.
+	badASCII := buildTIFF(binary.LittleEndian, []exifField{{tag: 0x010f, s: "Nikon"}, {tag: 0x0110, s: "Nikon D850"}}, nil)
+	binary.LittleEndian.PutUint32(badASCII[8+2+12+8:], 0xfffffff0)
+	// selfSub points the Exif sub-IFD back at IFD0.
+	selfSub := buildTIFF(binary.BigEndian, []exifField{{tag: 0x010f, s: "Sony"}}, []exifField{{tag: 0x9003, s: "x"}})
+	binary.BigEndian.PutUint32(selfSub[8+2+12+8:], 8)
+	// hugeCount claims 65535 entries in an IFD that holds one.
+	hugeCount := buildTIFF(binary.LittleEndian, []exifField{{tag: 0x0112, short: 3}}, nil)
+	binary.LittleEndian.PutUint16(hugeCount[8:], 0xffff)
+	// farIFD0 puts IFD0 past the end of the block.
+	farIFD0 := buildTIFF(binary.BigEndian, cameraIFD0, nil)
+	binary.BigEndian.PutUint32(farIFD0[4:], 0xffffffff)
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want map[string]string
+	}{
+		{"little-endian", withEXIF(jpg, le), cameraEXIF},
+		{"big-endian", withEXIF(jpg, be), cameraEXIF},
+		{"no exif", jpg, nil},
+		{"not a jpeg", encodeFixture(t, 16, 16, true), nil},
+		{"bad byte order", withEXIF(jpg, append([]byte("XX"), le[2:]...)), nil},
+		{"truncated tiff header", withEXIF(jpg, le[:6]), nil},
+		{"truncated ifd0", withEXIF(jpg, le[:8+2+12*3+6]), map[string]string{"orientation": "6"}},
+		{"ascii out of range", withEXIF(jpg, badASCII), map[string]string{"make": "Nikon"}},
+		{"sub-ifd loops to ifd0", withEXIF(jpg, selfSub), map[string]string{"make": "Sony"}},
+		{"entry count too high", withEXIF(jpg, hugeCount), map[string]string{"orientation": "3"}},
+		{"ifd0 out of range", withEXIF(jpg, farIFD0), nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jpegEXIF(tt.data); !maps.Equal(got, tt.want) {
+				t.Errorf("jpegEXIF = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestJPEGEXIFTruncated cuts a JPEG with EXIF at every length: each prefix
+// must parse without panicking and report no field the full file lacks.
+func TestJPEGEXIFTruncated(t *testing.T) {
+	data := withEXIF(encodeFixture(t, 16, 16, false), buildTIFF(binary.LittleEndian, cameraIFD0, cameraSub))
+	for n := range len(data) {
+		for k, v := range jpegEXIF(data[:n]) {
+			if cameraEXIF[k] != v {
+				t.Fatalf("prefix of %d bytes: %s = %q, want %q", n, k, v, cameraEXIF[k])
+			}
+		}
+	}
+}
+
+func TestInspectJPEGEXIF(t *testing.T) {
+	data := withEXIF(encodeFixture(t, 40, 30, false), buildTIFF(binary.BigEndian, cameraIFD0, cameraSub))
+	b, err := inspect(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got inspection
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Width != 40 || got.Height != 30 || got.Format != "jpeg" || got.HasAlpha {
+		t.Errorf("inspection = %+v, want a 40x30 opaque jpeg", got)
+	}
+	if !maps.Equal(got.EXIF, cameraEXIF) {
+		t.Errorf("exif = %v, want %v", got.EXIF, cameraEXIF)
+	}
+}
+
+// FuzzJPEGEXIF checks the EXIF reader can't panic or spin on hostile input,
+// whether it arrives as a whole JPEG or as a bare TIFF block.
+func FuzzJPEGEXIF(f *testing.F) {
+	jpg := []byte{0xff, 0xd8, 0xff, 0xd9}
+	f.Add(jpg)
+	f.Add(withEXIF(jpg, buildTIFF(binary.LittleEndian, cameraIFD0, cameraSub)))
+	f.Add(withEXIF(jpg, buildTIFF(binary.BigEndian, cameraIFD0, cameraSub)))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		jpegEXIF(data)
+		parseTIFF(data)
+	})
+}
@@ -0,0 +1,33 @@
+package actors
+
+import "context"
+
+// TransformLimit bounds how many transforms run at once across every
+// worker sharing it, so a process hosting several op workers can't decode
+// more large images at a time than it has memory for.
+type TransformLimit struct {
+	slots chan struct{}
+}
+
+// NewTransformLimit returns a limit of n concurrent transforms, or nil (no
+// limit) when n is below 1.
+func NewTransformLimit(n int) *TransformLimit {
+	if n < 1 {
+		return nil
+	}
+	return &TransformLimit{slots: make(chan struct{}, n)}
+}
+
+// acquire waits for a slot and returns its release func. A nil limit
+// never waits.
+func (l *TransformLimit) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,178 @@
+package actors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"hash/crc32"
+	"image"
+	"sort"
+	"strings"
+)
+
+// xmpNS is the custom XMP namespace for metadata keys without a Dublin Core
+// equivalent.
+const xmpNS = "https://example.com/image-factory/ns/1.0/"
+
+// dcAlt maps metadata keys to language-alternative Dublin Core properties.
+var dcAlt = map[string]string{
+	"copyright":   "dc:rights",
+	"title":       "dc:title",
+	"description": "dc:description",
+}
+
+// buildXMP renders metadata as an XMP packet. Well-known keys (copyright,
+// title, description, creator) map to Dublin Core; other keys that are
+// valid XML names go to the imgsvc namespace. It returns nil for no fields.
+func buildXMP(meta map[string]string) []byte {
+	if len(meta) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">`)
+	b.WriteString(`<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:imgsvc="` + xmpNS + `">`)
+	n := 0
+	for _, k := range keys {
+		v := xmlEscape(meta[k])
+		switch {
+		case dcAlt[k] != "":
+			b.WriteString("<" + dcAlt[k] + `><rdf:Alt><rdf:li xml:lang="x-default">` + v + "</rdf:li></rdf:Alt></" + dcAlt[k] + ">")
+		case k == "creator":
+			b.WriteString("<dc:creator><rdf:Seq><rdf:li>" + v + "</rdf:li></rdf:Seq></dc:creator>")
+		case validXMLName(k):
+			b.WriteString("<imgsvc:" + k + ">" + v + "</imgsvc:" + k + ">")
+		default:
+			continue
+		}
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	b.WriteString("</rdf:Description></rdf:RDF></x:xmpmeta>\n<?xpacket end=\"w\"?>")
+	return []byte(b.String())
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func validXMLName(k string) bool {
+	if k == "" {
+		return false
+	}
+	for i, r := range k {
+		letter := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_'
+		if !letter && (i == 0 || !(r >= '0' && r <= '9' || r == '-')) {
+			return false
+		}
+	}
+	return true
+}
+
+// embedXMP inserts an XMP packet into encoded image data. JPEG, PNG, and
+// WebP carry it; other formats are returned unchanged.
+func embedXMP(data []byte, format string, size image.Point, xmp []byte) ([]byte, error) {
+	if len(xmp) == 0 {
+		return data, nil
+	}
+	switch format {
+	case "jpeg":
+		return embedXMPJPEG(data, xmp)
+	case "png":
+		return embedXMPPNG(data, xmp)
+	case "webp":
+		return embedXMPWebP(data, size, xmp)
+	}
+	return data, nil
+}
+
+// embedXMPJPEG adds an APP1 XMP segment after SOI and any JFIF APP0.
+func embedXMPJPEG(data, xmp []byte) ([]byte, error) {
+	const ns = "http://ns.adobe.com/xap/1.0/\x00"
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errors.New("not a jpeg")
+	}
+	if 2+len(ns)+len(xmp) > 0xFFFF {
+		return nil, errors.New("xmp packet too large for a jpeg segment")
+	}
+	at := 2
+	if data[2] == 0xFF && data[3] == 0xE0 && len(data) >= 6 {
+		at = 4 + int(binary.BigEndian.Uint16(data[4:6]))
+		if at > len(data) {
+			return nil, errors.New("truncated APP0")
+		}
+	}
+	var out bytes.Buffer
+	out.Write(data[:at])
+	out.Write([]byte{0xFF, 0xE1})
+	_ = binary.Write(&out, binary.BigEndian, uint16(2+len(ns)+len(xmp)))
+	out.WriteString(ns)
+	out.Write(xmp)
+	out.Write(data[at:])
+	return out.Bytes(), nil
+}
+
+// embedXMPPNG adds an iTXt XML:com.adobe.xmp chunk after IHDR.
+func embedXMPPNG(data, xmp []byte) ([]byte, error) {
+	const sig = "\x89PNG\r\n\x1a\n"
+	if len(data) < 8+25 || string(data[:8]) != sig || string(data[12:16]) != "IHDR" {
+		return nil, errors.New("not a png")
+	}
+	at := 8 + 12 + int(binary.BigEndian.Uint32(data[8:12]))
+	var payload bytes.Buffer
+	payload.WriteString("XML:com.adobe.xmp")
+	payload.Write([]byte{0, 0, 0, 0, 0}) // keyword NUL, uncompressed, language NUL, translated keyword NUL
+	payload.Write(xmp)
+
+	var out bytes.Buffer
+	out.Write(data[:at])
+	_ = binary.Write(&out, binary.BigEndian, uint32(payload.Len()))
+	chunk := append([]byte("iTXt"), payload.Bytes()...)
+	out.Write(chunk)
+	_ = binary.Write(&out, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	out.Write(data[at:])
+	return out.Bytes(), nil
+}
+
+// embedXMPWebP appends an XMP chunk, promoting a simple WebP to the
+// extended (VP8X) layout that metadata requires.
+func embedXMPWebP(data []byte, size image.Point, xmp []byte) ([]byte, error) {
+	chunks, err := webpChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	if len(chunks) > 0 && chunks[0].fourCC == "VP8X" && len(chunks[0].data) >= 10 {
+		vp8x := append([]byte(nil), chunks[0].data...)
+		vp8x[0] |= 0x04
+		writeChunk(&body, "VP8X", vp8x)
+		chunks = chunks[1:]
+	} else {
+		vp8x := make([]byte, 10)
+		vp8x[0] = 0x04
+		putUint24(vp8x[4:], size.X-1)
+		putUint24(vp8x[7:], size.Y-1)
+		writeChunk(&body, "VP8X", vp8x)
+	}
+	for _, c := range chunks {
+		writeChunk(&body, c.fourCC, c.data)
+	}
+	writeChunk(&body, "XMP ", xmp)
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	_ = binary.Write(&out, binary.LittleEndian, uint32(4+body.Len()))
+	out.WriteString("WEBP")
+	out.Write(body.Bytes())
+	return out.Bytes(), nil
+}
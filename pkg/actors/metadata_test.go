@@ -0,0 +1,64 @@
+package actors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"image/jpeg"
+	"os"
+	"testing"
+
+	"example.com/image-factory/pkg/messages"
+)
+
+// jpegXMP returns the XMP packet of a JPEG's APP1 segment, walking the
+// markers the way a reader does rather than searching the bytes.
+func jpegXMP(t *testing.T, data []byte) []byte {
+	t.Helper()
+	const ns = "http://ns.adobe.com/xap/1.0/\x00"
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF && data[i+1] != 0xDA; {
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		seg := data[i+4 : i+2+n]
+		if data[i+1] == 0xE1 && bytes.HasPrefix(seg, []byte(ns)) {
+			return seg[len(ns):]
+		}
+		i += 2 + n
+	}
+	t.Fatal("no XMP segment before the scan")
+	return nil
+}
+
+// xmpRights is the part of a packet that holds dc:rights.
+type xmpRights struct {
+	Rights []string `xml:"RDF>Description>rights>Alt>li"`
+	Source string   `xml:"RDF>Description>source_id"`
+}
+
+func TestDoTransformCopyright(t *testing.T) {
+	w := &Worker{Metadata: map[string]string{"copyright": "© Static Co", "source_id": "cfg"}}
+	path, _, err := transform(t, w, encodeFixture(t, 32, 24, false), &messages.TransformTask{
+		Op: "grayscale",
+		// the upload's own fields override the static ones
+		Metadata: map[string]string{"copyright": "© 2026 Example & Sons"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("output no longer decodes: %v", err)
+	}
+	var got xmpRights
+	if err := xml.Unmarshal(jpegXMP(t, data), &got); err != nil {
+		t.Fatalf("parse XMP: %v", err)
+	}
+	if len(got.Rights) != 1 || got.Rights[0] != "© 2026 Example & Sons" {
+		t.Errorf("dc:rights = %q, want the upload's copyright", got.Rights)
+	}
+	if got.Source != "cfg" {
+		t.Errorf("source_id = %q, want the static %q", got.Source, "cfg")
+	}
+}
@@ -0,0 +1,117 @@
+package actors
+
+import "encoding/binary"
+
+// defaultJPEGQuality is used when the task doesn't set a valid quality.
+const defaultJPEGQuality = 90
+
+// stdLuminanceQuant is the JPEG Annex K luminance table that libjpeg-style
+// encoders scale by quality.
+var stdLuminanceQuant = [64]int{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}
+
+// estimateJPEGQuality inverts libjpeg's quality scaling using the file's
+// luminance quantization table. It returns 0 when data isn't a JPEG or has
+// no luminance table before the first scan.
+func estimateJPEGQuality(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0
+	}
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return 0
+		}
+		marker := data[i+1]
+		if marker == 0xFF { // fill byte
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 { // start of scan / end of image
+			return 0
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if length < 2 || i+2+length > len(data) {
+			return 0
+		}
+		if marker == 0xDB {
+			if q := lumaQualityFromDQT(data[i+4 : i+2+length]); q > 0 {
+				return q
+			}
+		}
+		i += 2 + length
+	}
+	return 0
+}
+
+// lumaQualityFromDQT scans a DQT segment payload for table 0.
+func lumaQualityFromDQT(seg []byte) int {
+	for len(seg) > 0 {
+		precision, id := seg[0]>>4, seg[0]&0x0F
+		size := 64
+		if precision == 1 {
+			size = 128
+		}
+		if len(seg) < 1+size {
+			return 0
+		}
+		if id == 0 {
+			sum := 0
+			for k := 0; k < 64; k++ {
+				if precision == 1 {
+					sum += int(binary.BigEndian.Uint16(seg[1+2*k:]))
+				} else {
+					sum += int(seg[1+k])
+				}
+			}
+			return qualityFromTableSum(sum)
+		}
+		seg = seg[1+size:]
+	}
+	return 0
+}
+
+func qualityFromTableSum(sum int) int {
+	std := 0
+	for _, v := range stdLuminanceQuant {
+		std += v
+	}
+	// libjpeg: table = std*scale/100, scale = 5000/q (q<50) or 200-2q.
+	scale := float64(sum) * 100 / float64(std)
+	var q float64
+	if scale <= 100 {
+		q = (200 - scale) / 2
+	} else {
+		q = 5000 / scale
+	}
+	switch {
+	case q < 1:
+		return 1
+	case q > 100:
+		return 100
+	}
+	return int(q + 0.5)
+}
+
+// outputQuality returns the JPEG quality to encode with: configured, capped
+// at the source's own quality when matching is enabled and it is a JPEG.
+func outputQuality(configured int, src []byte, matchSource bool) int {
+	if configured <= 0 || configured > 100 {
+		configured = defaultJPEGQuality
+	}
+	if !matchSource {
+		return configured
+	}
+	if est := estimateJPEGQuality(src); est > 0 && est < configured {
+		return est
+	}
+	return configured
+}
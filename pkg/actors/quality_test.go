@@ -0,0 +1,79 @@
+package actors
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"testing"
+
+	"example.com/image-factory/pkg/messages"
+)
+
+// encodeJPEG returns a small gradient encoded at quality q.
+func encodeJPEG(t *testing.T, q int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 5), 90, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: q}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestEstimateJPEGQuality(t *testing.T) {
+	// Below about 20 baseline tables clip at 255 and the estimate drifts.
+	for _, q := range []int{20, 30, 50, 75, 90, 100} {
+		// the tables are rounded to integers, so allow a step either way
+		if got := estimateJPEGQuality(encodeJPEG(t, q)); got < q-1 || got > q+1 {
+			t.Errorf("quality %d estimated as %d", q, got)
+		}
+	}
+	if got := estimateJPEGQuality(encodeFixture(t, 8, 8, true)); got != 0 {
+		t.Errorf("PNG estimated as quality %d, want 0", got)
+	}
+}
+
+func TestOutputQuality(t *testing.T) {
+	low, high := encodeJPEG(t, 40), encodeJPEG(t, 95)
+	png := encodeFixture(t, 8, 8, true)
+	for _, tt := range []struct {
+		name       string
+		configured int
+		src        []byte
+		match      bool
+		want       int
+	}{
+		{"low source caps", 85, low, true, 40},
+		{"low source, default quality", 0, low, true, 40},
+		{"high source keeps configured", 85, high, true, 85},
+		{"matching off", 85, low, false, 85},
+		{"not a jpeg", 85, png, true, 85},
+		{"invalid configured", 200, high, true, defaultJPEGQuality},
+	} {
+		if got := outputQuality(tt.configured, tt.src, tt.match); got != tt.want {
+			t.Errorf("%s: outputQuality = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDoTransformMatchSourceQuality(t *testing.T) {
+	w := &Worker{MatchSourceQuality: true}
+	path, _, err := transform(t, w, encodeJPEG(t, 40), &messages.TransformTask{Op: "grayscale", Quality: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := estimateJPEGQuality(out); got < 39 || got > 41 {
+		t.Errorf("quality-40 source encoded at %d, want it capped at 40", got)
+	}
+}
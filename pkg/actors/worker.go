@@ -5,26 +5,100 @@ import (
 	"errors"
 	"fmt"
 	"image"
-	"log"
+	"image/color"
+	"io/fs"
+	"log/slog"
+	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/disintegration/imaging"
+	"example.com/image-factory/pkg/imageops"
+	"example.com/image-factory/pkg/messages"
+	"example.com/image-factory/pkg/ops"
+	"example.com/image-factory/pkg/storage"
+	"example.com/image-factory/pkg/tracing"
 	"github.com/lytics/grid/v3"
 	etcdv3 "go.etcd.io/etcd/client/v3"
-	"google.golang.org/protobuf/types/known/structpb"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Handling for tasks routed to a worker of another op.
+const (
+	UnsupportedFail    = "fail"
+	UnsupportedRequeue = "requeue"
+)
+
+// drainQuiet is how long a draining worker keeps handing off tasks that
+// arrive after it deregistered, from senders that discovered it before.
+const drainQuiet = time.Second
+
+// defaultLeaseTTL applies when Worker.LeaseTTL is unset.
+const defaultLeaseTTL = 10 * time.Second
+
+// defaultMailboxSize is the mailbox buffer used when a Worker's or
+// Coordinator's MailboxSize is unset.
+const defaultMailboxSize = 100
+
 // Worker performs image transformations for a specific operation.
 type Worker struct {
 	Server      *grid.Server
 	Etcd        *etcdv3.Client
 	Namespace   string
 	SupportedOp string
+	// AnimatedWebP selects how animated WebP originals are handled:
+	// AnimatedFirstFrame (default) or AnimatedReject.
+	AnimatedWebP string
+	// MatchSourceQuality caps JPEG output quality at the estimated quality
+	// of a JPEG original, so low-quality sources aren't re-encoded larger.
+	MatchSourceQuality bool
+	// Unsupported selects what happens to a task for another op:
+	// UnsupportedFail (default) or UnsupportedRequeue.
+	Unsupported string
+	// Metadata is embedded as XMP in every JPEG/PNG/WebP output; a task's
+	// own metadata overrides matching keys.
+	Metadata map[string]string
+	// Background is the "#rrggbb" color transparency is flattened onto
+	// for JPEG output, unless the task sets its own; default white.
+	Background string
+	// WatermarkLogo is overlaid by watermark tasks that carry no text.
+	WatermarkLogo image.Image
+	// PreserveAlpha writes a variant whose result has transparency as PNG
+	// when the op would default to JPEG, instead of flattening it onto
+	// Background. Tasks with an explicit format are unaffected.
+	PreserveAlpha bool
+	// Concurrency is how many tasks the actor transforms at once; values
+	// below 1 mean one.
+	Concurrency int
+	// MailboxSize is how many tasks the mailbox buffers before senders
+	// block; 0 means 100.
+	MailboxSize int
+	// ProcessTimeout abandons a transform that runs longer, failing the
+	// attempt with reason FailureTimeout; 0 means no limit.
+	ProcessTimeout time.Duration
+	// LeaseTTL is how long the worker's discovery key outlives a crash
+	// before etcd drops it; 0 means 10s.
+	LeaseTTL time.Duration
+	// Store, when set, supplies originals that are neither inline in the
+	// task nor present at the task's path on this host.
+	Store storage.Store
+	// Limit, when set, is shared by every worker in the process and caps
+	// their transforms running at once; a task waits for a slot before
+	// its original is decoded, and the wait doesn't count against
+	// ProcessTimeout.
+	Limit *TransformLimit
+
+	log *slog.Logger
 }
 
 func (w *Worker) Act(ctx context.Context) {
 	name, _ := grid.ContextActorName(ctx)
-	log.Printf("[worker %s] starting (op=%s)", name, w.SupportedOp)
+	w.log = slog.With("component", "worker", "actor", name, "op", w.SupportedOp)
+	w.log.Info("starting")
 
 	mailboxName := "worker"
 	if w.SupportedOp != "" {
@@ -33,24 +107,24 @@ func (w *Worker) Act(ctx context.Context) {
 
 	// Announce start
 	if c, err := grid.NewClient(w.Etcd, grid.ClientCfg{Namespace: w.Namespace}); err == nil {
-		if msg, _ := structpb.NewStruct(map[string]any{"event": "worker_start", "name": name, "op": w.SupportedOp, "mailbox": mailboxName}); msg != nil {
-			c.RequestC(context.Background(), "system-events", msg)
-		}
+		msg := &messages.SystemEvent{Event: "worker_start", Name: name, Op: w.SupportedOp, Mailbox: mailboxName}
+		c.RequestC(context.Background(), "system-events", msg)
 		c.Close()
 	}
-	// Register in etcd for coordinator discovery
+	// Register in etcd for coordinator discovery, under a lease so the key
+	// goes away if this process dies without deregistering
 	key := fmt.Sprintf("/%s/workers/%s/%s", w.Namespace, w.SupportedOp, mailboxName)
-	_, _ = w.Etcd.Put(context.Background(), key, "")
+	deregister := w.register(ctx, key)
 
-	mb, err := w.Server.NewMailbox(mailboxName, 100)
+	mb, err := w.Server.NewMailbox(mailboxName, mailboxSize(w.MailboxSize))
 	if err != nil {
 		if errors.Is(err, grid.ErrAlreadyRegistered) {
-			log.Printf("worker: mailbox %s already registered on this peer; another worker is running. exiting.", mailboxName)
-			_, _ = w.Etcd.Delete(context.Background(), key)
+			w.log.Warn("mailbox already registered on this peer; another worker is running, exiting", "mailbox", mailboxName)
+			deregister()
 			return
 		}
-		log.Printf("worker: cannot create mailbox: %v", err)
-		_, _ = w.Etcd.Delete(context.Background(), key)
+		w.log.Error("cannot create mailbox", "mailbox", mailboxName, "err", err)
+		deregister()
 		return
 	}
 	defer mb.Close()
@@ -58,85 +132,528 @@ func (w *Worker) Act(ctx context.Context) {
 	defer func() {
 		// Announce stop and deregister
 		if c, err := grid.NewClient(w.Etcd, grid.ClientCfg{Namespace: w.Namespace}); err == nil {
-			if msg, _ := structpb.NewStruct(map[string]any{"event": "worker_stop", "name": name, "op": w.SupportedOp, "mailbox": mailboxName}); msg != nil {
-				c.RequestC(context.Background(), "system-events", msg)
-			}
+			msg := &messages.SystemEvent{Event: "worker_stop", Name: name, Op: w.SupportedOp, Mailbox: mailboxName}
+			c.RequestC(context.Background(), "system-events", msg)
 			c.Close()
 		}
-		_, _ = w.Etcd.Delete(context.Background(), key)
+		deregister()
 	}()
 
+	// Concurrency goroutines drain the mailbox; Act, and with it the
+	// deferred stop announcement, returns once they all have. A
+	// messages.StopEvent or DrainEvent ends them early, leaving tasks
+	// already running to finish.
+	loop, stop := context.WithCancel(ctx)
+	defer stop()
+	n := max(1, w.Concurrency)
+	var wg sync.WaitGroup
+	var draining atomic.Bool
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-loop.Done():
+					return
+				case req := <-mb.C():
+					if ev, ok := messages.AsSystemEvent(req.Msg()); ok && (ev.GetEvent() == messages.StopEvent || ev.GetEvent() == messages.DrainEvent) {
+						w.log.Info("stop requested", "event", ev.GetEvent())
+						// stop being discovered before the loops wind down
+						deregister()
+						if ev.GetEvent() == messages.DrainEvent {
+							draining.Store(true)
+						}
+						_ = req.Ack()
+						stop()
+						return
+					}
+					w.handle(ctx, name, req)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if draining.Load() {
+		w.handOff(ctx, mb)
+	}
+	w.log.Info("exiting")
+}
+
+// handOff forwards the tasks left in a draining worker's mailbox, and any
+// that arrive until it has been quiet for drainQuiet, to the op's other
+// workers, relaying their answers. It returns once every forwarded task
+// has been answered.
+func (w *Worker) handOff(ctx context.Context, mb grid.Mailbox) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	quiet := time.NewTimer(drainQuiet)
+	defer quiet.Stop()
+	handed := 0
+	defer func() { w.log.Info("drained", "handed_off", handed) }()
 	for {
 		select {
 		case <-ctx.Done():
-			log.Printf("worker exiting")
+			return
+		case <-quiet.C:
 			return
 		case req := <-mb.C():
-			task, ok := req.Msg().(*structpb.Struct)
+			task, ok := messages.AsTransformTask(req.Msg())
 			if !ok {
 				_ = req.Ack()
 				continue
 			}
-			imageID := task.GetFields()["image_id"].GetStringValue()
-			op := task.GetFields()["op"].GetStringValue()
-			if w.SupportedOp != "" && op != w.SupportedOp {
-				// Wrong queue; ack and ignore
-				_ = req.Ack()
-				continue
+			handed++
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				w.forward(ctx, req, task, "worker draining")
+			}()
+			quiet.Reset(drainQuiet)
+		}
+	}
+}
+
+// register puts the worker's discovery key, valued with its RFC 3339
+// registration time, under a lease kept alive until ctx ends, granting a
+// new one if the old lapses (say, across an etcd outage longer than the
+// TTL). The returned func deletes the key and revokes the lease.
+func (w *Worker) register(ctx context.Context, key string) func() {
+	ttl := w.LeaseTTL
+	if ttl <= 0 {
+		ttl = defaultLeaseTTL
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	var mu sync.Mutex
+	var lease etcdv3.LeaseID
+	registered := time.Now().UTC().Format(time.RFC3339)
+	grant := func() (<-chan *etcdv3.LeaseKeepAliveResponse, error) {
+		l, err := w.Etcd.Grant(ctx, int64(max(1, ttl/time.Second)))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Etcd.Put(ctx, key, registered, etcdv3.WithLease(l.ID)); err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		lease = l.ID
+		mu.Unlock()
+		return w.Etcd.KeepAlive(ctx, l.ID)
+	}
+	alive, err := grant()
+	if err != nil {
+		w.log.Warn("lease grant failed, retrying", "key", key, "err", err)
+	}
+	go func() {
+		for {
+			if alive != nil {
+				for range alive {
+				}
 			}
-			log.Printf("[worker %s] received task: %s %s", name, imageID, op)
-
-			// Determine paths
-			baseDir := filepath.Dir(task.GetFields()["path"].GetStringValue())
-			original := task.GetFields()["path"].GetStringValue()
-			variantPath := filepath.Join(baseDir, op+".jpg")
-
-			// Perform transform
-			success := true
-			if err := w.doTransform(original, variantPath, op); err != nil {
-				log.Printf("worker transform error: %v", err)
-				success = false
+			if ctx.Err() != nil {
+				return
 			}
+			if alive != nil {
+				w.log.Warn("lease lapsed, re-registering", "key", key)
+			}
+			t := time.NewTimer(ttl / 3)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return
+			}
+			if alive, err = grant(); err != nil {
+				w.log.Warn("lease grant failed, retrying", "key", key, "err", err)
+				alive = nil
+			}
+		}
+	}()
+	return sync.OnceFunc(func() {
+		cancel()
+		dctx, dcancel := context.WithTimeout(context.Background(), dispatchTimeout)
+		defer dcancel()
+		_, _ = w.Etcd.Delete(dctx, key)
+		mu.Lock()
+		id := lease
+		mu.Unlock()
+		if id != 0 {
+			_, _ = w.Etcd.Revoke(dctx, id)
+		}
+	})
+}
+
+// handle runs one mailbox request to completion and answers it.
+func (w *Worker) handle(ctx context.Context, name string, req grid.Request) {
+	task, ok := messages.AsTransformTask(req.Msg())
+	if !ok {
+		_ = req.Ack()
+		return
+	}
+	if w.SupportedOp != "" && task.GetOp() != w.SupportedOp {
+		w.unsupported(ctx, req, task)
+		return
+	}
+	w.log.Info("received task", "image_id", task.GetImageId(), "task_op", task.GetOp())
+	w.finish(req, task, w.process(ctx, task))
+}
 
-			result, _ := structpb.NewStruct(map[string]any{
-				"image_id": imageID,
-				"op":       op,
-				"success":  success,
-				"path":     variantPath,
-			})
+// transformLocal runs task in the calling goroutine rather than from a
+// mailbox, reporting a final result to the API as handle would. The
+// coordinator uses it when no worker serves the task's op.
+func (w *Worker) transformLocal(ctx context.Context, task *messages.TransformTask) *messages.TransformResult {
+	result := w.process(ctx, task)
+	if settle(task, result) {
+		w.report(result)
+	}
+	return result
+}
 
-			// Respond to coordinator
-			_ = req.Respond(result)
+// process transforms task and describes the outcome.
+func (w *Worker) process(ctx context.Context, task *messages.TransformTask) *messages.TransformResult {
+	imageID := task.GetImageId()
+	op := task.GetOp()
 
-			// Also send to transform-updates mailbox so API can pick it up (success or failure)
-			if upd, err := grid.NewClient(w.Etcd, grid.ClientCfg{Namespace: w.Namespace}); err == nil {
-				upd.RequestC(context.Background(), "transform-updates", result)
-				upd.Close()
-			}
+	// Determine paths; the op's registered default applies when the task has no format
+	baseDir := filepath.Dir(task.GetPath())
+	format := ops.OutputFormat(op, task.GetFormat())
+	variant := op
+	if v := task.GetVariant(); v != "" {
+		variant = v
+	}
+	variantPath := filepath.Join(baseDir, variant+ops.Extension(format))
+
+	// Perform transform
+	success := true
+	reason, category := "", ""
+	started := time.Now()
+	// a task that has started runs to completion even if the
+	// actor is stopping, so shutdown never leaves it half-done
+	tctx, span := tracing.Start(tracing.Remote(context.WithoutCancel(ctx), task.GetTraceId(), task.GetSpanId()), "transform",
+		trace.WithAttributes(
+			attribute.String("image_id", imageID),
+			attribute.String("op", op),
+			attribute.String("variant", variant),
+			attribute.Int("attempt", int(task.GetAttempt())),
+		))
+	defer span.End()
+	variantPath, size, err := w.transformTask(tctx, task, variantPath)
+	if err != nil {
+		w.log.Error("transform failed", "image_id", imageID, "variant", variant, "err", err)
+		span.SetStatus(codes.Error, err.Error())
+		success = false
+		reason = err.Error()
+		var tooLarge *errTooLarge
+		switch {
+		case errors.As(err, &tooLarge):
+			category = FailureTooLarge
+		case errors.Is(err, errProcessTimeout):
+			category = FailureTimeout
+		case errors.Is(err, errImageGone):
+			category = FailureGone
+		}
+	}
+
+	traceID, spanID := tracing.IDs(tctx)
+	return &messages.TransformResult{
+		ImageId:    imageID,
+		Op:         op,
+		Success:    success,
+		Path:       variantPath,
+		Error:      reason,
+		Reason:     category,
+		Variant:    task.GetVariant(),
+		DurationMs: time.Since(started).Milliseconds(),
+		Width:      int32(size.X),
+		Height:     int32(size.Y),
+		TraceId:    traceID,
+		SpanId:     spanID,
+	}
+}
+
+// finish responds to the coordinator and, unless the coordinator will retry
+// the failure, reports the result to the API.
+func (w *Worker) finish(req grid.Request, task *messages.TransformTask, result *messages.TransformResult) {
+	final := settle(task, result)
+	// Respond to coordinator
+	_ = req.Respond(result)
+	if final {
+		w.report(result)
+	}
+}
+
+// settle reports whether result is final, marking a last failed attempt
+// exhausted. A failure the coordinator will retry isn't final.
+func settle(task *messages.TransformTask, result *messages.TransformResult) bool {
+	success := result.GetSuccess()
+	final := success || permanent(result) || task.GetAttempt() >= task.GetMaxAttempts()
+	if !success && final && task.GetMaxAttempts() > 1 {
+		result.Exhausted = true
+		result.Attempts = task.GetAttempt()
+	}
+	return final
+}
+
+// report sends a final result to the transform-updates mailbox so the API
+// can pick it up (success or failure).
+func (w *Worker) report(result *messages.TransformResult) {
+	if upd, err := grid.NewClient(w.Etcd, grid.ClientCfg{Namespace: w.Namespace}); err == nil {
+		upd.RequestC(context.Background(), "transform-updates", result)
+		upd.Close()
+	}
+}
+
+// TransformResult reasons: FailureTooLarge for an input over its op's
+// MaxArea, FailureTimeout for a transform abandoned after ProcessTimeout,
+// FailureGone for an image deleted before its task ran.
+const (
+	FailureTooLarge = "too_large"
+	FailureTimeout  = "timeout"
+	FailureGone     = "gone"
+)
+
+// errProcessTimeout means a transform outran Worker.ProcessTimeout.
+var errProcessTimeout = errors.New("transform timed out")
+
+// errImageGone means the task's image directory no longer exists.
+var errImageGone = errors.New("image directory gone")
+
+// permanent reports whether a failed result would fail the same way on
+// retry, so the coordinator shouldn't bother. A timed-out transform is
+// still running in the background, so a retry would only add another, and
+// a deleted image stays deleted.
+func permanent(result *messages.TransformResult) bool {
+	if result.GetSuccess() {
+		return false
+	}
+	switch result.GetReason() {
+	case FailureTooLarge, FailureTimeout, FailureGone:
+		return true
+	}
+	return false
+}
+
+// unsupported handles a task for another op. With UnsupportedRequeue it is
+// forwarded to that op's workers and their answer relayed; otherwise, or if
+// forwarding fails, it fails like any other transform so the miss is
+// recorded instead of vanishing.
+func (w *Worker) unsupported(ctx context.Context, req grid.Request, task *messages.TransformTask) {
+	reason := fmt.Sprintf("worker for %s cannot run %s", w.SupportedOp, task.GetOp())
+	if w.Unsupported != UnsupportedRequeue {
+		w.log.Warn("unsupported task", "image_id", task.GetImageId(), "task_op", task.GetOp(), "reason", reason)
+		w.fail(req, task, reason)
+		return
+	}
+	go w.forward(ctx, req, task, reason)
+}
+
+// forward sends task on to its op's workers and relays their answer. If
+// that fails, the task fails with reason, so the coordinator can retry it
+// or record the miss.
+func (w *Worker) forward(ctx context.Context, req grid.Request, task *messages.TransformTask, reason string) {
+	client, err := grid.NewClient(w.Etcd, grid.ClientCfg{Namespace: w.Namespace})
+	if err != nil {
+		w.log.Warn("forward failed", "image_id", task.GetImageId(), "task_op", task.GetOp(), "err", err)
+		w.fail(req, task, reason+": requeue: "+err.Error())
+		return
+	}
+	defer client.Close()
+	res, err := SendTask(ctx, w.Etcd, w.Namespace, client, task)
+	if err != nil {
+		w.log.Warn("forward failed", "image_id", task.GetImageId(), "task_op", task.GetOp(), "err", err)
+		w.fail(req, task, reason+": requeue: "+err.Error())
+		return
+	}
+	// the worker that ran it has already reported to the API
+	_ = req.Respond(res)
+}
+
+// fail answers task with a failed result carrying reason.
+func (w *Worker) fail(req grid.Request, task *messages.TransformTask, reason string) {
+	w.finish(req, task, &messages.TransformResult{
+		ImageId: task.GetImageId(),
+		Op:      task.GetOp(),
+		Error:   reason,
+	})
+}
+
+// transformTask loads the task's original, writes the op's variant to dst
+// (or, when it keeps transparency, a PNG beside it), and returns where it
+// went and the variant's dimensions.
+func (w *Worker) transformTask(ctx context.Context, task *messages.TransformTask, dst string) (string, image.Point, error) {
+	data, err := w.loadSource(ctx, task)
+	if err != nil {
+		return dst, image.Point{}, err
+	}
+	// the API creates the image's directory on upload and removes it on
+	// delete; a task outliving its image mustn't recreate it
+	if _, err := os.Stat(filepath.Dir(dst)); errors.Is(err, fs.ErrNotExist) {
+		return dst, image.Point{}, errImageGone
+	} else if err != nil {
+		return dst, image.Point{}, err
+	}
+	out, err := w.outputFor(task)
+	if err != nil {
+		return dst, image.Point{}, err
+	}
+	release, err := w.Limit.acquire(ctx)
+	if err != nil {
+		return dst, image.Point{}, err
+	}
+	if w.ProcessTimeout <= 0 {
+		defer release()
+		return w.doTransform(data, dst, task.GetOp(), out, task.GetParams().AsMap())
+	}
+	// imaging can't be interrupted, so run it aside and stop waiting at the
+	// deadline; an abandoned transform finishes in the background, and its
+	// atomic write can't leave a torn file
+	type result struct {
+		path string
+		size image.Point
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		// an abandoned transform keeps its slot until it really ends
+		defer release()
+		path, size, err := w.doTransform(data, dst, task.GetOp(), out, task.GetParams().AsMap())
+		done <- result{path, size, err}
+	}()
+	ctx, cancel := context.WithTimeout(ctx, w.ProcessTimeout)
+	defer cancel()
+	select {
+	case r := <-done:
+		return r.path, r.size, r.err
+	case <-ctx.Done():
+		w.log.Warn("transform timed out", "image_id", task.GetImageId(), "task_op", task.GetOp(), "timeout", w.ProcessTimeout)
+		return dst, image.Point{}, fmt.Errorf("%w after %s", errProcessTimeout, w.ProcessTimeout)
+	}
+}
+
+// outputOptions are the encode settings for one task.
+type outputOptions struct {
+	quality    int
+	effort     int
+	autoOrient bool
+	xmp        []byte
+	background color.NRGBA
+	// keepAlpha writes transparent results as PNG rather than flattening
+	// them into a JPEG
+	keepAlpha bool
+}
+
+// outputFor resolves a task's encode settings against the worker defaults.
+func (w *Worker) outputFor(task *messages.TransformTask) (outputOptions, error) {
+	out := outputOptions{
+		quality:    int(task.GetQuality()),
+		effort:     ops.Effort(task.GetOp()),
+		autoOrient: task.AutoOrient == nil || task.GetAutoOrient(),
+		xmp:        w.xmpFor(task),
+		background: color.NRGBA{255, 255, 255, 255},
+		// an explicitly requested format always wins
+		keepAlpha: w.PreserveAlpha && task.GetFormat() == "",
+	}
+	if e := int(task.GetEffort()); e != 0 {
+		if !ops.ValidEffort(e) {
+			return out, fmt.Errorf("effort must be %d-%d, got %d", ops.MinEffort, ops.MaxEffort, e)
 		}
+		out.effort = e
+	}
+	bg := w.Background
+	if task.GetBackground() != "" {
+		bg = task.GetBackground()
+	}
+	if bg != "" {
+		c, ok := ops.ParseColor(bg)
+		if !ok {
+			return out, fmt.Errorf("invalid background color %q", bg)
+		}
+		out.background = c
+	}
+	return out, nil
+}
+
+// xmpFor builds the XMP packet for a task from the static and per-upload
+// metadata, or nil when there is none.
+func (w *Worker) xmpFor(task *messages.TransformTask) []byte {
+	if len(w.Metadata) == 0 && len(task.GetMetadata()) == 0 {
+		return nil
+	}
+	meta := make(map[string]string, len(w.Metadata)+len(task.GetMetadata()))
+	for k, v := range w.Metadata {
+		meta[k] = v
+	}
+	for k, v := range task.GetMetadata() {
+		meta[k] = v
+	}
+	return buildXMP(meta)
+}
+
+// loadSource returns the original: inline bytes when the coordinator sent
+// them, else the file at the task's path, else the store's copy.
+func (w *Worker) loadSource(ctx context.Context, task *messages.TransformTask) ([]byte, error) {
+	if data := task.GetOriginal(); len(data) > 0 {
+		return data, nil
+	}
+	data, err := os.ReadFile(task.GetPath())
+	if err == nil || w.Store == nil {
+		return data, err
 	}
+	data, _, serr := w.Store.GetOriginal(ctx, task.GetImageId())
+	if serr != nil {
+		return nil, fmt.Errorf("read original: %v; store: %w", err, serr)
+	}
+	return data, nil
 }
 
-func (w *Worker) doTransform(src, dst, op string) error {
-	img, err := imaging.Open(src)
+func (w *Worker) doTransform(data []byte, dst, op string, out outputOptions, params map[string]any) (string, image.Point, error) {
+	if op == ops.InspectOp {
+		js, err := inspect(data)
+		if err == nil {
+			err = writeFileAtomic(dst, js)
+		}
+		return dst, image.Point{}, err
+	}
+	if err := checkArea(data, op); err != nil {
+		return dst, image.Point{}, err
+	}
+	img, err := decodeSource(data, w.AnimatedWebP, out.autoOrient)
+	if err != nil {
+		return dst, image.Point{}, err
+	}
+	if op == "watermark" && w.WatermarkLogo != nil {
+		if _, ok := params[imageops.LogoParam]; !ok {
+			params[imageops.LogoParam] = w.WatermarkLogo
+		}
+	}
+	outImg, err := imageops.Apply(img, op, params)
 	if err != nil {
-		return err
-	}
-	var outImg *image.NRGBA
-	switch op {
-	case "thumbnail":
-		outImg = imaging.Thumbnail(img, 200, 200, imaging.Lanczos)
-	case "grayscale":
-		outImg = imaging.Grayscale(img)
-	case "blur":
-		outImg = imaging.Blur(img, 3.0)
-	case "rotate90":
-		outImg = imaging.Rotate90(img)
-	default:
-		return fmt.Errorf("unknown op %s", op)
-	}
-	if err := imaging.Save(outImg, dst); err != nil {
-		return err
-	}
-	return nil
+		return dst, image.Point{}, err
+	}
+	var stale string
+	if !hasAlpha(dst) {
+		if out.keepAlpha && !outImg.Opaque() {
+			stale = dst
+			dst = strings.TrimSuffix(dst, filepath.Ext(dst)) + ops.Extension("png")
+		} else {
+			outImg = flatten(outImg, out.background)
+		}
+	}
+	quality := outputQuality(out.quality, data, w.MatchSourceQuality)
+	if err := saveImage(outImg, dst, quality, out.effort, out.xmp); err != nil {
+		return dst, image.Point{}, err
+	}
+	if stale != "" {
+		// drop any flattened copy an earlier run left behind
+		_ = os.Remove(stale)
+	}
+	return dst, outImg.Bounds().Size(), nil
+}
+
+// mailboxSize returns n, or defaultMailboxSize when it is unset.
+func mailboxSize(n int) int {
+	if n <= 0 {
+		return defaultMailboxSize
+	}
+	return n
 }
@@ -0,0 +1,356 @@
+package actors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"example.com/image-factory/pkg/messages"
+	"example.com/image-factory/pkg/ops"
+	"example.com/image-factory/pkg/storage"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// encodeFixture returns a w x h gradient encoded as JPEG, or as PNG with a
+// transparent left half when transparent is set.
+func encodeFixture(t *testing.T, w, h int, transparent bool) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			a := uint8(255)
+			if transparent && x < w/2 {
+				a = 0
+			}
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 255 / w), uint8(y * 255 / h), 90, a})
+		}
+	}
+	var buf bytes.Buffer
+	var err error
+	if transparent {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// transform runs doTransform for task the way a worker would, without a
+// grid or etcd, and returns the written path and the decoded output's size.
+func transform(t *testing.T, w *Worker, data []byte, task *messages.TransformTask) (string, image.Point, error) {
+	t.Helper()
+	out, err := w.outputFor(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(t.TempDir(), task.GetOp()+ops.Extension(ops.OutputFormat(task.GetOp(), task.GetFormat())))
+	return w.doTransform(data, dst, task.GetOp(), out, task.GetParams().AsMap())
+}
+
+func mustStruct(t *testing.T, m map[string]any) *structpb.Struct {
+	t.Helper()
+	s, err := structpb.NewStruct(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func decodedSize(t *testing.T, path string) image.Point {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		t.Fatalf("decode %s: %v", path, err)
+	}
+	return image.Pt(cfg.Width, cfg.Height)
+}
+
+func TestDoTransformDimensions(t *testing.T) {
+	data := encodeFixture(t, 640, 480, false)
+	tests := []struct {
+		op    string
+		check func(image.Point) bool
+		want  string
+	}{
+		{"thumbnail", func(p image.Point) bool { return p.X <= 200 && p.Y <= 200 }, "within 200x200"},
+		{"grayscale", func(p image.Point) bool { return p == image.Pt(640, 480) }, "640x480"},
+		{"blur", func(p image.Point) bool { return p == image.Pt(640, 480) }, "640x480"},
+		{"rotate90", func(p image.Point) bool { return p == image.Pt(480, 640) }, "480x640"},
+		{"sharpen", func(p image.Point) bool { return p == image.Pt(640, 480) }, "640x480"},
+		{"flip_h", func(p image.Point) bool { return p == image.Pt(640, 480) }, "640x480"},
+		{"flip_v", func(p image.Point) bool { return p == image.Pt(640, 480) }, "640x480"},
+		{"sepia", func(p image.Point) bool { return p == image.Pt(640, 480) }, "640x480"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.op, func(t *testing.T) {
+			path, size, err := transform(t, &Worker{}, data, &messages.TransformTask{Op: tt.op})
+			if err != nil {
+				t.Fatalf("%s: %v", tt.op, err)
+			}
+			if !tt.check(size) {
+				t.Errorf("%s reported %v, want %s", tt.op, size, tt.want)
+			}
+			if got := decodedSize(t, path); got != size {
+				t.Errorf("%s wrote %v, reported %v", tt.op, got, size)
+			}
+		})
+	}
+}
+
+func TestDoTransformParams(t *testing.T) {
+	data := encodeFixture(t, 640, 480, false)
+	task := &messages.TransformTask{Op: "resize"}
+	task.Params = mustStruct(t, map[string]any{"width": 320})
+	path, size, err := transform(t, &Worker{}, data, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != image.Pt(320, 240) || decodedSize(t, path) != size {
+		t.Errorf("resize to width 320 gave %v", size)
+	}
+}
+
+func TestDoTransformUnknownOp(t *testing.T) {
+	data := encodeFixture(t, 64, 48, false)
+	if _, _, err := transform(t, &Worker{}, data, &messages.TransformTask{Op: "posterize"}); err == nil || !strings.Contains(err.Error(), "unknown op") {
+		t.Errorf("unknown op: err = %v, want unknown op", err)
+	}
+}
+
+func TestDoTransformCorruptInput(t *testing.T) {
+	inputs := map[string][]byte{
+		"empty":     nil,
+		"garbage":   []byte("definitely not an image"),
+		"truncated": encodeFixture(t, 64, 48, false)[:100],
+	}
+	for name, data := range inputs {
+		t.Run(name, func(t *testing.T) {
+			path, _, err := transform(t, &Worker{}, data, &messages.TransformTask{Op: "thumbnail"})
+			if err == nil {
+				t.Fatal("corrupt input transformed without error")
+			}
+			if _, statErr := os.Stat(path); statErr == nil {
+				t.Errorf("corrupt input left %s behind", path)
+			}
+		})
+	}
+}
+
+func TestDoTransformPreserveAlpha(t *testing.T) {
+	data := encodeFixture(t, 64, 48, true)
+	path, _, err := transform(t, &Worker{PreserveAlpha: true}, data, &messages.TransformTask{Op: "grayscale"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Ext(path) != ".png" {
+		t.Errorf("transparent result written to %s, want a .png", path)
+	}
+	path, _, err = transform(t, &Worker{}, data, &messages.TransformTask{Op: "grayscale"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Ext(path) != ".jpg" {
+		t.Errorf("flattened result written to %s, want a .jpg", path)
+	}
+}
+
+func TestDoTransformFlattenBackground(t *testing.T) {
+	data := encodeFixture(t, 64, 48, true)
+	for _, tt := range []struct {
+		name   string
+		worker *Worker
+		task   *messages.TransformTask
+		want   color.NRGBA
+	}{
+		{"default white", &Worker{}, &messages.TransformTask{Op: "grayscale"}, color.NRGBA{255, 255, 255, 255}},
+		{"worker background", &Worker{Background: "#204080"}, &messages.TransformTask{Op: "grayscale"}, color.NRGBA{0x20, 0x40, 0x80, 255}},
+		{"task background", &Worker{Background: "#204080"}, &messages.TransformTask{Op: "grayscale", Background: "#ffffff"}, color.NRGBA{255, 255, 255, 255}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path, _, err := transform(t, tt.worker, data, tt.task)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			img, err := jpeg.Decode(f)
+			if err != nil {
+				t.Fatalf("decode %s: %v", path, err)
+			}
+			// The fixture's left half is transparent.
+			near := func(a uint32, b uint8) bool { return a>>8 <= uint32(b)+8 && uint32(b) <= a>>8+8 }
+			b := img.Bounds()
+			for _, p := range []image.Point{b.Min, {b.Min.X, b.Max.Y - 1}} {
+				r, g, bl, _ := img.At(p.X, p.Y).RGBA()
+				if !near(r, tt.want.R) || !near(g, tt.want.G) || !near(bl, tt.want.B) {
+					t.Errorf("corner %v = %v, want %v", p, img.At(p.X, p.Y), tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDoTransformInspect(t *testing.T) {
+	data := encodeFixture(t, 64, 48, true)
+	path, _, err := transform(t, &Worker{}, data, &messages.TransformTask{Op: ops.InspectOp, Format: "webp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Ext(path) != ".json" {
+		t.Errorf("inspection written to %s, want a .json", path)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got inspection
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("inspection isn't JSON: %v", err)
+	}
+	want := inspection{Width: 64, Height: 48, Format: "png", HasAlpha: true, Bytes: len(data)}
+	if got.Width != want.Width || got.Height != want.Height || got.Format != want.Format || got.HasAlpha != want.HasAlpha || got.Bytes != want.Bytes {
+		t.Errorf("inspection = %+v, want %+v", got, want)
+	}
+}
+
+func TestProcessOutputFormat(t *testing.T) {
+	data := encodeFixture(t, 64, 48, false)
+	for _, tt := range []struct {
+		format, wantExt, wantFormat string
+	}{
+		// no format: the op's registered default
+		{"", ops.Extension(ops.OutputFormat("grayscale", "")), "jpeg"},
+		// a requested one overrides it
+		{"png", ".png", "png"},
+		{"GIF", ".gif", "gif"},
+	} {
+		t.Run("format="+tt.format, func(t *testing.T) {
+			w := &Worker{log: slog.Default()}
+			task := &messages.TransformTask{
+				ImageId:  "img",
+				Op:       "grayscale",
+				Path:     filepath.Join(t.TempDir(), "original.jpg"),
+				Format:   tt.format,
+				Original: data,
+			}
+			res := w.process(context.Background(), task)
+			if !res.GetSuccess() {
+				t.Fatalf("process failed: %s", res.GetError())
+			}
+			if ext := filepath.Ext(res.GetPath()); ext != tt.wantExt {
+				t.Errorf("wrote %s, want a %s", res.GetPath(), tt.wantExt)
+			}
+			f, err := os.Open(res.GetPath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if _, got, err := image.DecodeConfig(f); err != nil || got != tt.wantFormat {
+				t.Errorf("output decodes as %q (%v), want %q", got, err, tt.wantFormat)
+			}
+		})
+	}
+}
+
+// TestProcessDeletedImage runs a task whose image was deleted before it
+// ran: it fails for good and leaves no directory behind.
+func TestProcessDeletedImage(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "img")
+	w := &Worker{log: slog.Default()}
+	res := w.process(context.Background(), &messages.TransformTask{
+		ImageId:  "img",
+		Op:       "grayscale",
+		Path:     filepath.Join(dir, "original.jpg"),
+		Original: encodeFixture(t, 16, 16, false),
+	})
+	if res.GetSuccess() || res.GetReason() != FailureGone {
+		t.Fatalf("result = %v, want a %q failure", res, FailureGone)
+	}
+	if _, err := os.Stat(dir); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("image dir stat = %v, want it still gone", err)
+	}
+}
+
+func TestLoadSource(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	onDisk := filepath.Join(dir, "original.jpg")
+	if err := os.WriteFile(onDisk, []byte("disk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	st, err := storage.NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SaveOriginal(ctx, "img", ".jpg", []byte("store")); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "gone", "original.jpg")
+	for _, tt := range []struct {
+		name  string
+		task  *messages.TransformTask
+		store storage.Store
+		want  string
+	}{
+		// small originals ride along in the task and win over the path
+		{"inline", &messages.TransformTask{ImageId: "img", Path: onDisk, Original: []byte("inline")}, st, "inline"},
+		{"inline, nothing else", &messages.TransformTask{ImageId: "img", Path: missing, Original: []byte("inline")}, nil, "inline"},
+		// large ones are read by reference: the path, else the store
+		{"path", &messages.TransformTask{ImageId: "img", Path: onDisk}, st, "disk"},
+		{"store", &messages.TransformTask{ImageId: "img", Path: missing}, st, "store"},
+		{"nowhere", &messages.TransformTask{ImageId: "img", Path: missing}, nil, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &Worker{Store: tt.store}
+			got, err := w.loadSource(ctx, tt.task)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("loaded %q, want an error", got)
+				}
+				return
+			}
+			if err != nil || string(got) != tt.want {
+				t.Fatalf("loaded %q (%v), want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	for _, tt := range []struct {
+		result *messages.TransformResult
+		want   bool
+	}{
+		{&messages.TransformResult{Success: true}, false},
+		{&messages.TransformResult{Error: "decode: unexpected EOF"}, false},
+		{&messages.TransformResult{Error: "too big", Reason: FailureTooLarge}, true},
+		{&messages.TransformResult{Error: "timed out", Reason: FailureTimeout}, true},
+		{&messages.TransformResult{Error: "image directory gone", Reason: FailureGone}, true},
+	} {
+		if got := permanent(tt.result); got != tt.want {
+			t.Errorf("permanent(%v) = %v, want %v", tt.result, got, tt.want)
+		}
+	}
+}
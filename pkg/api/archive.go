@@ -0,0 +1,134 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// archiveEntry reads one file of an image's ZIP, returning its name in the
+// archive. Entries are read only as they're written.
+type archiveEntry func(ctx context.Context) (name string, r io.ReadCloser, err error)
+
+// GET /images/{id}/archive.zip streams the original and every variant as a
+// ZIP. Entries come from the store when one is configured, else from disk,
+// and are written one at a time so the archive is never held in memory.
+func (s *Server) handleArchive(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	entries, err := s.archiveEntries(r.Context(), id)
+	if err != nil {
+		s.log.Error("archive", "image_id", id, "err", err)
+		writeJSONError(w, http.StatusServiceUnavailable, codeUnavailable, "store unavailable")
+		return
+	}
+	if len(entries) == 0 {
+		writeNotFound(w, "image not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, id))
+	flusher, _ := w.(http.Flusher)
+	zw := zip.NewWriter(w)
+	for _, open := range entries {
+		name, rc, err := open(r.Context())
+		if err != nil {
+			// the headers are gone; skip it rather than cut the archive short
+			s.log.Warn("archive: skipping entry", "image_id", id, "entry", name, "err", err)
+			continue
+		}
+		// images are already compressed, so store them as-is
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err == nil {
+			_, err = io.Copy(f, rc)
+		}
+		rc.Close()
+		if err == nil && flusher != nil {
+			// send each entry as it's written rather than when the
+			// response buffer happens to fill
+			if err = zw.Flush(); err == nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			s.log.Warn("archive: write entry", "image_id", id, "entry", name, "err", err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		s.log.Warn("archive: close", "image_id", id, "err", err)
+	}
+}
+
+// archiveEntries lists what goes in an image's archive: the original first,
+// then variants by name.
+func (s *Server) archiveEntries(ctx context.Context, id string) ([]archiveEntry, error) {
+	if s.Store == nil {
+		return diskArchiveEntries(s.imageDir(id)), nil
+	}
+	names, err := s.Store.ListOps(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	original := func(ctx context.Context) (string, io.ReadCloser, error) {
+		data, ext, err := s.Store.GetOriginal(ctx, id)
+		if err != nil {
+			return "original", nil, err
+		}
+		return "original" + ext, io.NopCloser(bytes.NewReader(data)), nil
+	}
+	if len(names) == 0 {
+		// nothing stored but perhaps the original; else try a local copy
+		name, rc, err := original(ctx)
+		if err != nil {
+			return diskArchiveEntries(s.imageDir(id)), nil
+		}
+		return []archiveEntry{func(context.Context) (string, io.ReadCloser, error) { return name, rc, nil }}, nil
+	}
+	entries := []archiveEntry{original}
+	sort.Strings(names)
+	for _, op := range names {
+		entries = append(entries, func(ctx context.Context) (string, io.ReadCloser, error) {
+			data, ct, err := s.getVariant(ctx, id, op)
+			if err != nil {
+				return op, nil, err
+			}
+			return op + extForContentType(ct), io.NopCloser(bytes.NewReader(data)), nil
+		})
+	}
+	return entries, nil
+}
+
+// diskArchiveEntries lists the files in an image's directory, skipping
+// hidden ones such as in-progress writes.
+func diskArchiveEntries(dir string) []archiveEntry {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var original, variants []archiveEntry
+	for _, f := range files {
+		if !f.Type().IsRegular() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		name := f.Name()
+		e := func(context.Context) (string, io.ReadCloser, error) {
+			rc, err := os.Open(filepath.Join(dir, name))
+			return name, rc, err
+		}
+		if strings.HasPrefix(name, "original.") {
+			original = append(original, e)
+		} else {
+			variants = append(variants, e)
+		}
+	}
+	return append(original, variants...)
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// flushRecorder notes how much of the body had been written at each Flush.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushedAt []int
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushedAt = append(r.flushedAt, r.Body.Len())
+	r.ResponseRecorder.Flush()
+}
+
+func TestArchiveStreamsEntries(t *testing.T) {
+	s := newTestServer(t, nil)
+	id := testImageID(1)
+	writeVariants(t, s, id, "original", "thumbnail", "grayscale")
+
+	rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	s.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/images/"+id+"/archive.zip", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	body := rec.Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if want := []string{"original.jpg", "grayscale.jpg", "thumbnail.jpg"}; !slices.Equal(names, want) {
+		t.Errorf("entries %v, want %v", names, want)
+	}
+	// Each entry reaches the client before the next is read.
+	if len(rec.flushedAt) != len(names) || !slices.IsSorted(rec.flushedAt) || rec.flushedAt[0] == 0 || rec.flushedAt[len(names)-1] == len(body) {
+		t.Errorf("flushed at %v of %d bytes, want once after each of %d entries", rec.flushedAt, len(body), len(names))
+	}
+}
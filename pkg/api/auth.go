@@ -0,0 +1,70 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// authorize gates requests on API keys. Mutating requests, everything
+// under /admin/, and /events?upload_id= (an upload's own progress) need one
+// of APIKeys; other requests need one of APIKeys or ReadKeys once ReadKeys
+// is set. /healthz stays open for probes. With no APIKeys configured,
+// writes are open too, as before.
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		write := strings.HasPrefix(r.URL.Path, "/admin/") ||
+			(r.URL.Path == "/events" && r.URL.Query().Has("upload_id"))
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			write = true
+		}
+		allowed := s.APIKeys
+		if !write {
+			if len(s.ReadKeys) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			allowed = append(append([]string(nil), s.APIKeys...), s.ReadKeys...)
+		}
+		if len(allowed) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="imgsvc"`)
+			writeJSONError(w, http.StatusUnauthorized, codeUnauthorized, "missing API key")
+			return
+		}
+		if !keyIn(key, allowed) {
+			writeJSONError(w, http.StatusForbidden, codeForbidden, "invalid API key")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerToken extracts the key from an "Authorization: Bearer <key>" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, key, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || key == "" {
+		return "", false
+	}
+	return strings.TrimSpace(key), true
+}
+
+// keyIn compares key against every candidate in constant time.
+func keyIn(key string, keys []string) bool {
+	found := false
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+			found = true
+		}
+	}
+	return found
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorize(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.APIKeys = []string{"write-key"}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := s.authorize(ok)
+	for _, tt := range []struct {
+		method, target string
+		readKeys       []string
+		key            string
+		want           int
+	}{
+		{http.MethodGet, "/healthz", nil, "", http.StatusOK},
+		{http.MethodGet, "/events", nil, "", http.StatusOK},
+		{http.MethodPost, "/upload", nil, "", http.StatusUnauthorized},
+		{http.MethodPost, "/upload", nil, "wrong", http.StatusForbidden},
+		{http.MethodPost, "/upload", nil, "write-key", http.StatusOK},
+		{http.MethodGet, "/admin/deadletters", nil, "", http.StatusUnauthorized},
+		// an upload's progress is as private as the upload
+		{http.MethodGet, "/events?upload_id=u1", nil, "", http.StatusUnauthorized},
+		{http.MethodGet, "/events?upload_id=u1", []string{"read-key"}, "read-key", http.StatusForbidden},
+		{http.MethodGet, "/events?upload_id=u1", nil, "write-key", http.StatusOK},
+		{http.MethodGet, "/events", []string{"read-key"}, "read-key", http.StatusOK},
+		{http.MethodGet, "/events", []string{"read-key"}, "", http.StatusUnauthorized},
+	} {
+		s.ReadKeys = tt.readKeys
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.key != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.key)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s (read keys %v, key %q): status %d, want %d", tt.method, tt.target, tt.readKeys, tt.key, rec.Code, tt.want)
+		}
+	}
+}
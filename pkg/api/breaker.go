@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"example.com/image-factory/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Defaults for the store write breaker, and the bound on one write so a
+// hung store counts as a failure instead of stalling the caller.
+const (
+	defaultBreakerCooldown = 30 * time.Second
+	storeWriteTimeout      = 10 * time.Second
+)
+
+var (
+	storeBreakerOpen = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "imgsvc_store_breaker_open",
+		Help: "1 while store writes are being skipped after repeated failures.",
+	})
+	storeWritesSkipped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "imgsvc_store_writes_skipped_total",
+		Help: "Store writes skipped because the breaker was open.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(storeBreakerOpen, storeWritesSkipped)
+}
+
+// breaker stops store writes after threshold consecutive failures. Once
+// cooldown has passed it lets a single write through (half-open): success
+// closes it, failure opens it for another cooldown. Only errors that say
+// the store is struggling count as failures (see storeFailure). A zero
+// threshold never trips.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	log       *slog.Logger
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow reports whether a write may go ahead, and whether it is the probe:
+// while the breaker is half-open only the first caller is let through.
+func (b *breaker) allow() (ok, probe bool) {
+	if b.threshold <= 0 {
+		return true, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true, false
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		storeWritesSkipped.Inc()
+		return false, false
+	}
+	b.probing = true
+	b.log.Info("store breaker half-open, probing")
+	return true, true
+}
+
+// open reports whether writes are currently being skipped.
+func (b *breaker) open() bool {
+	if b.threshold <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.threshold && (time.Now().Before(b.openUntil) || b.probing)
+}
+
+// record notes the outcome of a write allow let through; probe is what
+// allow said of it. Writes that were already under way when the breaker
+// opened may finish during the probe, and only the probe ends it.
+func (b *breaker) record(err error, probe bool) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+	}
+	if err != nil && !storeFailure(err) {
+		// says nothing of the store's health; a probe ending this way
+		// leaves the next write to probe
+		return
+	}
+	if err == nil {
+		if b.failures >= b.threshold {
+			b.log.Info("store breaker closed")
+			storeBreakerOpen.Set(0)
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+		if probe || b.failures == b.threshold {
+			b.log.Warn("store breaker open, skipping writes", "failures", b.failures, "cooldown", b.cooldown, "err", err)
+		}
+		storeBreakerOpen.Set(1)
+	}
+}
+
+// storeFailure reports whether err from a store write counts against the
+// breaker: a transient store error or a write that ran out of time, not a
+// cancelled caller or a missing row.
+func storeFailure(err error) bool {
+	return storage.IsRetryable(err) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// storeBreaker lazily builds the write breaker from the StoreBreaker*
+// fields.
+func (s *Server) storeBreaker() *breaker {
+	s.breakerOnce.Do(func() {
+		cooldown := s.StoreBreakerCooldown
+		if cooldown <= 0 {
+			cooldown = defaultBreakerCooldown
+		}
+		s.breaker = &breaker{threshold: s.StoreBreakerThreshold, cooldown: cooldown, log: s.log.With("store", "breaker")}
+	})
+	return s.breaker
+}
+
+// storeWrite runs one store write through the breaker, bounded by
+// storeWriteTimeout. It returns false, without calling write, while the
+// breaker is open; the data then stays on local disk only.
+func (s *Server) storeWrite(ctx context.Context, write func(context.Context) error) (bool, error) {
+	b := s.storeBreaker()
+	ok, probe := b.allow()
+	if !ok {
+		return false, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, storeWriteTimeout)
+	defer cancel()
+	err := write(ctx)
+	b.record(err, probe)
+	return true, err
+}
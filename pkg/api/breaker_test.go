@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+
+	"example.com/image-factory/pkg/storage"
+)
+
+func TestBreakerCountsOnlyStoreFailures(t *testing.T) {
+	b := &breaker{threshold: 2, cooldown: time.Hour, log: slog.Default()}
+	for _, err := range []error{
+		context.Canceled,
+		fmt.Errorf("save: %w", storage.ErrNotFound),
+		context.Canceled,
+	} {
+		ok, _ := b.allow()
+		if !ok {
+			t.Fatalf("write refused after %v", err)
+		}
+		b.record(err, false)
+	}
+	if b.open() {
+		t.Fatal("breaker opened on cancellations and missing rows")
+	}
+	for range 2 {
+		b.allow()
+		b.record(context.DeadlineExceeded, false)
+	}
+	if !b.open() {
+		t.Error("breaker still closed after 2 timed-out writes")
+	}
+}
+
+// TestBreakerProbe checks only the probe's outcome ends the half-open
+// state: a write from before the breaker opened that finishes meanwhile
+// doesn't let a second probe through.
+func TestBreakerProbe(t *testing.T) {
+	b := &breaker{threshold: 1, cooldown: time.Millisecond, log: slog.Default()}
+	b.allow()
+	b.record(context.DeadlineExceeded, false)
+	time.Sleep(2 * time.Millisecond)
+
+	if ok, probe := b.allow(); !ok || !probe {
+		t.Fatalf("allow after cooldown = %v, %v; want the probe", ok, probe)
+	}
+	// a straggler from before the breaker opened
+	b.record(context.DeadlineExceeded, false)
+	time.Sleep(2 * time.Millisecond)
+	if ok, _ := b.allow(); ok {
+		t.Fatal("second write let through while the probe is out")
+	}
+	// a probe the caller abandoned says nothing of the store
+	b.record(context.Canceled, true)
+	if ok, probe := b.allow(); !ok || !probe {
+		t.Fatalf("allow after an inconclusive probe = %v, %v; want a new probe", ok, probe)
+	}
+	b.record(nil, true)
+	if b.open() {
+		t.Error("breaker still open after the probe succeeded")
+	}
+	if ok, probe := b.allow(); !ok || probe {
+		t.Errorf("allow once closed = %v, %v; want a plain write", ok, probe)
+	}
+}
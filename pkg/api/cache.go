@@ -0,0 +1,111 @@
+package api
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// byteCache keeps recent blobs with their content types, evicting the least
+// recently used past maxBytes. Lookups are counted in results by hit or
+// miss. A nil cache is valid and caches nothing.
+//
+// A read-through caller takes generation before reading the source and
+// passes it to put, so bytes read before a drop can't be put back after it.
+type byteCache struct {
+	mu       sync.Mutex
+	maxBytes int
+	size     int
+	order    *list.List // front = most recent
+	items    map[string]*list.Element
+	results  *prometheus.CounterVec
+	gen      uint64 // bumped by every drop
+}
+
+type cacheEntry struct {
+	key         string
+	data        []byte
+	contentType string
+}
+
+func newByteCache(maxBytes int, results *prometheus.CounterVec) *byteCache {
+	return &byteCache{maxBytes: maxBytes, order: list.New(), items: make(map[string]*list.Element), results: results}
+}
+
+func (c *byteCache) get(key string) (cacheEntry, bool) {
+	if c == nil {
+		return cacheEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		c.results.WithLabelValues("miss").Inc()
+		return cacheEntry{}, false
+	}
+	c.results.WithLabelValues("hit").Inc()
+	c.order.MoveToFront(el)
+	return el.Value.(cacheEntry), true
+}
+
+// generation returns the token for a put of what is about to be read.
+func (c *byteCache) generation() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// put caches e unless something was dropped since gen was taken, in which
+// case e may be what the drop removed.
+func (c *byteCache) put(e cacheEntry, gen uint64) {
+	if c == nil || len(e.data) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	if el, ok := c.items[e.key]; ok {
+		c.size -= len(el.Value.(cacheEntry).data)
+		c.order.Remove(el)
+	}
+	c.items[e.key] = c.order.PushFront(e)
+	c.size += len(e.data)
+	for c.size > c.maxBytes {
+		c.removeLocked(c.order.Back())
+	}
+}
+
+// drop removes key, and with prefix set every key starting with it.
+func (c *byteCache) drop(key string, prefix bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if !prefix {
+		if el, ok := c.items[key]; ok {
+			c.removeLocked(el)
+		}
+		return
+	}
+	for k, el := range c.items {
+		if strings.HasPrefix(k, key) {
+			c.removeLocked(el)
+		}
+	}
+}
+
+func (c *byteCache) removeLocked(el *list.Element) {
+	e := el.Value.(cacheEntry)
+	c.order.Remove(el)
+	delete(c.items, e.key)
+	c.size -= len(e.data)
+}
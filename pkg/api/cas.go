@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"example.com/image-factory/pkg/ops"
+	"example.com/image-factory/pkg/storage"
+	"github.com/gorilla/mux"
+)
+
+// casEntry locates a local copy of some content hash.
+type casEntry struct {
+	id   string
+	op   string
+	path string
+}
+
+// indexContent records a produced variant's content hash. Callers must not
+// hold s.mu.
+func (s *Server) indexContent(id, op, path string, data []byte) {
+	hash := storage.ContentHash(data)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.hashes[id][op]; ok && old != hash {
+		if e := s.cas[old]; e.id == id && e.op == op {
+			delete(s.cas, old)
+		}
+	}
+	if s.hashes[id] == nil {
+		s.hashes[id] = make(map[string]string)
+	}
+	s.hashes[id][op] = hash
+	s.cas[hash] = casEntry{id: id, op: op, path: path}
+}
+
+// forgetContent drops an image from the content index. Callers hold s.mu.
+func (s *Server) forgetContent(id string) {
+	for _, hash := range s.hashes[id] {
+		if e := s.cas[hash]; e.id == id {
+			delete(s.cas, hash)
+		}
+	}
+	delete(s.hashes, id)
+}
+
+// casURL is the immutable URL for a variant's content.
+func casURL(hash, path string) string {
+	return fmt.Sprintf("/cas/%s%s", hash, filepath.Ext(path))
+}
+
+// handleCAS serves /cas/{hash}.{ext}. The bytes behind a hash never change,
+// so responses are cacheable forever.
+func (s *Server) handleCAS(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	hash := strings.TrimSuffix(name, filepath.Ext(name))
+	if _, err := hex.DecodeString(hash); err != nil || len(hash) != 64 {
+		writeNotFound(w, "not found")
+		return
+	}
+	s.mu.RLock()
+	e, ok := s.cas[hash]
+	s.mu.RUnlock()
+	if ok {
+		w.Header().Set("Cache-Control", ops.ImmutableCacheControl)
+		w.Header().Set("Content-Type", variantContentType(e.path))
+		http.ServeFile(w, r, e.path)
+		return
+	}
+	if hl, ok := s.Store.(storage.HashLookup); ok {
+		data, ct, err := hl.GetVariantByHash(r.Context(), hash)
+		if err == nil {
+			if ct == "" {
+				ct = "image/jpeg"
+			}
+			w.Header().Set("Cache-Control", ops.ImmutableCacheControl)
+			w.Header().Set("Content-Type", ct)
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(data)
+			return
+		}
+	}
+	writeNotFound(w, "not found")
+}
+
+// manifestVariant is one entry of an image manifest.
+type manifestVariant struct {
+	URL    string `json:"url"`
+	CASURL string `json:"cas_url,omitempty"`
+	Hash   string `json:"hash,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// handleManifest lists an image's variants under both their mutable and
+// content-addressed URLs.
+func (s *Server) handleManifest(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	s.mu.RLock()
+	urls, ok := s.variants[id]
+	variants := make(map[string]manifestVariant, len(urls))
+	for op, url := range urls {
+		v := manifestVariant{URL: url}
+		if sz, ok := s.sizes[id][op]; ok {
+			v.Width, v.Height = sz.Width, sz.Height
+		}
+		if hash, ok := s.hashes[id][op]; ok {
+			v.Hash = hash
+			v.CASURL = casURL(hash, s.cas[hash].path)
+		}
+		variants[op] = v
+	}
+	srcset, density := s.srcsetLocked(id)
+	failed := make(map[string]string, len(s.failures[id]))
+	for op, reason := range s.failures[id] {
+		failed[op] = reason
+	}
+	s.mu.RUnlock()
+	if !ok && len(failed) == 0 {
+		writeNotFound(w, "image not found")
+		return
+	}
+	resp := map[string]any{
+		"image_id": id,
+		"variants": variants,
+	}
+	if srcset != "" {
+		resp["srcset"] = srcset
+	}
+	if density != "" {
+		resp["srcset_density"] = density
+	}
+	if len(failed) > 0 {
+		resp["failed"] = failed
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
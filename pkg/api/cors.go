@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Defaults for the CORS preflight response when CORSMethods/CORSHeaders are
+// unset.
+var (
+	defaultCORSMethods = []string{"GET", "POST", "DELETE", "OPTIONS"}
+	defaultCORSHeaders = []string{"Authorization", "Content-Type", "Idempotency-Key"}
+)
+
+// corsMaxAge is how long, in seconds, browsers may cache a preflight.
+const corsMaxAge = "600"
+
+// cors adds CORS headers for requests from CORSOrigins and answers their
+// preflight OPTIONS requests itself, ahead of routing and auth. Requests
+// without an Origin, or from an origin not listed, pass through untouched
+// (and disallowed preflights get 403), so the browser blocks them.
+func (s *Server) cors(next http.Handler) http.Handler {
+	if len(s.CORSOrigins) == 0 {
+		return next
+	}
+	wildcard := slices.Contains(s.CORSOrigins, "*")
+	methods := s.CORSMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	headers := s.CORSHeaders
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+	allowMethods := strings.Join(methods, ", ")
+	allowHeaders := strings.Join(headers, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if !wildcard && !slices.Contains(s.CORSOrigins, origin) {
+			if preflight {
+				writeJSONError(w, http.StatusForbidden, codeForbidden, "origin not allowed")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if wildcard {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if !preflight {
+			h.Set("Access-Control-Expose-Headers", "Retry-After")
+			next.ServeHTTP(w, r)
+			return
+		}
+		h.Set("Access-Control-Allow-Methods", allowMethods)
+		h.Set("Access-Control-Allow-Headers", allowHeaders)
+		h.Set("Access-Control-Max-Age", corsMaxAge)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"example.com/image-factory/pkg/messages"
+	"example.com/image-factory/pkg/ops"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lytics/grid/v3"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// deadLetter is a task that exhausted its retries, as listed by
+// /admin/deadletters and /deadletter.
+type deadLetter struct {
+	ID            string    `json:"id"`
+	ImageID       string    `json:"image_id"`
+	Op            string    `json:"op"`
+	Error         string    `json:"error"`
+	Attempts      int       `json:"attempts"`
+	FirstFailedAt time.Time `json:"first_failed_at"`
+	LastFailedAt  time.Time `json:"last_failed_at"`
+
+	task *messages.TransformTask
+}
+
+// deadLetters holds one entry per (image, op); a repeat failure updates it.
+// Entries live only in memory and do not survive a restart.
+type deadLetters struct {
+	mu    sync.Mutex
+	byKey map[string]*deadLetter
+	byID  map[string]*deadLetter
+}
+
+func (d *deadLetters) add(msg *messages.DeadLetter) {
+	task := msg.GetTask()
+	at := time.UnixMilli(msg.GetFailedAtUnixMs())
+	key := waiterKey(task.GetImageId(), task.GetOp())
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.byKey == nil {
+		d.byKey = make(map[string]*deadLetter)
+		d.byID = make(map[string]*deadLetter)
+	}
+	e, ok := d.byKey[key]
+	if !ok {
+		e = &deadLetter{ID: uuid.New().String(), ImageID: task.GetImageId(), Op: task.GetOp(), FirstFailedAt: at}
+		d.byKey[key] = e
+		d.byID[e.ID] = e
+	}
+	e.Error = msg.GetError()
+	e.Attempts = int(msg.GetAttempts())
+	e.LastFailedAt = at
+	e.task = task
+}
+
+// take removes and returns the entry with id, or every entry when id is "".
+func (d *deadLetters) take(id string) []*deadLetter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var out []*deadLetter
+	for eid, e := range d.byID {
+		if id != "" && eid != id {
+			continue
+		}
+		out = append(out, e)
+		delete(d.byID, eid)
+		delete(d.byKey, waiterKey(e.ImageID, e.Op))
+	}
+	return out
+}
+
+// takeKey removes and returns the entry for an image's op, if any.
+func (d *deadLetters) takeKey(imageID, op string) []*deadLetter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.byKey[waiterKey(imageID, op)]
+	if !ok {
+		return nil
+	}
+	delete(d.byKey, waiterKey(imageID, op))
+	delete(d.byID, e.ID)
+	return []*deadLetter{e}
+}
+
+// restore puts back entries whose re-dispatch failed.
+func (d *deadLetters) restore(es []*deadLetter) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, e := range es {
+		key := waiterKey(e.ImageID, e.Op)
+		if _, ok := d.byKey[key]; !ok {
+			d.byKey[key] = e
+			d.byID[e.ID] = e
+		}
+	}
+}
+
+func (d *deadLetters) list() []deadLetter {
+	d.mu.Lock()
+	out := make([]deadLetter, 0, len(d.byID))
+	for _, e := range d.byID {
+		out = append(out, *e)
+	}
+	d.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].LastFailedAt.After(out[j].LastFailedAt) })
+	return out
+}
+
+func (s *Server) subscribeDeadLetters() {
+	if err := s.GridSrv.WaitUntilStarted(context.Background()); err != nil {
+		s.log.Error("dead-letter wait", "err", err)
+		return
+	}
+	mb, err := s.GridSrv.NewMailbox("dead-letter", 100)
+	if err != nil {
+		s.log.Error("dead-letter mailbox", "err", err)
+		return
+	}
+	defer mb.Close()
+	for {
+		select {
+		case <-s.GridSrv.Context().Done():
+			return
+		case req := <-mb.C():
+			if msg, ok := req.Msg().(*messages.DeadLetter); ok && msg.GetTask() != nil {
+				s.log.Warn("dead-lettered", "image_id", msg.GetTask().GetImageId(), "op", msg.GetTask().GetOp(), "attempts", msg.GetAttempts(), "err", msg.GetError())
+				s.deadletters.add(msg)
+			}
+			_ = req.Ack()
+		}
+	}
+}
+
+// retryDeadLetters re-dispatches entries through the coordinator as
+// single-op uploads, so they start over with a fresh attempt budget.
+// Entries that can't be sent are kept.
+func (s *Server) retryDeadLetters(ctx context.Context, es []*deadLetter) (int, error) {
+	client, err := grid.NewClient(s.Etcd, grid.ClientCfg{Namespace: s.Namespace})
+	if err != nil {
+		s.deadletters.restore(es)
+		return 0, err
+	}
+	defer client.Close()
+	sent := 0
+	var failed []*deadLetter
+	for _, e := range es {
+		t := e.task
+		ev := &messages.UploadEvent{
+			ImageId:    t.GetImageId(),
+			Path:       t.GetPath(),
+			Format:     t.GetFormat(),
+			Ops:        []string{t.GetOp()},
+			Quality:    t.GetQuality(),
+			Effort:     t.GetEffort(),
+			AutoOrient: t.AutoOrient,
+			Metadata:   t.GetMetadata(),
+			Background: t.GetBackground(),
+			EventId:    uuid.New().String(),
+		}
+		// a sized thumbnail's params came from the coordinator's expansion;
+		// leave them off so it expands the sizes again
+		sized := t.GetOp() == ops.SizedOp && t.GetVariant() != ""
+		if t.GetParams() != nil && !sized {
+			ev.Params = map[string]*structpb.Struct{t.GetOp(): t.GetParams()}
+		}
+		if _, err := client.RequestC(ctx, "uploads", ev); err != nil {
+			s.log.Warn("dead-letter retry", "image_id", e.ImageID, "op", e.Op, "err", err)
+			failed = append(failed, e)
+			continue
+		}
+		sent++
+	}
+	s.deadletters.restore(failed)
+	return sent, nil
+}
+
+// GET /admin/deadletters, and GET /deadletter
+func (s *Server) handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.deadletters.list())
+}
+
+// POST /admin/deadletters/{id}/retry
+func (s *Server) handleRetryDeadLetter(w http.ResponseWriter, r *http.Request) {
+	es := s.deadletters.take(mux.Vars(r)["id"])
+	if len(es) == 0 {
+		writeNotFound(w, "dead letter not found")
+		return
+	}
+	s.writeRetryResult(w, r, es)
+}
+
+// POST /deadletter/{id}/{op}/retry re-dispatches an image's dead-lettered op.
+func (s *Server) handleRetryDeadLetterOp(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	es := s.deadletters.takeKey(vars["id"], vars["op"])
+	if len(es) == 0 {
+		writeNotFound(w, "dead letter not found")
+		return
+	}
+	s.writeRetryResult(w, r, es)
+}
+
+// POST /admin/deadletters/retry-all
+func (s *Server) handleRetryAllDeadLetters(w http.ResponseWriter, r *http.Request) {
+	s.writeRetryResult(w, r, s.deadletters.take(""))
+}
+
+func (s *Server) writeRetryResult(w http.ResponseWriter, r *http.Request, es []*deadLetter) {
+	sent, err := s.retryDeadLetters(r.Context(), es)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "grid client")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"retried": sent, "failed": len(es) - sent})
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"example.com/image-factory/pkg/messages"
+	"google.golang.org/protobuf/proto"
+)
+
+// uploadKey identifies an upload by its original's SHA-256 and the options
+// it was sent with, so the same bytes asked for different variants aren't
+// treated as a repeat. ev must not have its id or path set yet.
+func uploadKey(sum []byte, ev *messages.UploadEvent) string {
+	opts, _ := proto.MarshalOptions{Deterministic: true}.Marshal(ev)
+	h := sha256.Sum256(opts)
+	return hex.EncodeToString(sum) + "-" + hex.EncodeToString(h[:8])
+}
+
+// lookupUpload returns the image an earlier upload with key created.
+func (s *Server) lookupUpload(key string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	id, ok := s.uploads[key]
+	return id, ok
+}
+
+// rememberUpload indexes id under key. Callers hold s.mu.
+func (s *Server) rememberUpload(key, id string) {
+	s.uploads[key] = id
+	s.uploadKeys[id] = key
+}
+
+// forgetUpload drops an image from the dedup index. Callers hold s.mu.
+func (s *Server) forgetUpload(id string) {
+	if key, ok := s.uploadKeys[id]; ok {
+		delete(s.uploads, key)
+		delete(s.uploadKeys, id)
+	}
+}
+
+// writeDuplicate answers a repeated upload with the existing image, and
+// its waitFor variant when that's already done.
+func (s *Server) writeDuplicate(w http.ResponseWriter, id, waitFor string) {
+	resp := map[string]any{"image_id": id, "duplicate": true}
+	if waitFor != "" {
+		s.mu.RLock()
+		url, ok := s.variants[id][waitFor]
+		s.mu.RUnlock()
+		if ok {
+			resp["variants"] = map[string]string{waitFor: url}
+		} else {
+			resp["pending"] = []string{waitFor}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
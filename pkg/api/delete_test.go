@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"example.com/image-factory/pkg/messages"
+	"example.com/image-factory/pkg/storage"
+)
+
+func deleteImage(t *testing.T, s *Server, id string) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/images/"+id, nil))
+	return rec.Code
+}
+
+func TestDeleteStoredImage(t *testing.T) {
+	disk, err := storage.NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &countingStore{Store: disk}
+	s := newTestServer(t, st)
+	id := testImageID(1)
+	// only the store has it: no local dir, not indexed
+	if err := disk.SaveOriginal(context.Background(), id, ".jpg", make([]byte, 1<<20)); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := deleteImage(t, s, id); code != http.StatusNoContent {
+		t.Fatalf("delete: status %d, want 204", code)
+	}
+	if st.reads != 0 {
+		t.Errorf("delete read the original %d times to find it", st.reads)
+	}
+	if _, err := disk.GetImageMetadata(context.Background(), id); !storage.IsNotFound(err) {
+		t.Errorf("stored image still there after delete (%v)", err)
+	}
+	if code := deleteImage(t, s, id); code != http.StatusNotFound {
+		t.Errorf("second delete: status %d, want 404", code)
+	}
+}
+
+func TestDeleteBacksOutSucceededVariants(t *testing.T) {
+	s := newTestServer(t, nil)
+	id := testImageID(1)
+	s.variants[id] = map[string]string{"thumbnail_300": "u", "thumbnail_600": "u", "blur": "u"}
+	// blur succeeded once, then failed when reprocessed
+	s.failures[id] = map[string]string{"blur": "boom"}
+	s.successPerOp["thumbnail"], s.successPerOp["blur"] = 2, 1
+	s.totalVariants = 3
+
+	if code := deleteImage(t, s, id); code != http.StatusNoContent {
+		t.Fatalf("delete: status %d, want 204", code)
+	}
+	if got := s.successPerOp["thumbnail"]; got != 0 {
+		t.Errorf("thumbnail successes = %d, want 0", got)
+	}
+	if got := s.successPerOp["blur"]; got != 1 {
+		t.Errorf("blur successes = %d, want the failed variant left at 1", got)
+	}
+	if s.totalVariants != 1 {
+		t.Errorf("total variants = %d, want 1", s.totalVariants)
+	}
+}
+
+// TestDeleteDropsLateResults delivers a variant finished after its image
+// was deleted: it neither reappears in the index nor reaches the store,
+// even when a worker's write recreated the file meanwhile.
+func TestDeleteDropsLateResults(t *testing.T) {
+	st, err := storage.NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, st)
+	id := testImageID(1)
+	writeVariants(t, s, id, "original")
+	path := filepath.Join(s.imageDir(id), "thumbnail.jpg")
+	if code := deleteImage(t, s, id); code != http.StatusNoContent {
+		t.Fatalf("delete: status %d, want 204", code)
+	}
+
+	s.recordResult(&messages.TransformResult{ImageId: id, Op: "thumbnail", Success: true, Path: path})
+	s.recordResult(&messages.TransformResult{ImageId: id, Op: "blur", Error: "boom"})
+	if _, ok := s.variants[id]; ok {
+		t.Errorf("variants = %v after delete, want none", s.variants[id])
+	}
+	if _, ok := s.failures[id]; ok {
+		t.Errorf("failures = %v after delete, want none", s.failures[id])
+	}
+	if s.totalVariants != 0 || s.failedVariants != 0 {
+		t.Errorf("totals = %d ok, %d failed, want the late results uncounted", s.totalVariants, s.failedVariants)
+	}
+
+	writeVariants(t, s, id, "thumbnail")
+	s.persistOne(persistJob{id: id, name: "thumbnail", path: path})
+	if _, _, err := st.GetVariant(context.Background(), id, "thumbnail"); !storage.IsNotFound(err) {
+		t.Errorf("late variant stored for a deleted image (%v)", err)
+	}
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"example.com/image-factory/pkg/messages"
+	"github.com/lytics/grid/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Defaults for sending upload events to the coordinator.
+const (
+	defaultDispatchBackoff = 200 * time.Millisecond
+	dispatchRetryAfter     = "5"
+	// defaultUnconfirmedTimeout is how long an upload whose dispatch timed
+	// out waits for a first result before it's undone.
+	defaultUnconfirmedTimeout = 10 * time.Minute
+)
+
+// errUnconfirmed marks a dispatch that failed in a way that leaves open
+// whether the coordinator got the event: an attempt timed out, rather than
+// being refused.
+var errUnconfirmed = errors.New("upload event may have reached the coordinator")
+
+// ambiguous reports whether a failed request may still have been
+// delivered: it timed out or was cancelled in flight.
+func ambiguous(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.DeadlineExceeded, codes.Canceled:
+		return true
+	}
+	return false
+}
+
+// dispatchUpload sends an upload event to the coordinator over a new grid
+// client, retrying as sendUpload does.
+func (s *Server) dispatchUpload(ctx context.Context, ev *messages.UploadEvent) error {
+	client, err := grid.NewClient(s.Etcd, grid.ClientCfg{Namespace: s.Namespace})
+	if err != nil {
+		return fmt.Errorf("grid client: %w", err)
+	}
+	defer client.Close()
+	return s.sendUpload(ctx, client, ev)
+}
+
+// sendUpload sends an upload event to the coordinator, retrying up to
+// DispatchRetries more times, DispatchBackoff apart (doubling), so a
+// coordinator that is briefly away, e.g. during a leader change, doesn't
+// lose the upload. The event id stays the same across attempts, so the
+// coordinator drops a repeat of one that did land. When any attempt timed
+// out, the error wraps errUnconfirmed.
+func (s *Server) sendUpload(ctx context.Context, client *grid.Client, ev *messages.UploadEvent) error {
+	backoff := s.DispatchBackoff
+	if backoff <= 0 {
+		backoff = defaultDispatchBackoff
+	}
+	unconfirmed := false
+	fail := func(err error) error {
+		if unconfirmed {
+			return fmt.Errorf("%w: %w", errUnconfirmed, err)
+		}
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		_, err := client.RequestC(ctx, "uploads", ev)
+		if err == nil {
+			return nil
+		}
+		unconfirmed = unconfirmed || ambiguous(err)
+		if attempt >= s.DispatchRetries {
+			return fail(err)
+		}
+		s.log.Warn("upload request failed, retrying", "image_id", ev.GetImageId(), "attempt", attempt+1, "backoff", backoff, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fail(ctx.Err())
+		}
+		backoff *= 2
+	}
+}
+
+// holdUnconfirmed keeps an upload whose dispatch may have landed, rather
+// than undoing it: the first result for it confirms it, and if none comes
+// within UnconfirmedTimeout it's abandoned then, freeing key (its
+// Idempotency-Key, if any).
+func (s *Server) holdUnconfirmed(id, dir, key string) {
+	timeout := s.UnconfirmedTimeout
+	if timeout <= 0 {
+		timeout = defaultUnconfirmedTimeout
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unconfirmed[id] = time.AfterFunc(timeout, func() {
+		s.mu.Lock()
+		_, ok := s.unconfirmed[id]
+		delete(s.unconfirmed, id)
+		s.mu.Unlock()
+		if !ok {
+			return
+		}
+		s.log.Warn("unconfirmed upload got no results, abandoning", "image_id", id, "timeout", timeout)
+		s.abandonUpload(context.Background(), id, dir)
+		if key != "" {
+			s.releaseIdempotencyKey(key, id)
+		}
+	})
+}
+
+// confirmUpload counts an upload held by holdUnconfirmed once a result
+// shows the coordinator has it; it's a no-op for any other image.
+func (s *Server) confirmUpload(id string) {
+	s.mu.Lock()
+	t, ok := s.unconfirmed[id]
+	if ok {
+		t.Stop()
+		delete(s.unconfirmed, id)
+		s.totalUploads++
+	}
+	s.mu.Unlock()
+	if ok {
+		s.log.Info("unconfirmed upload confirmed by a result", "image_id", id)
+		uploadsTotal.Inc()
+	}
+}
+
+// abandonUpload undoes an upload whose event never reached the
+// coordinator, so it doesn't linger as an image whose variants will never
+// come.
+func (s *Server) abandonUpload(ctx context.Context, id, dir string) {
+	if err := os.RemoveAll(dir); err != nil {
+		s.log.Warn("abandon upload: remove dir", "image_id", id, "err", err)
+	}
+	if s.Store != nil {
+		// the client may be gone, but the original still has to go
+		if err := s.Store.DeleteImage(context.WithoutCancel(ctx), id); err != nil {
+			s.log.Warn("abandon upload: store delete", "image_id", id, "err", err)
+		}
+	}
+	s.mu.Lock()
+	delete(s.expected, id)
+	s.forgetUpload(id)
+	s.mu.Unlock()
+	s.forgetImageDir(id)
+}
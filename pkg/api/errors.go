@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Error codes sent in error responses, one per class of failure a client
+// may want to tell apart without matching on the message.
+const (
+	codeBadRequest        = "bad_request" // malformed body, or a required field missing
+	codeInvalidParam      = "invalid_param"
+	codeInvalidID         = "invalid_id"
+	codeUnknownOp         = "unknown_op"
+	codeUnsupportedFormat = "unsupported_format"
+	codeUnsupportedMedia  = "unsupported_media_type"
+	codeTooLarge          = "too_large"
+	codeUnauthorized      = "unauthorized"
+	codeForbidden         = "forbidden"
+	codeNotFound          = "not_found"
+	codeMethodNotAllowed  = "method_not_allowed"
+	codeConflict          = "conflict"
+	codeRateLimited       = "rate_limited"
+	codeBusy              = "busy" // the on-the-fly render queue is full
+	codeRenderFailed      = "render_failed"
+	codeUpstream          = "upstream_failed"
+	codeUnavailable       = "unavailable"
+	codeInternal          = "internal"
+)
+
+// errorBody is the envelope every error response carries.
+type errorBody struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// writeJSONError answers with status and a
+// {"error": {"code": code, "message": msg}} body.
+func writeJSONError(w http.ResponseWriter, status int, code, msg string) {
+	var body errorBody
+	body.Error.Code, body.Error.Message = code, msg
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeNotFound answers 404 with code not_found.
+func writeNotFound(w http.ResponseWriter, msg string) {
+	writeJSONError(w, http.StatusNotFound, codeNotFound, msg)
+}
+
+// handleImageNotFound answers every /images/ path no route serves, in place
+// of a file server that would list image directories.
+func (s *Server) handleImageNotFound(w http.ResponseWriter, r *http.Request) {
+	writeNotFound(w, "not found")
+}
+
+// handleNotFound and handleMethodNotAllowed answer requests the router has
+// no route for, so they too get the JSON envelope.
+func handleNotFound(w http.ResponseWriter, r *http.Request) {
+	writeNotFound(w, "not found")
+}
+
+func handleMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBroadcastDropsLaggingDeltaSubscribers(t *testing.T) {
+	s := newTestServer(t, nil)
+	delta := &eventSub{ch: make(chan []byte, 16), delta: true, dropped: make(chan struct{})}
+	snap := &eventSub{ch: make(chan []byte, 16), dropped: make(chan struct{})}
+	s.eventSubs[delta] = struct{}{}
+	s.eventSubs[snap] = struct{}{}
+
+	for i := 0; i < 17; i++ {
+		s.broadcast([]byte("snapshot"), false)
+	}
+	for i := 0; i < 16; i++ {
+		s.broadcastDelta(variantDone{ImageID: testImageID(i), Op: "thumbnail"})
+	}
+	select {
+	case <-delta.dropped:
+		t.Fatal("delta subscriber dropped before its queue was full")
+	default:
+	}
+	s.broadcastDelta(variantDone{ImageID: testImageID(16), Op: "thumbnail"})
+	select {
+	case <-delta.dropped:
+	default:
+		t.Fatal("delta subscriber kept after missing a delta")
+	}
+	if _, ok := s.eventSubs[delta]; ok {
+		t.Error("dropped delta subscriber still registered")
+	}
+	if _, ok := s.eventSubs[snap]; !ok {
+		t.Error("lagging snapshot subscriber was dropped")
+	}
+}
+
+func TestEventsStreamEndsWhenDropped(t *testing.T) {
+	s := newTestServer(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/events?mode=delta", nil).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.handler().ServeHTTP(httptest.NewRecorder(), req)
+	}()
+
+	var sub *eventSub
+	for deadline := time.Now().Add(5 * time.Second); sub == nil; {
+		if time.Now().After(deadline) {
+			t.Fatal("stream never subscribed")
+		}
+		s.eventsMu.Lock()
+		for es := range s.eventSubs {
+			sub = es
+		}
+		s.eventsMu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	s.eventsMu.Lock()
+	delete(s.eventSubs, sub)
+	close(sub.dropped)
+	s.eventsMu.Unlock()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream still open after its subscriber was dropped")
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"example.com/image-factory/pkg/ops"
+)
+
+// remoteFetchTimeout bounds a server-side download for POST /upload {url}.
+const remoteFetchTimeout = 15 * time.Second
+
+// Errors from fetchRemote, mapped to HTTP statuses by handleUpload.
+var (
+	errBadURL       = errors.New("url must be http or https")
+	errNotImage     = errors.New("url is not a supported image")
+	errRemoteTooBig = errors.New("remote image too large")
+	errBlockedHost  = errors.New("url host is not allowed")
+)
+
+// maxFetchRedirects is how many redirects a download may follow; each hop
+// is checked like the first URL.
+const maxFetchRedirects = 5
+
+// fetchClient downloads remote images. Its dialer refuses non-public
+// addresses, so neither a URL nor a redirect nor a hostname resolving to
+// one can reach the metadata server, etcd, or anything else on the
+// server's own networks.
+var fetchClient = &http.Client{
+	Transport: &http.Transport{
+		// a proxy would be dialed in place of the target, defeating the check
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				ap, err := netip.ParseAddrPort(address)
+				if err != nil || !publicAddr(ap.Addr()) {
+					return errBlockedHost
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxFetchRedirects {
+			return errors.New("too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return errBadURL
+		}
+		return nil
+	},
+}
+
+// publicAddr reports whether a is a globally routable unicast address:
+// not loopback, private, link-local, unspecified or multicast.
+func publicAddr(a netip.Addr) bool {
+	a = a.Unmap()
+	return a.IsValid() && a.IsGlobalUnicast() && !a.IsPrivate() && !a.IsLoopback() &&
+		!a.IsLinkLocalUnicast() && !a.IsUnspecified()
+}
+
+// fetchRemote downloads an image of at most maxBytes from rawURL and returns
+// it with a file extension derived from its Content-Type.
+func fetchRemote(ctx context.Context, rawURL string, maxBytes int64) ([]byte, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, "", errBadURL
+	}
+	ctx, cancel := context.WithTimeout(ctx, remoteFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", errBadURL
+	}
+	resp, err := fetchClient.Do(req)
+	for _, known := range []error{errBlockedHost, errBadURL} {
+		if errors.Is(err, known) {
+			return nil, "", known
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("fetch %s: %s", u.Redacted(), resp.Status)
+	}
+	ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	format, ok := ops.NormalizeFormat(strings.TrimPrefix(ct, "image/"))
+	if !strings.HasPrefix(ct, "image/") || !ok {
+		return nil, "", errNotImage
+	}
+	if resp.ContentLength > maxBytes {
+		return nil, "", errRemoteTooBig
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, "", errRemoteTooBig
+	}
+	return data, ops.Extension(format), nil
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestFetchRemoteRejectsLoopback(t *testing.T) {
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("not really a png"))
+	}))
+	defer srv.Close()
+
+	for _, u := range []string{srv.URL, "http://localhost:2379/v3/kv/range", "http://[::1]/"} {
+		if _, _, err := fetchRemote(context.Background(), u, 1<<20); !errors.Is(err, errBlockedHost) {
+			t.Errorf("fetchRemote(%s) err = %v, want errBlockedHost", u, err)
+		}
+	}
+	if hits != 0 {
+		t.Fatalf("loopback server was reached %d times", hits)
+	}
+}
+
+func TestFetchClientRedirects(t *testing.T) {
+	// a redirect's host is checked when it is dialed, like the first URL's;
+	// CheckRedirect only has to refuse other schemes and long chains
+	hop := func(raw string) *http.Request {
+		req, err := http.NewRequest(http.MethodGet, raw, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	via := []*http.Request{hop("https://example.com/a")}
+	if err := fetchClient.CheckRedirect(hop("ftp://example.com/b"), via); !errors.Is(err, errBadURL) {
+		t.Errorf("redirect to ftp: err = %v, want errBadURL", err)
+	}
+	if err := fetchClient.CheckRedirect(hop("https://example.com/b"), via); err != nil {
+		t.Errorf("redirect to https: err = %v", err)
+	}
+	for len(via) < maxFetchRedirects {
+		via = append(via, hop("https://example.com/a"))
+	}
+	if err := fetchClient.CheckRedirect(hop("https://example.com/b"), via); err == nil {
+		t.Error("redirect past the limit was allowed")
+	}
+}
+
+func TestPublicAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"::ffff:10.0.0.1": false,
+		"224.0.0.1":       false,
+		"8.8.8.8":         true,
+		"2606:4700::1111": true,
+	} {
+		if got := publicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("publicAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"example.com/image-factory/pkg/storage"
+)
+
+// writeTracker numbers store writes as they begin so a flush can wait for
+// the ones already in flight without waiting on writes that start after it.
+type writeTracker struct {
+	mu      sync.Mutex
+	seq     uint64
+	open    map[uint64]struct{} // writes begun and not yet ended
+	changed chan struct{}       // closed and replaced whenever a write ends
+}
+
+// begin records a write starting and returns its number for end.
+func (t *writeTracker) begin() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.open == nil {
+		t.open = make(map[uint64]struct{})
+	}
+	t.seq++
+	t.open[t.seq] = struct{}{}
+	return t.seq
+}
+
+// end records write seq finishing.
+func (t *writeTracker) end(seq uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.open, seq)
+	if t.changed != nil {
+		close(t.changed)
+		t.changed = nil
+	}
+}
+
+// wait blocks until every write begun before the call has ended, and
+// returns how many of them it saw end.
+func (t *writeTracker) wait(ctx context.Context) (int, error) {
+	t.mu.Lock()
+	last, total := t.seq, len(t.open)
+	t.mu.Unlock()
+	for {
+		t.mu.Lock()
+		left := 0
+		for seq := range t.open {
+			if seq <= last {
+				left++
+			}
+		}
+		if left == 0 {
+			t.mu.Unlock()
+			return total, nil
+		}
+		if t.changed == nil {
+			t.changed = make(chan struct{})
+		}
+		changed := t.changed
+		t.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return total - left, ctx.Err()
+		}
+	}
+}
+
+// Flush blocks until every pending store write has been committed and
+// returns how many writes it waited for. A store that batches writes is
+// flushed too, so none linger in its queue.
+func (s *Server) Flush(ctx context.Context) (int, error) {
+	n, err := s.writes.wait(ctx)
+	if err != nil {
+		return n, err
+	}
+	if f, ok := s.Store.(storage.Flusher); ok {
+		err = f.Flush(ctx)
+	}
+	return n, err
+}
+
+// Admin flush: POST /admin/flush
+func (s *Server) handleFlush(w http.ResponseWriter, r *http.Request) {
+	n, err := s.Flush(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, codeUnavailable, "flush interrupted")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"flushed": n})
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestWriteTrackerWaitsForEarlierWrites checks a flush waits for the writes
+// in flight when it was called, however they interleave, and not for writes
+// that start while it waits.
+func TestWriteTrackerWaitsForEarlierWrites(t *testing.T) {
+	var tr writeTracker
+	a, b := tr.begin(), tr.begin()
+
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := tr.wait(context.Background())
+		done <- result{n, err}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	// a steady stream of later writes never lets pending reach zero
+	later := tr.begin()
+	tr.end(b)
+	next := tr.begin()
+	select {
+	case r := <-done:
+		t.Fatalf("flush returned %v with a write still in flight", r)
+	case <-time.After(10 * time.Millisecond):
+	}
+	tr.end(a)
+	select {
+	case r := <-done:
+		if r.err != nil || r.n != 2 {
+			t.Errorf("flush = %d, %v; want 2 writes flushed", r.n, r.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("flush still waiting on writes begun after it")
+	}
+	tr.end(later)
+	tr.end(next)
+	if n, err := tr.wait(context.Background()); n != 0 || err != nil {
+		t.Errorf("idle flush = %d, %v; want 0", n, err)
+	}
+}
+
+func TestWriteTrackerWaitCancelled(t *testing.T) {
+	var tr writeTracker
+	a := tr.begin()
+	tr.begin()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		tr.end(a)
+		cancel()
+	}()
+	n, err := tr.wait(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("wait = %v, want cancelled", err)
+	}
+	if n > 1 {
+		t.Errorf("flushed = %d, want at most the 1 write that ended", n)
+	}
+}
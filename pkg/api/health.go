@@ -0,0 +1,42 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// handleHealthz reports readiness: 200 when the grid server is started and
+// the store (if configured) answers, else 503 naming what is unhealthy.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	checks := map[string]string{}
+	healthy := true
+	gridCtx, gridCancel := context.WithTimeout(ctx, time.Second)
+	if err := s.GridSrv.WaitUntilStarted(gridCtx); err != nil {
+		checks["grid"] = "not started: " + err.Error()
+		healthy = false
+	} else {
+		checks["grid"] = "ok"
+	}
+	gridCancel()
+	if s.Store != nil {
+		if err := s.Store.HealthCheck(ctx); err != nil {
+			checks["store"] = err.Error()
+			healthy = false
+		} else {
+			checks["store"] = "ok"
+		}
+	}
+
+	status, code := "ok", http.StatusOK
+	if !healthy {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{"status": status, "checks": checks})
+}
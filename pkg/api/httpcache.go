@@ -0,0 +1,43 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"example.com/image-factory/pkg/ops"
+	"example.com/image-factory/pkg/storage"
+)
+
+// variantCacheControl returns the Cache-Control sent with the named
+// variant: VariantMaxAge when set, else its op's registered policy.
+func (s *Server) variantCacheControl(name string) string {
+	if s.VariantMaxAge > 0 {
+		return fmt.Sprintf("public, max-age=%d", int(s.VariantMaxAge/time.Second))
+	}
+	return ops.CacheControl(name)
+}
+
+// variantETag returns the strong ETag for a variant's bytes, from the
+// content index when it has them and else by hashing data.
+func (s *Server) variantETag(id, name string, data []byte) string {
+	s.mu.RLock()
+	hash, ok := s.hashes[id][name]
+	s.mu.RUnlock()
+	if !ok {
+		hash = storage.ContentHash(data)
+	}
+	return `"` + hash + `"`
+}
+
+// serveVariantBytes writes a variant held in memory with its ETag, letting
+// http.ServeContent answer a matching If-None-Match with 304.
+func (s *Server) serveVariantBytes(w http.ResponseWriter, r *http.Request, id, name, ct string, data []byte) {
+	if ct == "" {
+		ct = "image/jpeg"
+	}
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("ETag", s.variantETag(id, name, data))
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
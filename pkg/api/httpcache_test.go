@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"example.com/image-factory/pkg/ops"
+)
+
+// writeVariants puts a small JPEG on disk as each named variant of id.
+func writeVariants(t *testing.T, s *Server, id string, names ...string) {
+	t.Helper()
+	data, err := os.ReadFile(writeJPEG(t, 8, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := s.newImageDir(id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name+".jpg"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVariantCacheControl(t *testing.T) {
+	s := newTestServer(t, nil)
+	id := testImageID(1)
+	writeVariants(t, s, id, "thumbnail", "thumbnail_300", "resize", "grayscale")
+	for _, tt := range []struct {
+		op, want string
+	}{
+		{"thumbnail", ops.ImmutableCacheControl},
+		{"thumbnail_300", ops.ImmutableCacheControl},
+		{"resize", "public, max-age=3600"},
+		{"grayscale", ops.DefaultCacheControl},
+	} {
+		rec := get(t, s, "/images/"+id+"/"+tt.op, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", tt.op, rec.Code)
+		}
+		if got := rec.Header().Get("Cache-Control"); got != tt.want {
+			t.Errorf("%s: Cache-Control %q, want %q", tt.op, got, tt.want)
+		}
+	}
+
+	rec := get(t, s, "/images/"+id+"/blur", nil)
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Cache-Control") != "" {
+		t.Errorf("missing variant: status %d, Cache-Control %q; want an uncached 404", rec.Code, rec.Header().Get("Cache-Control"))
+	}
+
+	// VARIANT_MAX_AGE replaces every op's policy
+	s.VariantMaxAge = time.Hour
+	for _, op := range []string{"thumbnail", "grayscale"} {
+		if got := get(t, s, "/images/"+id+"/"+op, nil).Header().Get("Cache-Control"); got != "public, max-age=3600" {
+			t.Errorf("%s with VariantMaxAge: Cache-Control %q", op, got)
+		}
+	}
+}
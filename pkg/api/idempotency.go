@@ -0,0 +1,49 @@
+package api
+
+import "time"
+
+// idempotencySweepInterval is how often claiming a key also drops the
+// expired ones.
+const idempotencySweepInterval = time.Minute
+
+// idempotentUpload is the image an Idempotency-Key was first used for.
+type idempotentUpload struct {
+	id string
+	at time.Time
+}
+
+// claimIdempotencyKey ties key to id unless an upload in the last
+// IdempotencyKeyTTL already holds it, in which case it returns that
+// upload's image and false.
+func (s *Server) claimIdempotencyKey(key, id string) (string, bool) {
+	now := time.Now()
+	ttl := s.IdempotencyKeyTTL
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.idempotencySwept) >= idempotencySweepInterval {
+		for k, u := range s.idempotencyKeys {
+			if now.Sub(u.at) >= ttl {
+				delete(s.idempotencyKeys, k)
+			}
+		}
+		s.idempotencySwept = now
+	}
+	if u, ok := s.idempotencyKeys[key]; ok && now.Sub(u.at) < ttl {
+		return u.id, false
+	}
+	s.idempotencyKeys[key] = idempotentUpload{id: id, at: now}
+	return id, true
+}
+
+// releaseIdempotencyKey frees key after the upload that claimed it for id
+// failed, so the client's retry is processed afresh.
+func (s *Server) releaseIdempotencyKey(key, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.idempotencyKeys[key].id == id {
+		delete(s.idempotencyKeys, key)
+	}
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+
+	"github.com/disintegration/imaging"
+)
+
+// capOriginal shrinks the original at path in place so its long edge is at
+// most maxEdge, keeping the aspect ratio and file format. It reports whether
+// the file was rewritten. Formats imaging can't encode (e.g. WebP) are kept
+// as uploaded. Re-encoding drops EXIF, so the orientation tag is applied to
+// the pixels first.
+func capOriginal(path string, maxEdge int) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return false, fmt.Errorf("decode config: %w", err)
+	}
+	if max(cfg.Width, cfg.Height) <= maxEdge {
+		return false, nil
+	}
+	format, err := imaging.FormatFromFilename(path)
+	if err != nil {
+		return false, nil
+	}
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return false, err
+	}
+	img = imaging.Fit(img, maxEdge, maxEdge, imaging.Lanczos)
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, format, imaging.JPEGQuality(95)); err != nil {
+		return false, err
+	}
+	tmp := filepath.Join(filepath.Dir(path), ".capped"+filepath.Ext(path))
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp, path)
+}
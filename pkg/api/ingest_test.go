@@ -0,0 +1,72 @@
+package api
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeJPEG writes a w x h JPEG to a temp file and returns its path.
+func writeJPEG(t *testing.T, w, h int) string {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), 90, 255})
+		}
+	}
+	path := filepath.Join(t.TempDir(), "original.jpg")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := jpeg.Encode(f, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func storedSize(t *testing.T, path string) image.Point {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return image.Pt(cfg.Width, cfg.Height)
+}
+
+func TestCapOriginal(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		w, h      int
+		rewritten bool
+		want      image.Point
+	}{
+		{"landscape over the cap", 800, 400, true, image.Pt(200, 100)},
+		{"portrait over the cap", 300, 600, true, image.Pt(100, 200)},
+		{"within the cap", 200, 150, false, image.Pt(200, 150)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeJPEG(t, tc.w, tc.h)
+			rewritten, err := capOriginal(path, 200)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rewritten != tc.rewritten {
+				t.Errorf("rewritten = %v, want %v", rewritten, tc.rewritten)
+			}
+			if got := storedSize(t, path); got != tc.want {
+				t.Errorf("stored %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
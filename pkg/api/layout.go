@@ -0,0 +1,175 @@
+package api
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultPathTemplate keeps every image's directory directly under the data
+// dir.
+const defaultPathTemplate = "{id}"
+
+// pathPlaceholders are the date parts a path template may use besides {id},
+// with how each is rendered from the upload time (UTC).
+var pathPlaceholders = map[string]string{
+	"{yyyy}": "2006",
+	"{mm}":   "01",
+	"{dd}":   "02",
+}
+
+// pathLayout places image directories under root per a template such as
+// "{yyyy}/{mm}/{dd}/{id}". Workers never see it: they write next to the
+// original, whose full path travels with each task.
+type pathLayout struct {
+	root     string
+	template string
+}
+
+// ValidatePathTemplate checks t is a relative path ending in an {id}
+// element, using only the known placeholders. Empty means the default.
+func ValidatePathTemplate(t string) error {
+	if t == "" {
+		return nil
+	}
+	if filepath.IsAbs(t) || filepath.Clean(t) != t || strings.HasPrefix(t, "..") {
+		return errors.New("must be a clean relative path")
+	}
+	if strings.Count(t, "{id}") != 1 || (t != "{id}" && !strings.HasSuffix(t, "/{id}")) {
+		return errors.New("must end in a {id} element")
+	}
+	rest := strings.ReplaceAll(t, "{id}", "")
+	for p := range pathPlaceholders {
+		rest = strings.ReplaceAll(rest, p, "")
+	}
+	if strings.ContainsAny(rest, "{}*?[") {
+		return errors.New("unknown placeholder")
+	}
+	return nil
+}
+
+// dir is where an image uploaded at t gets its directory.
+func (l pathLayout) dir(id string, t time.Time) string {
+	p := strings.ReplaceAll(l.template, "{id}", id)
+	for ph, layout := range pathPlaceholders {
+		p = strings.ReplaceAll(p, ph, t.UTC().Format(layout))
+	}
+	return filepath.Join(l.root, p)
+}
+
+// dated reports whether the template has date placeholders, so an image's
+// directory depends on when it was uploaded.
+func (l pathLayout) dated() bool {
+	for ph := range pathPlaceholders {
+		if strings.Contains(l.template, ph) {
+			return true
+		}
+	}
+	return false
+}
+
+// glob matches the directory of id, or of every image when id is "*".
+func (l pathLayout) glob(id string) string {
+	p := strings.ReplaceAll(l.template, "{id}", id)
+	for ph := range pathPlaceholders {
+		p = strings.ReplaceAll(p, ph, "*")
+	}
+	return filepath.Join(l.root, p)
+}
+
+// layout returns the directory layout, from PathTemplate on first use.
+func (s *Server) layout() pathLayout {
+	s.layoutOnce.Do(func() {
+		t := s.PathTemplate
+		if t == "" {
+			t = defaultPathTemplate
+		} else if err := ValidatePathTemplate(t); err != nil {
+			// startup checks it; this only guards a Server built in code
+			s.log.Error("invalid path template, using "+defaultPathTemplate, "template", t, "err", err)
+			t = defaultPathTemplate
+		}
+		s.paths = pathLayout{root: s.imgsDir, template: t}
+	})
+	return s.paths
+}
+
+// newImageDir picks the directory for a new upload and remembers it.
+func (s *Server) newImageDir(id string) string {
+	dir := s.layout().dir(id, time.Now())
+	s.dirsMu.Lock()
+	s.dirs[id] = dir
+	s.dirsMu.Unlock()
+	return dir
+}
+
+// imageDir returns the directory holding an image's files: the one in the
+// index, else where it would go if created now. With a dated layout the
+// index is filled from disk once, on first use; an id missing from it,
+// such as one a peer uploaded to shared storage since, is looked for in
+// the date directories and indexed when found.
+func (s *Server) imageDir(id string) string {
+	l := s.layout()
+	if !l.dated() {
+		return l.dir(id, time.Time{})
+	}
+	s.dirsScan.Do(func() { s.diskImageIDs() })
+	s.dirsMu.Lock()
+	dir, ok := s.dirs[id]
+	s.dirsMu.Unlock()
+	if ok {
+		return dir
+	}
+	// only a well-formed id is safe to glob with
+	if validImageID(id) {
+		matches, _ := filepath.Glob(l.glob(id))
+		for _, m := range matches {
+			if fi, err := os.Stat(m); err == nil && fi.IsDir() {
+				s.dirsMu.Lock()
+				s.dirs[id] = m
+				s.dirsMu.Unlock()
+				return m
+			}
+		}
+	}
+	return l.dir(id, time.Now())
+}
+
+// forgetImageDir drops a deleted image's directory from the index.
+func (s *Server) forgetImageDir(id string) {
+	s.dirsMu.Lock()
+	delete(s.dirs, id)
+	s.dirsMu.Unlock()
+}
+
+// diskImageIDs lists the images with a directory on disk.
+func (s *Server) diskImageIDs() []string {
+	matches, _ := filepath.Glob(s.layout().glob("*"))
+	var ids []string
+	for _, m := range matches {
+		if fi, err := os.Stat(m); err != nil || !fi.IsDir() {
+			continue
+		}
+		id := filepath.Base(m)
+		ids = append(ids, id)
+		s.dirsMu.Lock()
+		if _, ok := s.dirs[id]; !ok {
+			s.dirs[id] = m
+		}
+		s.dirsMu.Unlock()
+	}
+	return ids
+}
+
+// variantFile returns the regular file named name.<ext> in dir, skipping
+// anything else the name matches, such as a directory.
+func variantFile(dir, name string) (string, bool) {
+	matches, _ := filepath.Glob(filepath.Join(dir, name+".*"))
+	for _, m := range matches {
+		if fi, err := os.Stat(m); err == nil && fi.Mode().IsRegular() {
+			return m, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValidatePathTemplate(t *testing.T) {
+	for tmpl, ok := range map[string]bool{
+		"":                      true,
+		"{id}":                  true,
+		"{yyyy}/{mm}/{dd}/{id}": true,
+		"shard/{yyyy}/{id}":     true,
+		"/abs/{id}":             false,
+		"../{id}":               false,
+		"{id}/{yyyy}":           false,
+		"{yyyy}/{mm}":           false,
+		"{hh}/{id}":             false,
+		"*/{id}":                false,
+	} {
+		if err := ValidatePathTemplate(tmpl); (err == nil) != ok {
+			t.Errorf("ValidatePathTemplate(%q) = %v, want ok %v", tmpl, err, ok)
+		}
+	}
+}
+
+func TestImageDirDatedLayout(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.PathTemplate = "{yyyy}/{mm}/{dd}/{id}"
+	old, later := testImageID(1), testImageID(2)
+	oldDir := filepath.Join(s.imgsDir, "2024", "02", "29", old)
+	if err := os.MkdirAll(oldDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// existing directories are indexed on first use
+	if got := s.imageDir(old); got != oldDir {
+		t.Errorf("imageDir(existing) = %s, want %s", got, oldDir)
+	}
+	// one created since, as by a peer sharing the data dir, is found
+	// in the date directories
+	laterDir := filepath.Join(s.imgsDir, "2024", "03", "01", later)
+	if err := os.MkdirAll(laterDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.imageDir(later); got != laterDir {
+		t.Errorf("imageDir(created since) = %s, want %s", got, laterDir)
+	}
+	// one on disk nowhere gets today's directory
+	fresh := testImageID(3)
+	if got, want := s.imageDir(fresh), s.layout().dir(fresh, time.Now()); got != want {
+		t.Errorf("imageDir(unknown) = %s, want %s", got, want)
+	}
+	// uploads are indexed as they're made
+	dir := s.newImageDir(fresh)
+	if got := s.imageDir(fresh); got != dir {
+		t.Errorf("imageDir(new upload) = %s, want %s", got, dir)
+	}
+}
@@ -0,0 +1,44 @@
+package api
+
+import "sync"
+
+// imageLocks serializes lifecycle operations (variant updates and their
+// persistence, delete, reprocess) on the same image id. Entries are reference counted
+// and removed as soon as nobody holds or waits on them, so the map only
+// ever contains ids with activity in flight.
+type imageLocks struct {
+	mu    sync.Mutex
+	locks map[string]*imageLock
+}
+
+type imageLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lock blocks until the caller holds the lock for id and returns the
+// function that releases it.
+func (l *imageLocks) lock(id string) (unlock func()) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*imageLock)
+	}
+	e, ok := l.locks[id]
+	if !ok {
+		e = &imageLock{}
+		l.locks[id] = e
+	}
+	e.refs++
+	l.mu.Unlock()
+
+	e.mu.Lock()
+	return func() {
+		e.mu.Unlock()
+		l.mu.Lock()
+		e.refs--
+		if e.refs == 0 {
+			delete(l.locks, id)
+		}
+		l.mu.Unlock()
+	}
+}
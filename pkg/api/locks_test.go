@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/image-factory/pkg/messages"
+	"example.com/image-factory/pkg/storage"
+)
+
+// TestDeleteReprocessRace hammers one image with concurrent deletes and
+// reprocesses. Whichever wins, every dispatched event must find its
+// original on disk, and a deleted image must leave no state behind.
+func TestDeleteReprocessRace(t *testing.T) {
+	st, err := storage.NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, st)
+	var mu sync.Mutex
+	var missing []string
+	s.dispatch = func(ctx context.Context, ev *messages.UploadEvent) error {
+		// a send takes a while, giving a delete time to get in
+		time.Sleep(time.Millisecond)
+		if _, err := os.Stat(ev.GetPath()); err != nil {
+			mu.Lock()
+			missing = append(missing, ev.GetPath())
+			mu.Unlock()
+		}
+		return nil
+	}
+	h := s.handler()
+	serve := func(method, target string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec.Code
+	}
+
+	id := testImageID(1)
+	for round := 0; round < 50; round++ {
+		dir := s.newImageDir(id)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "original.jpg"), []byte("jpeg"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.SaveOriginal(context.Background(), id, ".jpg", []byte("jpeg")); err != nil {
+			t.Fatal(err)
+		}
+		s.mu.Lock()
+		s.variants[id] = map[string]string{"thumbnail": "/images/" + id + "/thumbnail"}
+		s.mu.Unlock()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if i == 0 {
+					if code := serve(http.MethodDelete, "/images/"+id); code != http.StatusNoContent {
+						t.Errorf("delete: status %d", code)
+					}
+					return
+				}
+				code := serve(http.MethodPost, "/images/"+id+"/reprocess")
+				if code != http.StatusAccepted && code != http.StatusNotFound {
+					t.Errorf("reprocess: status %d", code)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		// the delete ran, and no reprocess after it found anything
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Fatalf("round %d: image dir left behind (%v)", round, err)
+		}
+		s.mu.RLock()
+		_, expected := s.expected[id]
+		_, indexed := s.variants[id]
+		s.mu.RUnlock()
+		if expected || indexed {
+			t.Fatalf("round %d: deleted image still expected=%v indexed=%v", round, expected, indexed)
+		}
+	}
+	if len(missing) > 0 {
+		t.Fatalf("%d events dispatched without an original, e.g. %s", len(missing), missing[0])
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"example.com/image-factory/pkg/storage"
+	"github.com/gorilla/mux"
+)
+
+// GET /images/{id}/meta returns when the original and each variant were
+// stored, and the original's size. Spanner is authoritative when
+// configured; otherwise file modification times stand in.
+func (s *Server) handleImageMeta(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var meta *storage.ImageMetadata
+	if s.Store != nil {
+		m, err := s.Store.GetImageMetadata(r.Context(), id)
+		if storage.IsNotFound(err) {
+			writeNotFound(w, "image not found")
+			return
+		}
+		if err != nil {
+			s.log.Error("store image metadata", "image_id", id, "err", err)
+			writeJSONError(w, http.StatusServiceUnavailable, codeUnavailable, "store unavailable")
+			return
+		}
+		meta = m
+	} else {
+		meta = s.diskMetadata(id)
+		if meta == nil {
+			writeNotFound(w, "image not found")
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meta)
+}
+
+// diskMetadata builds an image's metadata from its files on disk, or
+// returns nil when it has no original there.
+func (s *Server) diskMetadata(id string) *storage.ImageMetadata {
+	files, _ := filepath.Glob(filepath.Join(s.imageDir(id), "*"))
+	var meta *storage.ImageMetadata
+	variants := map[string]storage.VariantMetadata{}
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil || fi.IsDir() {
+			continue
+		}
+		name := strings.TrimSuffix(fi.Name(), filepath.Ext(fi.Name()))
+		if name == "original" {
+			meta = &storage.ImageMetadata{ImageID: id, CreatedAt: fi.ModTime().UTC(), OriginalBytes: fi.Size()}
+			continue
+		}
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		variants[name] = storage.VariantMetadata{CreatedAt: fi.ModTime().UTC(), Bytes: fi.Size()}
+	}
+	if meta != nil {
+		meta.Variants = variants
+	}
+	return meta
+}
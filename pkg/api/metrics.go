@@ -0,0 +1,61 @@
+package api
+
+import (
+	"example.com/image-factory/pkg/ops"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// transformDuration records how long workers spend per transform, as
+// reported in TransformResult.duration_ms.
+var transformDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "imgsvc_transform_duration_seconds",
+	Help:    "Time workers spent transforming an image, by op.",
+	Buckets: prometheus.ExponentialBucketsRange(0.01, 10, 12),
+}, []string{"op"})
+
+// transformFailures counts failed transforms by op and the worker's failure
+// category ("error" when it gave none).
+var transformFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "imgsvc_transform_failures_total",
+	Help: "Failed transforms, by op and failure reason.",
+}, []string{"op", "reason"})
+
+// Counterparts of the /metrics/json totals. Failures are counted by
+// transformFailures.
+var (
+	uploadsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "imgsvc_uploads_total",
+		Help: "Images accepted by POST /upload.",
+	})
+	variantsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "imgsvc_variants_total",
+		Help: "Variants produced, by op.",
+	}, []string{"op"})
+	variantsExhausted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "imgsvc_variants_exhausted_total",
+		Help: "Variants that failed after using every retry, by op.",
+	}, []string{"op"})
+)
+
+// pendingTasks mirrors the coordinators' backlog reports: tasks dispatched
+// but not yet resolved.
+var pendingTasks = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "imgsvc_pending_tasks",
+	Help: "Transform tasks dispatched but not yet resolved, by op.",
+}, []string{"op"})
+
+// variantCacheResults counts variant cache lookups made ahead of store reads.
+var variantCacheResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "imgsvc_variant_cache_requests_total",
+	Help: "Variant cache lookups ahead of store reads, by result (hit or miss).",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(transformDuration, transformFailures, uploadsTotal, variantsTotal, variantsExhausted, pendingTasks, variantCacheResults)
+	// start every op at zero, so a failure rate exists before its first
+	// result
+	for _, spec := range ops.All() {
+		variantsTotal.WithLabelValues(spec.Name)
+		transformFailures.WithLabelValues(spec.Name, "error")
+	}
+}
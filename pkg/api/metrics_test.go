@@ -0,0 +1,41 @@
+package api
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestRecordBacklog(t *testing.T) {
+	s := newTestServer(t, nil)
+	report := func(coordinator string, pending map[string]int32) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.recordBacklogLocked(coordinator, pending)
+	}
+	gauge := func(op string) float64 {
+		var m dto.Metric
+		if err := pendingTasks.WithLabelValues(op).Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		return m.GetGauge().GetValue()
+	}
+
+	report("c1", map[string]int32{"blur": 3, "thumbnail": 2})
+	report("c2", map[string]int32{"blur": 1})
+	if g := gauge("blur"); g != 4 {
+		t.Errorf("blur pending %v, want 4", g)
+	}
+	report("c1", map[string]int32{"blur": 1})
+	if g := gauge("thumbnail"); g != 0 {
+		t.Errorf("thumbnail pending %v after it drained, want 0", g)
+	}
+	if g := gauge("blur"); g != 2 {
+		t.Errorf("blur pending %v, want 2", g)
+	}
+	report("c1", nil)
+	report("c2", nil)
+	if g := gauge("blur"); g != 0 {
+		t.Errorf("blur pending %v once every backlog is empty, want 0", g)
+	}
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"example.com/image-factory/pkg/ops"
+	"github.com/chai2010/webp"
+	"github.com/disintegration/imaging"
+)
+
+// transcodeQuality is the JPEG/WebP quality of variants transcoded on the
+// fly for a client that asked for another format.
+const transcodeQuality = 90
+
+// storedFormat returns the canonical format ("jpeg", "webp", "json", ...)
+// of a variant stored with content type ct.
+func storedFormat(ct string) string {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return ""
+	}
+	_, sub, _ := strings.Cut(mt, "/")
+	f, _ := ops.NormalizeFormat(sub)
+	return f
+}
+
+// servedFormat picks the format to serve a variant stored as stored in: the
+// URL's extension when there is one, otherwise the best match for the
+// Accept header. It returns stored when no transcoding is needed, and ok
+// false when ext names a format the variant can't be served in.
+func servedFormat(r *http.Request, ext, stored string) (string, bool) {
+	if _, isImage := ops.NormalizeFormat(stored); !isImage {
+		// ops that don't output an image have only the one format
+		return stored, ext == "" || strings.EqualFold(ext, stored)
+	}
+	if ext != "" {
+		f, ok := ops.NormalizeFormat(ext)
+		return f, ok
+	}
+	return negotiateFormat(r.Header.Get("Accept"), stored), true
+}
+
+// acceptEntry is one media range of an Accept header.
+type acceptEntry struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept returns an Accept header's media ranges, most preferred
+// first, leaving out those with q=0.
+func parseAccept(header string) []acceptEntry {
+	var out []acceptEntry
+	for _, part := range strings.Split(header, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			out = append(out, acceptEntry{mediaType: mt, q: q})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].q > out[j].q })
+	return out
+}
+
+// acceptQ returns the q the most specific of entries matching mt gives it,
+// or 0 when none does.
+func acceptQ(entries []acceptEntry, mt string) float64 {
+	typ, _, _ := strings.Cut(mt, "/")
+	q, specificity := 0.0, -1
+	for _, e := range entries {
+		var s int
+		switch e.mediaType {
+		case mt:
+			s = 2
+		case typ + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s > specificity {
+			q, specificity = e.q, s
+		}
+	}
+	return q
+}
+
+// negotiateFormat returns the format the Accept header prefers for a
+// variant stored as stored. The stored format wins whenever the client
+// takes it as readily as anything else, so wildcards like browsers send
+// don't cause transcoding; a client that asks only for, say, image/webp
+// gets WebP. With no usable preference the stored format is served.
+func negotiateFormat(header, stored string) string {
+	entries := parseAccept(header)
+	best, bestQ := stored, acceptQ(entries, "image/"+stored)
+	for _, e := range entries {
+		typ, sub, _ := strings.Cut(e.mediaType, "/")
+		if typ != "image" || e.q <= bestQ {
+			continue
+		}
+		if f, ok := ops.NormalizeFormat(sub); ok {
+			best, bestQ = f, e.q
+		}
+	}
+	return best
+}
+
+// serveNegotiated writes a variant held in memory in format, transcoding it
+// under the render limiter when that differs from how it is stored. The
+// transcoded copies share the render cache.
+func (s *Server) serveNegotiated(w http.ResponseWriter, r *http.Request, id, name, ct, format string, data []byte) {
+	if format == storedFormat(ct) {
+		s.serveVariantBytes(w, r, id, name, ct, data)
+		return
+	}
+	key := name + "." + format
+	out, outCT, err := s.render(r.Context(), "transcode:"+id+"/"+key, func(context.Context) ([]byte, string, error) {
+		out, err := transcode(data, format)
+		return out, "image/" + format, err
+	})
+	if err != nil {
+		w.Header().Del("Cache-Control")
+		writeRenderError(w, err)
+		return
+	}
+	s.serveVariantBytes(w, r, id, key, outCT, out)
+}
+
+// transcode re-encodes an image in format. Transparency is flattened onto
+// white for JPEG, which has no alpha channel.
+func transcode(data []byte, format string) ([]byte, error) {
+	img, err := imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("transcode: %w", err)
+	}
+	var buf bytes.Buffer
+	if format == "webp" {
+		if err := webp.Encode(&buf, img, &webp.Options{Quality: transcodeQuality}); err != nil {
+			return nil, fmt.Errorf("transcode: %w", err)
+		}
+		return buf.Bytes(), nil
+	}
+	f, err := imaging.FormatFromExtension(ops.Extension(format))
+	if err != nil {
+		return nil, fmt.Errorf("transcode: %w", err)
+	}
+	if f == imaging.JPEG {
+		b := img.Bounds()
+		img = imaging.Overlay(imaging.New(b.Dx(), b.Dy(), color.White), img, image.Pt(0, 0), 1.0)
+	}
+	if err := imaging.Encode(&buf, img, f, imaging.JPEGQuality(transcodeQuality)); err != nil {
+		return nil, fmt.Errorf("transcode: %w", err)
+	}
+	return buf.Bytes(), nil
+}
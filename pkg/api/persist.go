@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Defaults for variant persistence.
+const (
+	defaultPersistWorkers = 4
+	defaultPersistQueue   = 256
+	persistAttempts       = 3
+	persistBackoff        = 500 * time.Millisecond
+)
+
+// persistJob is one finished variant to index and copy to the store.
+type persistJob struct {
+	id, name, path string
+	write          uint64 // the job's writeTracker number
+}
+
+// persister indexes and stores finished variants from a bounded queue, so
+// the transform-updates loop acks without waiting on disk or the store.
+// Jobs are independent: a variant's file is read when its job runs, so
+// the order they finish in doesn't matter.
+type persister struct {
+	queue chan persistJob
+}
+
+// persistence lazily starts the persister from the Persist* fields.
+func (s *Server) persistence() *persister {
+	s.persistOnce.Do(func() {
+		n := s.PersistWorkers
+		if n <= 0 {
+			n = defaultPersistWorkers
+		}
+		q := s.PersistQueue
+		if q <= 0 {
+			q = defaultPersistQueue
+		}
+		s.persist = &persister{queue: make(chan persistJob, q)}
+		for i := 0; i < n; i++ {
+			go s.persistLoop(s.persist.queue)
+		}
+	})
+	return s.persist
+}
+
+// persistVariant queues a finished variant. It blocks only when the queue
+// is full, which pushes back on the mailbox instead of buffering without
+// bound. Flush waits for queued jobs too.
+func (s *Server) persistVariant(id, name, path string) {
+	write := s.writes.begin()
+	s.persistence().queue <- persistJob{id: id, name: name, path: path, write: write}
+}
+
+func (s *Server) persistLoop(queue <-chan persistJob) {
+	for job := range queue {
+		s.persistOne(job)
+		s.writes.end(job.write)
+	}
+}
+
+// persistOne indexes a variant's content hash and saves it to the store,
+// retrying failed saves with backoff. Each attempt holds the image's lock
+// and rereads the file, so a variant deleted meanwhile isn't resurrected
+// in the store.
+func (s *Server) persistOne(job persistJob) {
+	backoff := persistBackoff
+	for attempt := 1; ; attempt++ {
+		retry := s.persistAttempt(job, attempt)
+		if !retry {
+			return
+		}
+		if attempt >= persistAttempts {
+			s.log.Error("store save variant: giving up", "image_id", job.id, "variant", job.name, "attempts", attempt)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// persistAttempt makes one attempt and reports whether a retry is due.
+func (s *Server) persistAttempt(job persistJob, attempt int) bool {
+	unlock := s.locks.lock(job.id)
+	defer unlock()
+	s.mu.RLock()
+	gone := s.wasDeleted(job.id)
+	s.mu.RUnlock()
+	if gone {
+		s.log.Debug("variant of deleted image not stored", "image_id", job.id, "variant", job.name)
+		return false
+	}
+	data, err := os.ReadFile(job.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// deleted while queued
+		s.log.Debug("variant gone before it was stored", "image_id", job.id, "variant", job.name)
+		return false
+	}
+	if err != nil {
+		s.log.Error("read variant", "image_id", job.id, "variant", job.name, "err", err)
+		return false
+	}
+	if attempt == 1 {
+		s.indexContent(job.id, job.name, job.path, data)
+	}
+	// Transcodes and renders are cached under the image's id, not the
+	// variant's, so a new rendering drops all of them.
+	if s.Store == nil {
+		s.dropCachedVariants(job.id)
+		return false
+	}
+	defer s.dropCachedVariants(job.id)
+	ok, err := s.storeWrite(context.Background(), func(ctx context.Context) error {
+		return s.Store.SaveVariant(ctx, job.id, job.name, variantContentType(job.path), data)
+	})
+	switch {
+	case err != nil:
+		s.log.Warn("store save variant failed", "image_id", job.id, "variant", job.name, "attempt", attempt, "err", err)
+		return true
+	case !ok:
+		s.log.Warn("store breaker open, variant kept on disk only", "image_id", job.id, "variant", job.name)
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"path/filepath"
+	"testing"
+
+	"example.com/image-factory/pkg/storage"
+)
+
+func TestPersistDropsCachedRenders(t *testing.T) {
+	st, err := storage.NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, st)
+	s.VariantCacheBytes = 1 << 20
+	id, other := testImageID(1), testImageID(2)
+	writeVariants(t, s, id, "thumbnail")
+
+	_, render := s.renderer()
+	cached := []string{
+		"transcode:" + id + "/thumbnail.webp",
+		"transcode:" + id + "/grayscale.png",
+		"transform:" + id + "/thumbnail_w300",
+		"transcode:" + other + "/thumbnail.webp",
+	}
+	for _, key := range cached {
+		render.put(cacheEntry{key: key, data: []byte("old")}, render.generation())
+	}
+	s.variantReads().put(cacheEntry{key: id + "/thumbnail", data: []byte("old")}, s.variantReads().generation())
+
+	s.persistOne(persistJob{id: id, name: "thumbnail", path: filepath.Join(s.imageDir(id), "thumbnail.jpg")})
+
+	if _, ok := s.variantReads().get(id + "/thumbnail"); ok {
+		t.Error("stale variant still cached")
+	}
+	for _, key := range cached[:3] {
+		if _, ok := render.get(key); ok {
+			t.Errorf("%s still cached after the variant was re-rendered", key)
+		}
+	}
+	if _, ok := render.get(cached[3]); !ok {
+		t.Error("another image's transcode was dropped")
+	}
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// progressInterval is how often a running upload reports bytes received.
+const progressInterval = 250 * time.Millisecond
+
+// uploadIDPattern matches the ids clients give uploads they want to follow.
+var uploadIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// uploadProgress is the event /events?upload_id= streams for one upload.
+type uploadProgress struct {
+	Type     string `json:"type"`
+	UploadID string `json:"upload_id"`
+	Bytes    int64  `json:"bytes"`
+	// Total is the request's Content-Length, when the client sent one.
+	Total int64 `json:"total,omitempty"`
+	// Done marks the last event: the body has been read and the upload
+	// either stored as ImageID or rejected.
+	Done    bool   `json:"done,omitempty"`
+	ImageID string `json:"image_id,omitempty"`
+}
+
+// progressHub fans upload progress out to the /events streams following
+// each upload. Followers only care about the latest count, so each gets a
+// one-slot channel that a newer event replaces.
+type progressHub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan uploadProgress]struct{}
+}
+
+func (h *progressHub) subscribe(uploadID string) (chan uploadProgress, func()) {
+	ch := make(chan uploadProgress, 1)
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = make(map[string]map[chan uploadProgress]struct{})
+	}
+	if h.subs[uploadID] == nil {
+		h.subs[uploadID] = make(map[chan uploadProgress]struct{})
+	}
+	h.subs[uploadID][ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs[uploadID], ch)
+		if len(h.subs[uploadID]) == 0 {
+			delete(h.subs, uploadID)
+		}
+		h.mu.Unlock()
+	}
+}
+
+func (h *progressHub) publish(p uploadProgress) {
+	p.Type = "upload_progress"
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[p.UploadID] {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- p
+	}
+}
+
+// progressReader counts an upload's body as it is read, publishing the
+// count at most every progressInterval.
+type progressReader struct {
+	io.ReadCloser
+	hub      *progressHub
+	uploadID string
+	total    int64
+	n        int64
+	last     time.Time
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	p.n += int64(n)
+	if now := time.Now(); now.Sub(p.last) >= progressInterval {
+		p.last = now
+		p.hub.publish(uploadProgress{UploadID: p.uploadID, Bytes: p.n, Total: p.total})
+	}
+	return n, err
+}
+
+// finish publishes the upload's last event, with the image it became, if
+// any.
+func (p *progressReader) finish(imageID string) {
+	p.hub.publish(uploadProgress{UploadID: p.uploadID, Bytes: p.n, Total: p.total, Done: true, ImageID: imageID})
+}
+
+// trackUpload wraps r's body to report progress when the client named the
+// upload with ?upload_id=, returning a func that publishes the final event
+// the first time it's called. ok is false for a malformed upload_id.
+func (s *Server) trackUpload(r *http.Request) (finish func(imageID string), ok bool) {
+	id := r.URL.Query().Get("upload_id")
+	if id == "" {
+		return func(string) {}, true
+	}
+	if !uploadIDPattern.MatchString(id) {
+		return nil, false
+	}
+	pr := &progressReader{ReadCloser: r.Body, hub: &s.progress, uploadID: id, total: r.ContentLength}
+	r.Body = pr
+	var once sync.Once
+	return func(imageID string) { once.Do(func() { pr.finish(imageID) }) }, true
+}
+
+// streamUploadProgress serves /events?upload_id=: the upload's progress
+// events until its done event, then the stream ends.
+func (s *Server) streamUploadProgress(w http.ResponseWriter, r *http.Request, flusher http.Flusher, uploadID string) {
+	ch, unsubscribe := s.progress.subscribe(uploadID)
+	defer unsubscribe()
+	// flush the headers so the client knows it's subscribed before it
+	// starts the upload
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	keep := time.NewTicker(15 * time.Second)
+	defer keep.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.closing:
+			return
+		case <-keep.C:
+			fmt.Fprintf(w, ": keepalive\n\n")
+			flusher.Flush()
+		case p := <-ch:
+			b, err := json.Marshal(p)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "data: %s\n\n", b)
+			flusher.Flush()
+			if p.Done {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Idle client limiters are dropped after limiterIdle, checked every
+// limiterSweep.
+const (
+	limiterIdle  = 3 * time.Minute
+	limiterSweep = time.Minute
+)
+
+// clientLimiters holds a token bucket per client.
+type clientLimiters struct {
+	mu    sync.Mutex
+	m     map[string]*clientLimiter
+	sweep sync.Once
+}
+
+type clientLimiter struct {
+	lim      *rate.Limiter
+	lastSeen time.Time
+}
+
+// reserve takes a token from key's bucket, creating it on first use, and
+// returns how long the caller must wait for one; 0 means go ahead.
+func (c *clientLimiters) reserve(key string, rps float64, burst int) time.Duration {
+	c.sweep.Do(func() { go c.evict() })
+	now := time.Now()
+	c.mu.Lock()
+	if c.m == nil {
+		c.m = make(map[string]*clientLimiter)
+	}
+	cl, ok := c.m[key]
+	if !ok {
+		cl = &clientLimiter{lim: rate.NewLimiter(rate.Limit(rps), burst)}
+		c.m[key] = cl
+	}
+	cl.lastSeen = now
+	c.mu.Unlock()
+
+	res := cl.lim.ReserveN(now, 1)
+	if !res.OK() {
+		return time.Second
+	}
+	d := res.DelayFrom(now)
+	if d > 0 {
+		// rejected requests don't consume a token
+		res.CancelAt(now)
+	}
+	return d
+}
+
+func (c *clientLimiters) evict() {
+	t := time.NewTicker(limiterSweep)
+	defer t.Stop()
+	for now := range t.C {
+		c.mu.Lock()
+		for k, cl := range c.m {
+			if now.Sub(cl.lastSeen) > limiterIdle {
+				delete(c.m, k)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// allowUpload applies the per-client upload rate limit, answering 429 with
+// Retry-After and returning false when the client is over it. Clients are
+// keyed by API key when they send a configured one, otherwise by remote
+// IP, so made-up tokens can't each claim a fresh bucket.
+func (s *Server) allowUpload(w http.ResponseWriter, r *http.Request) bool {
+	if s.UploadRate <= 0 {
+		return true
+	}
+	burst := s.UploadBurst
+	if burst <= 0 {
+		burst = max(1, int(math.Ceil(s.UploadRate)))
+	}
+	key, ok := bearerToken(r)
+	if ok && keyIn(key, s.APIKeys) {
+		key = "key:" + key
+	} else {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		key = "ip:" + host
+	}
+	wait := s.uploadLimits.reserve(key, s.UploadRate, burst)
+	if wait <= 0 {
+		return true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeJSONError(w, http.StatusTooManyRequests, codeRateLimited, "rate limit exceeded")
+	return false
+}
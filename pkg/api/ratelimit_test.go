@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowUploadKeys(t *testing.T) {
+	upload := func(s *Server, ip, token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/upload", nil)
+		req.RemoteAddr = ip + ":1234"
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		if !s.allowUpload(rec, req) {
+			return rec.Code
+		}
+		return http.StatusOK
+	}
+
+	t.Run("unknown tokens share the IP's bucket", func(t *testing.T) {
+		s := newTestServer(t, nil)
+		s.UploadRate, s.UploadBurst = 0.001, 1
+		if code := upload(s, "10.0.0.1", "random-1"); code != http.StatusOK {
+			t.Fatalf("first upload: %d", code)
+		}
+		if code := upload(s, "10.0.0.1", "random-2"); code != http.StatusTooManyRequests {
+			t.Errorf("second upload with a new token: %d, want 429", code)
+		}
+		if code := upload(s, "10.0.0.2", "random-3"); code != http.StatusOK {
+			t.Errorf("upload from another IP: %d", code)
+		}
+	})
+
+	t.Run("configured keys get their own bucket", func(t *testing.T) {
+		s := newTestServer(t, nil)
+		s.UploadRate, s.UploadBurst = 0.001, 1
+		s.APIKeys = []string{"alpha", "beta"}
+		if code := upload(s, "10.0.0.1", "alpha"); code != http.StatusOK {
+			t.Fatalf("alpha: %d", code)
+		}
+		if code := upload(s, "10.0.0.1", "beta"); code != http.StatusOK {
+			t.Errorf("beta from the same IP: %d", code)
+		}
+		if code := upload(s, "10.0.0.2", "alpha"); code != http.StatusTooManyRequests {
+			t.Errorf("alpha from another IP: %d, want 429", code)
+		}
+	})
+}
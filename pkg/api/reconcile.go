@@ -0,0 +1,236 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"example.com/image-factory/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Counterparts of the /admin/reconcile stats.
+var (
+	reconcileRuns = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "imgsvc_reconcile_runs_total",
+		Help: "Completed passes of the store/disk reconciler.",
+	})
+	reconcileImagesChecked = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "imgsvc_reconcile_images_checked_total",
+		Help: "Images the reconciler compared between disk and the store.",
+	})
+	reconcileMissing = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "imgsvc_reconcile_missing_total",
+		Help: "Variants the reconciler found on only one side, by the side missing them (store or disk).",
+	}, []string{"missing_in"})
+	reconcileFixed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "imgsvc_reconcile_fixed_total",
+		Help: "Variants the reconciler copied to the side missing them.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(reconcileRuns, reconcileImagesChecked, reconcileMissing, reconcileFixed)
+	reconcileMissing.WithLabelValues("store")
+	reconcileMissing.WithLabelValues("disk")
+}
+
+// reconcileStats counts what the background verifier found and repaired.
+type reconcileStats struct {
+	Runs           int       `json:"runs"`
+	ImagesChecked  int       `json:"images_checked"`
+	MissingInStore int       `json:"missing_in_store"`
+	MissingOnDisk  int       `json:"missing_on_disk"`
+	Fixed          int       `json:"fixed"`
+	LastRun        time.Time `json:"last_run"`
+}
+
+// RunReconciler periodically compares the variants on disk with the ones in the
+// store and re-saves whichever side is missing. It visits at most perSecond
+// images per second so a large library doesn't hammer the store. It returns
+// when ctx is done; it is a no-op without a store.
+func (s *Server) RunReconciler(ctx context.Context, every time.Duration, perSecond float64) {
+	if s.Store == nil || every <= 0 {
+		return
+	}
+	if perSecond <= 0 {
+		perSecond = 1
+	}
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.reconcileOnce(ctx, time.Duration(float64(time.Second)/perSecond))
+		}
+	}
+}
+
+func (s *Server) reconcileOnce(ctx context.Context, pace time.Duration) {
+	ids := s.knownImageIDs()
+	tick := time.NewTicker(pace)
+	defer tick.Stop()
+	var checked, missingStore, missingDisk, fixed int
+	for _, id := range ids {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		ms, md, fx := s.reconcileImage(ctx, id)
+		checked++
+		missingStore += ms
+		missingDisk += md
+		fixed += fx
+	}
+	s.mu.Lock()
+	s.reconcile.Runs++
+	s.reconcile.ImagesChecked += checked
+	s.reconcile.MissingInStore += missingStore
+	s.reconcile.MissingOnDisk += missingDisk
+	s.reconcile.Fixed += fixed
+	s.reconcile.LastRun = time.Now()
+	s.mu.Unlock()
+	reconcileRuns.Inc()
+	reconcileImagesChecked.Add(float64(checked))
+	reconcileMissing.WithLabelValues("store").Add(float64(missingStore))
+	reconcileMissing.WithLabelValues("disk").Add(float64(missingDisk))
+	reconcileFixed.Add(float64(fixed))
+	if missingStore+missingDisk > 0 {
+		s.log.Info("reconcile done", "checked", checked, "missing_in_store", missingStore, "missing_on_disk", missingDisk, "fixed", fixed)
+	}
+}
+
+// reconcileImage repairs a single image and reports what it found. It
+// holds the image's lock throughout, and leaves alone an image whose
+// directory or stored row is gone: that's a delete, not a gap to repair.
+func (s *Server) reconcileImage(ctx context.Context, id string) (missingStore, missingDisk, fixed int) {
+	unlock := s.locks.lock(id)
+	defer unlock()
+	dir := s.imageDir(id)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			s.log.Warn("reconcile: read dir", "image_id", id, "err", err)
+		}
+		return 0, 0, 0
+	}
+	if _, err := s.Store.GetImageMetadata(ctx, id); err != nil {
+		if !storage.IsNotFound(err) {
+			s.log.Warn("reconcile: image metadata", "image_id", id, "err", err)
+		}
+		return 0, 0, 0
+	}
+	onDisk := map[string]string{} // op -> path
+	for _, e := range entries {
+		// skip in-progress atomic writes (.name.tmpNNN)
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		op := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+		if op == "original" {
+			continue
+		}
+		onDisk[op] = filepath.Join(dir, e.Name())
+	}
+	stored, err := s.Store.ListOps(ctx, id)
+	if err != nil {
+		s.log.Warn("reconcile: list ops", "image_id", id, "err", err)
+		return 0, 0, 0
+	}
+	inStore := map[string]bool{}
+	for _, op := range stored {
+		inStore[op] = true
+	}
+
+	for op, path := range onDisk {
+		if inStore[op] {
+			continue
+		}
+		missingStore++
+		data, err := os.ReadFile(path)
+		if err != nil {
+			s.log.Warn("reconcile: read", "image_id", id, "op", op, "err", err)
+			continue
+		}
+		if err := s.Store.SaveVariant(ctx, id, op, variantContentType(path), data); err != nil {
+			s.log.Warn("reconcile: save", "image_id", id, "op", op, "err", err)
+			continue
+		}
+		fixed++
+	}
+	for op := range inStore {
+		if _, ok := onDisk[op]; ok {
+			continue
+		}
+		missingDisk++
+		data, ct, err := s.Store.GetVariant(ctx, id, op)
+		if err != nil {
+			s.log.Warn("reconcile: fetch", "image_id", id, "op", op, "err", err)
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, op+extForContentType(ct)), data, 0644); err != nil {
+			s.log.Warn("reconcile: write", "image_id", id, "op", op, "err", err)
+			continue
+		}
+		fixed++
+	}
+	return missingStore, missingDisk, fixed
+}
+
+// knownImageIDs returns the ids present on disk or in the in-memory index.
+func (s *Server) knownImageIDs() []string {
+	seen := map[string]bool{}
+	ids := []string{}
+	for _, id := range s.diskImageIDs() {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	s.mu.RLock()
+	for id := range s.variants {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	s.mu.RUnlock()
+	return ids
+}
+
+// extForContentType maps a stored content type back to a file extension.
+func extForContentType(ct string) string {
+	switch ct {
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	case "image/bmp":
+		return ".bmp"
+	case "image/tiff":
+		return ".tiff"
+	case "application/json":
+		return ".json"
+	default:
+		return ".jpg"
+	}
+}
+
+func (s *Server) handleReconcileStats(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	st := s.reconcile
+	s.mu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
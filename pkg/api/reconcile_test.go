@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"example.com/image-factory/pkg/storage"
+)
+
+func TestReconcileImage(t *testing.T) {
+	ctx := context.Background()
+	st, err := storage.NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, st)
+	id := testImageID(1)
+	writeVariants(t, s, id, "original", "thumbnail")
+	// a worker's write still in progress isn't a variant
+	if err := os.WriteFile(filepath.Join(s.imageDir(id), ".thumbnail.jpg.tmp123"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SaveOriginal(ctx, id, ".jpg", []byte("original")); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SaveVariant(ctx, id, "blur", "image/jpeg", []byte("blur")); err != nil {
+		t.Fatal(err)
+	}
+
+	ms, md, fixed := s.reconcileImage(ctx, id)
+	if ms != 1 || md != 1 || fixed != 2 {
+		t.Errorf("reconcile = %d missing in store, %d on disk, %d fixed; want 1, 1, 2", ms, md, fixed)
+	}
+	if got, _ := st.ListOps(ctx, id); !slices.Equal(got, []string{"blur", "thumbnail"}) {
+		t.Errorf("stored ops = %v, want [blur thumbnail]", got)
+	}
+	if _, err := os.Stat(filepath.Join(s.imageDir(id), "blur.jpg")); err != nil {
+		t.Errorf("blur not restored to disk: %v", err)
+	}
+}
+
+// TestReconcileSkipsDeleted checks the reconciler leaves alone an image
+// whose directory or stored row is gone, rather than restoring it.
+func TestReconcileSkipsDeleted(t *testing.T) {
+	ctx := context.Background()
+	st, err := storage.NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, st)
+
+	noDir := testImageID(1)
+	if err := st.SaveOriginal(ctx, noDir, ".jpg", []byte("original")); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SaveVariant(ctx, noDir, "blur", "image/jpeg", []byte("blur")); err != nil {
+		t.Fatal(err)
+	}
+	noRow := testImageID(2)
+	writeVariants(t, s, noRow, "original", "thumbnail")
+
+	for _, id := range []string{noDir, noRow} {
+		if ms, md, fixed := s.reconcileImage(ctx, id); ms+md+fixed != 0 {
+			t.Errorf("%s: reconcile = %d, %d, %d; want nothing done", id, ms, md, fixed)
+		}
+	}
+	if _, err := os.Stat(s.imageDir(noDir)); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("deleted image's dir recreated (%v)", err)
+	}
+	if got, _ := st.ListOps(ctx, noRow); len(got) != 0 {
+		t.Errorf("deleted image's variants stored: %v", got)
+	}
+}
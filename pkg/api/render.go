@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Defaults for on-the-fly rendering, and the bound on a shared render,
+// which outlives any one caller giving up on it.
+const (
+	defaultRenderQueue      = 16
+	defaultRenderCacheBytes = 64 << 20
+	renderTimeout           = time.Minute
+)
+
+// errRenderBusy means every render slot and queue position is taken; the
+// handler answers 429.
+var errRenderBusy = errors.New("render queue full")
+
+var (
+	renderQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "imgsvc_render_queue_depth",
+		Help: "On-the-fly renders waiting for a slot.",
+	})
+	renderInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "imgsvc_render_in_flight",
+		Help: "On-the-fly renders currently running.",
+	})
+	renderRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "imgsvc_render_rejected_total",
+		Help: "On-the-fly renders refused because the queue was full.",
+	})
+	renderCacheResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "imgsvc_render_cache_requests_total",
+		Help: "On-the-fly render cache lookups, by result (hit or miss).",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(renderQueueDepth, renderInFlight, renderRejected, renderCacheResults)
+}
+
+// renderLimiter bounds concurrent on-the-fly renders separately from the
+// async pipeline: up to slots run at once, up to queue more wait, and the
+// rest are turned away.
+type renderLimiter struct {
+	slots   chan struct{}
+	waiting chan struct{}
+}
+
+func newRenderLimiter(concurrency, queue int) *renderLimiter {
+	return &renderLimiter{
+		slots:   make(chan struct{}, concurrency),
+		waiting: make(chan struct{}, concurrency+queue),
+	}
+}
+
+// acquire waits for a render slot and returns its release function, or
+// errRenderBusy when the queue is full.
+func (l *renderLimiter) acquire(ctx context.Context) (func(), error) {
+	select {
+	case l.waiting <- struct{}{}:
+	default:
+		renderRejected.Inc()
+		return nil, errRenderBusy
+	}
+	renderQueueDepth.Inc()
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		renderQueueDepth.Dec()
+		<-l.waiting
+		return nil, ctx.Err()
+	}
+	renderQueueDepth.Dec()
+	renderInFlight.Inc()
+	return func() {
+		renderInFlight.Dec()
+		<-l.slots
+		<-l.waiting
+	}, nil
+}
+
+// renderer lazily builds the limiter and cache from the Render* fields.
+func (s *Server) renderer() (*renderLimiter, *byteCache) {
+	s.renderOnce.Do(func() {
+		n := s.RenderConcurrency
+		if n <= 0 {
+			n = runtime.GOMAXPROCS(0)
+		}
+		q := s.RenderQueue
+		if q < 0 {
+			q = 0
+		} else if q == 0 {
+			q = defaultRenderQueue
+		}
+		b := s.RenderCacheBytes
+		if b <= 0 {
+			b = defaultRenderCacheBytes
+		}
+		s.renderLimit = newRenderLimiter(n, q)
+		s.renderCache = newByteCache(b, renderCacheResults)
+	})
+	return s.renderLimit, s.renderCache
+}
+
+// render returns the cached output for key, or runs fn under the render
+// limiter and caches what it produces. Concurrent misses on the same key
+// share one run of fn, on a context detached from theirs and bounded by
+// renderTimeout, so the first caller leaving doesn't fail the rest; each
+// caller stops waiting when its own ctx is done. A miss after the cache
+// dropped entries starts its own run, since the shared one may have read
+// what was dropped.
+func (s *Server) render(ctx context.Context, key string, fn func(context.Context) ([]byte, string, error)) ([]byte, string, error) {
+	limit, cache := s.renderer()
+	if e, ok := cache.get(key); ok {
+		return e.data, e.contentType, nil
+	}
+	gen := cache.generation()
+	ch := s.renderGroup.DoChan(strconv.FormatUint(gen, 10)+":"+key, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), renderTimeout)
+		defer cancel()
+		release, err := limit.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		data, ct, err := fn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		e := cacheEntry{key: key, data: data, contentType: ct}
+		cache.put(e, gen)
+		return e, nil
+	})
+	select {
+	case r := <-ch:
+		if r.Err != nil {
+			return nil, "", r.Err
+		}
+		e := r.Val.(cacheEntry)
+		return e.data, e.contentType, nil
+	case <-ctx.Done():
+		return nil, "", ctx.Err()
+	}
+}
+
+// writeRenderError answers a failed render: 429 when the render queue is
+// full, 502 otherwise.
+func writeRenderError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errRenderBusy) {
+		writeJSONError(w, http.StatusTooManyRequests, codeBusy, err.Error())
+		return
+	}
+	writeJSONError(w, http.StatusBadGateway, codeUpstream, err.Error())
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRenderCollapsesConcurrentMisses(t *testing.T) {
+	s := newTestServer(t, nil)
+	const callers = 8
+	var runs atomic.Int32
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	fn := func(context.Context) ([]byte, string, error) {
+		if runs.Add(1) == 1 {
+			close(started)
+		}
+		<-unblock
+		return []byte("rendered"), "image/png", nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, ct, err := s.render(context.Background(), "id/thumbnail.png", fn)
+			if err == nil && (string(data) != "rendered" || ct != "image/png") {
+				t.Errorf("render = %q, %q", data, ct)
+			}
+			errs <- err
+		}()
+	}
+	<-started
+	// Give the other callers time to miss the cache and join the render.
+	time.Sleep(20 * time.Millisecond)
+	close(unblock)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("render: %v", err)
+		}
+	}
+	if n := runs.Load(); n != 1 {
+		t.Errorf("fn ran %d times, want 1", n)
+	}
+
+	// A later call is served from the cache without running fn.
+	if _, _, err := s.render(context.Background(), "id/thumbnail.png", fn); err != nil {
+		t.Fatal(err)
+	}
+	if n := runs.Load(); n != 1 {
+		t.Errorf("fn ran %d times after a cached render, want 1", n)
+	}
+}
+
+func TestRenderDroppedMidRender(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.RenderConcurrency = 2
+	id := testImageID(1)
+	key := "transform:" + id + "/w100_h100.png"
+	var runs atomic.Int32
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	stale := func(context.Context) ([]byte, string, error) {
+		runs.Add(1)
+		close(started)
+		<-unblock
+		return []byte("old"), "image/png", nil
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.render(context.Background(), key, stale)
+	}()
+	<-started
+	// The image is re-rendered while the old render is still reading.
+	s.dropCachedVariants(id)
+	fresh := func(context.Context) ([]byte, string, error) {
+		runs.Add(1)
+		return []byte("new"), "image/png", nil
+	}
+	if data, _, err := s.render(context.Background(), key, fresh); err != nil || string(data) != "new" {
+		t.Errorf("render after the drop = %q, %v; want a fresh render, not the one in flight", data, err)
+	}
+	close(unblock)
+	<-done
+
+	if data, _, err := s.render(context.Background(), key, fresh); err != nil || string(data) != "new" {
+		t.Errorf("render = %q, %v; the stale render was cached after the drop", data, err)
+	}
+	if n := runs.Load(); n != 2 {
+		t.Errorf("fn ran %d times, want 2", n)
+	}
+}
+
+// TestRenderFirstCallerCancels has the caller that started a shared render
+// give up: it returns at once, and the caller that joined still gets the
+// render.
+func TestRenderFirstCallerCancels(t *testing.T) {
+	s := newTestServer(t, nil)
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	fn := func(ctx context.Context) ([]byte, string, error) {
+		close(started)
+		select {
+		case <-unblock:
+			return []byte("rendered"), "image/png", nil
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, _, err := s.render(ctx, "id/thumbnail.png", fn)
+		first <- err
+	}()
+	<-started
+	second := make(chan error, 1)
+	go func() {
+		data, _, err := s.render(context.Background(), "id/thumbnail.png", fn)
+		if err == nil && string(data) != "rendered" {
+			t.Errorf("joined render = %q", data)
+		}
+		second <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled caller: err = %v, want context.Canceled", err)
+	}
+	close(unblock)
+	if err := <-second; err != nil {
+		t.Errorf("joined caller failed with the first one's cancellation: %v", err)
+	}
+}
@@ -0,0 +1,411 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"example.com/image-factory/pkg/messages"
+	"example.com/image-factory/pkg/ops"
+	"example.com/image-factory/pkg/storage"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lytics/grid/v3"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Defaults for the bulk reprocess prefetcher.
+const (
+	defaultPrefetchDepth    = 8
+	defaultPrefetchMaxBytes = 256 << 20
+)
+
+// reprocessStats counts bulk reprocess progress and how well the prefetcher
+// kept ahead: a hit means the next original was already buffered, a wait
+// means dispatch stalled on a store read.
+type reprocessStats struct {
+	Runs          int           `json:"runs"`
+	Dispatched    int           `json:"dispatched"`
+	Failed        int           `json:"failed"`
+	PrefetchHits  int           `json:"prefetch_hits"`
+	PrefetchWaits int           `json:"prefetch_waits"`
+	WaitTime      time.Duration `json:"wait_time_ns"`
+	Running       bool          `json:"running"`
+}
+
+// prefetched is one original read ahead of dispatch. data is nil when the
+// original was already on disk; reserved is what it holds of the prefetch
+// budget until dispatched.
+type prefetched struct {
+	id       string
+	path     string
+	data     []byte
+	reserved int64
+	err      error
+}
+
+// byteBudget bounds how many prefetched bytes are buffered at once. A single
+// item larger than the budget is still admitted, on its own.
+type byteBudget struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	max  int64
+	used int64
+}
+
+func newByteBudget(max int64) *byteBudget {
+	b := &byteBudget{max: max}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *byteBudget) acquire(n int64) {
+	b.mu.Lock()
+	for b.used > 0 && b.used+n > b.max {
+		b.cond.Wait()
+	}
+	b.used += n
+	b.mu.Unlock()
+}
+
+func (b *byteBudget) release(n int64) {
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// prefetchOriginals reads originals for ids with up to depth concurrent
+// store reads. Each read reserves its size against budget before it starts
+// and holds it until the consumer releases p.reserved, so buffered
+// originals stay within the budget. Results arrive in completion order; the
+// channel closes once every id has been delivered.
+func (s *Server) prefetchOriginals(ctx context.Context, ids []string, depth int, budget *byteBudget) <-chan prefetched {
+	next := make(chan string)
+	out := make(chan prefetched, depth)
+	go func() {
+		defer close(next)
+		for _, id := range ids {
+			select {
+			case next <- id:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < depth; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range next {
+				out <- s.prefetchOriginal(ctx, id, budget)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// prefetchOriginal fetches one original against budget. The reservation
+// is the size the store's metadata gives; should the read come back larger
+// (no metadata, or stale), the rest is reserved before it's handed on.
+func (s *Server) prefetchOriginal(ctx context.Context, id string, budget *byteBudget) prefetched {
+	if path, ok := s.localOriginal(id); ok {
+		return prefetched{id: id, path: path}
+	}
+	var reserve int64
+	if s.Store != nil {
+		if md, err := s.Store.GetImageMetadata(ctx, id); err == nil {
+			reserve = md.OriginalBytes
+		}
+	}
+	budget.acquire(reserve)
+	p := s.fetchOriginal(ctx, id)
+	if extra := int64(len(p.data)) - reserve; extra > 0 {
+		budget.acquire(extra)
+		reserve += extra
+	}
+	p.reserved = reserve
+	return p
+}
+
+// localOriginal returns the path of an image's original on disk.
+func (s *Server) localOriginal(id string) (string, bool) {
+	matches, _ := filepath.Glob(filepath.Join(s.imageDir(id), "original.*"))
+	if len(matches) == 0 {
+		return "", false
+	}
+	return matches[0], true
+}
+
+// fetchOriginal locates an image's original on disk, or reads it from the
+// store when the local copy is gone.
+func (s *Server) fetchOriginal(ctx context.Context, id string) prefetched {
+	if path, ok := s.localOriginal(id); ok {
+		return prefetched{id: id, path: path}
+	}
+	if s.Store == nil {
+		return prefetched{id: id, err: os.ErrNotExist}
+	}
+	data, ext, err := s.Store.GetOriginal(ctx, id)
+	if err != nil {
+		return prefetched{id: id, err: err}
+	}
+	return prefetched{id: id, path: filepath.Join(s.imageDir(id), "original"+ext), data: data}
+}
+
+// Reprocess re-sends every listed image (all known images when ids is
+// empty) to the coordinator, fetching originals ahead of dispatch.
+func (s *Server) Reprocess(ctx context.Context, ids []string) {
+	if len(ids) == 0 {
+		ids = s.knownImageIDs()
+	}
+	depth := s.PrefetchDepth
+	if depth <= 0 {
+		depth = defaultPrefetchDepth
+	}
+	maxBytes := s.PrefetchMaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultPrefetchMaxBytes
+	}
+
+	s.mu.Lock()
+	s.reprocess.Runs++
+	s.reprocess.Running = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.reprocess.Running = false
+		s.mu.Unlock()
+	}()
+
+	client, err := grid.NewClient(s.Etcd, grid.ClientCfg{Namespace: s.Namespace})
+	if err != nil {
+		s.log.Error("reprocess grid client", "err", err)
+		return
+	}
+	defer client.Close()
+
+	budget := newByteBudget(maxBytes)
+	in := s.prefetchOriginals(ctx, ids, depth, budget)
+	for {
+		var p prefetched
+		var ok bool
+		hit := true
+		start := time.Now()
+		select {
+		case p, ok = <-in:
+		default:
+			hit = false
+			p, ok = <-in
+		}
+		if !ok {
+			return
+		}
+		s.mu.Lock()
+		if hit {
+			s.reprocess.PrefetchHits++
+		} else {
+			s.reprocess.PrefetchWaits++
+			s.reprocess.WaitTime += time.Since(start)
+		}
+		s.mu.Unlock()
+		err := p.err
+		if err == nil {
+			err = s.redispatch(ctx, client, p)
+		}
+		budget.release(p.reserved)
+		s.mu.Lock()
+		if err != nil {
+			s.log.Warn("reprocess", "image_id", p.id, "err", err)
+			s.reprocess.Failed++
+		} else {
+			s.reprocess.Dispatched++
+		}
+		s.mu.Unlock()
+	}
+}
+
+// redispatch restores a fetched original to disk if needed and sends a
+// fresh upload event for it. Bulk reprocessing isn't latency-sensitive, so
+// it asks for the smallest output.
+func (s *Server) redispatch(ctx context.Context, client *grid.Client, p prefetched) error {
+	unlock := s.locks.lock(p.id)
+	defer unlock()
+	if err := s.restoreOriginal(p); err != nil {
+		return err
+	}
+	s.dropCachedVariants(p.id)
+	_, err := client.RequestC(ctx, "uploads", &messages.UploadEvent{
+		ImageId: p.id,
+		Path:    p.path,
+		Effort:  ops.MaxEffort,
+		EventId: uuid.New().String(),
+	})
+	return err
+}
+
+// restoreOriginal writes a fetched original back to disk when it came from
+// the store, so workers on this host can read it. Callers hold the image's
+// lock.
+func (s *Server) restoreOriginal(p prefetched) error {
+	if p.data == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(p.path, p.data, 0644)
+}
+
+// POST /images/{id}/reprocess {ops?, params?, format?, quality?} re-runs an
+// existing image's transforms from its original: the listed ops (else the
+// defaults, plus any op given params) with the given params.
+func (s *Server) handleReprocessImage(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var body struct {
+		Ops     []string                  `json:"ops"`
+		Params  map[string]map[string]any `json:"params"`
+		Format  string                    `json:"format"`
+		Quality int32                     `json:"quality"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSONError(w, http.StatusBadRequest, codeBadRequest, "bad json")
+			return
+		}
+	}
+	if body.Format != "" {
+		f, ok := ops.NormalizeFormat(body.Format)
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, codeUnsupportedFormat, "unsupported format")
+			return
+		}
+		body.Format = f
+	}
+	if body.Quality < 0 || body.Quality > 100 {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidParam, "invalid quality")
+		return
+	}
+	params := map[string]*structpb.Struct{}
+	for op, p := range body.Params {
+		if _, ok := ops.Lookup(op); !ok {
+			writeJSONError(w, http.StatusBadRequest, codeUnknownOp, "unknown op "+op)
+			return
+		}
+		st, err := structpb.NewStruct(p)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidParam, "invalid params for "+op)
+			return
+		}
+		params[op] = st
+	}
+	if err := validateParams(params); err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidParam, err.Error())
+		return
+	}
+	hasParams := func(op string) bool {
+		_, ok := params[op]
+		return ok
+	}
+	selected, unknown := ops.Select(body.Ops, hasParams)
+	if len(unknown) > 0 {
+		writeJSONError(w, http.StatusBadRequest, codeUnknownOp, "unknown op "+unknown[0])
+		return
+	}
+
+	// Held until the event is sent, so a delete can't remove the original
+	// out from under the dispatch or race it on the image's state.
+	unlock := s.locks.lock(id)
+	defer unlock()
+	p := s.fetchOriginal(r.Context(), id)
+	switch {
+	case errors.Is(p.err, os.ErrNotExist) || storage.IsNotFound(p.err):
+		writeNotFound(w, "image not found")
+		return
+	case p.err != nil:
+		s.log.Error("reprocess: fetch original", "image_id", id, "err", p.err)
+		writeJSONError(w, http.StatusServiceUnavailable, codeUnavailable, "store unavailable")
+		return
+	}
+	if err := s.restoreOriginal(p); err != nil {
+		s.log.Error("reprocess: restore original", "image_id", id, "err", err)
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "restore failed")
+		return
+	}
+
+	s.mu.Lock()
+	s.expected[id] = s.variantNames(selected, hasParams)
+	s.mu.Unlock()
+	s.dropCachedVariants(id)
+	err := s.dispatch(r.Context(), &messages.UploadEvent{
+		ImageId: id,
+		Path:    p.path,
+		Format:  body.Format,
+		Params:  params,
+		Ops:     body.Ops,
+		Quality: body.Quality,
+		EventId: uuid.New().String(),
+	})
+	if err != nil {
+		s.log.Error("reprocess request", "image_id", id, "err", err)
+		writeJSONError(w, http.StatusServiceUnavailable, codeUnavailable, "dispatch failed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]any{"image_id": id, "ops": selected})
+}
+
+// Admin reprocess: POST /admin/reprocess {ids?: [...]}; runs in the background.
+func (s *Server) handleReprocess(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		IDs []string `json:"ids"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSONError(w, http.StatusBadRequest, codeBadRequest, "bad json")
+			return
+		}
+	}
+	for _, id := range body.IDs {
+		if !validImageID(id) {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidID, fmt.Sprintf("invalid image id %q", id))
+			return
+		}
+	}
+	s.mu.Lock()
+	running := s.reprocess.Running
+	s.reprocess.Running = true
+	s.mu.Unlock()
+	if running {
+		writeJSONError(w, http.StatusConflict, codeConflict, "reprocess already running")
+		return
+	}
+	ids := body.IDs
+	if len(ids) == 0 {
+		ids = s.knownImageIDs()
+	}
+	go s.Reprocess(s.GridSrv.Context(), ids)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]int{"queued": len(ids)})
+}
+
+func (s *Server) handleReprocessStats(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	st := s.reprocess
+	s.mu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
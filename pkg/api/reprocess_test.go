@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/image-factory/pkg/messages"
+	"example.com/image-factory/pkg/storage"
+)
+
+// countingStore counts original reads, and tracks how many original bytes
+// it has handed out and not yet had back through done.
+type countingStore struct {
+	storage.Store
+	mu       sync.Mutex
+	reads    int
+	live     int64
+	peakLive int64
+}
+
+func (c *countingStore) GetOriginal(ctx context.Context, id string) ([]byte, string, error) {
+	data, ext, err := c.Store.GetOriginal(ctx, id)
+	c.mu.Lock()
+	c.reads++
+	c.live += int64(len(data))
+	c.peakLive = max(c.peakLive, c.live)
+	c.mu.Unlock()
+	return data, ext, err
+}
+
+func (c *countingStore) done(n int) {
+	c.mu.Lock()
+	c.live -= int64(n)
+	c.mu.Unlock()
+}
+
+func TestPrefetchOriginalsBudget(t *testing.T) {
+	disk, err := storage.NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &countingStore{Store: disk}
+	s := newTestServer(t, st)
+	const size, budget = 1000, 2500
+	var ids []string
+	for i := 0; i < 12; i++ {
+		id := testImageID(i)
+		ids = append(ids, id)
+		if err := disk.SaveOriginal(context.Background(), id, ".jpg", make([]byte, size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := newByteBudget(budget)
+	got := 0
+	for p := range s.prefetchOriginals(context.Background(), ids, 8, b) {
+		if p.err != nil || len(p.data) != size {
+			t.Fatalf("%s: %d bytes, err %v", p.id, len(p.data), p.err)
+		}
+		// a slow consumer: every reader that can get ahead has
+		time.Sleep(2 * time.Millisecond)
+		st.done(len(p.data))
+		b.release(p.reserved)
+		got++
+	}
+	if got != len(ids) {
+		t.Fatalf("prefetched %d originals, want %d", got, len(ids))
+	}
+	if st.peakLive > budget {
+		t.Errorf("%d bytes buffered at once, budget %d", st.peakLive, budget)
+	}
+}
+
+func TestReprocessImageValidatesParams(t *testing.T) {
+	s := newTestServer(t, nil)
+	id := testImageID(1)
+	writeVariants(t, s, id, "original")
+	var sent []*messages.UploadEvent
+	s.dispatch = func(ctx context.Context, ev *messages.UploadEvent) error {
+		sent = append(sent, ev)
+		return nil
+	}
+	for _, tt := range []struct {
+		body   string
+		status int
+	}{
+		{`{"params": {"thumbnail": {"width": 300}}}`, http.StatusAccepted},
+		{`{"params": {"thumbnail": {"width": 100000, "height": 100000}}}`, http.StatusBadRequest},
+		{`{"params": {"resize": {"width": -5}}}`, http.StatusBadRequest},
+		{`{"params": {"blur": {"radius": "soft"}}}`, http.StatusBadRequest},
+		{`{"params": {"watermark": {"text": "` + strings.Repeat("x", 201) + `"}}}`, http.StatusBadRequest},
+	} {
+		sent = nil
+		rec := httptest.NewRecorder()
+		s.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/images/"+id+"/reprocess", strings.NewReader(tt.body)))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.body, rec.Code, tt.status, rec.Body)
+		}
+		if rec.Code != http.StatusAccepted && len(sent) > 0 {
+			t.Errorf("%s: rejected request still dispatched", tt.body)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/image-factory/pkg/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// flakyStore answers its first `fails` variant reads with a GCS 503.
+type flakyStore struct {
+	storage.Store
+	mu    sync.Mutex
+	fails int
+	reads int
+}
+
+func (f *flakyStore) GetVariant(ctx context.Context, id, op string) ([]byte, string, error) {
+	f.mu.Lock()
+	f.reads++
+	fail := f.reads <= f.fails
+	f.mu.Unlock()
+	if fail {
+		return nil, "", &googleapi.Error{Code: http.StatusServiceUnavailable}
+	}
+	return f.Store.GetVariant(ctx, id, op)
+}
+
+// gatedStore reads each variant, tells started, and holds the result until
+// gate is closed.
+type gatedStore struct {
+	storage.Store
+	started chan struct{}
+	gate    chan struct{}
+}
+
+func (g *gatedStore) GetVariant(ctx context.Context, id, op string) ([]byte, string, error) {
+	data, ct, err := g.Store.GetVariant(ctx, id, op)
+	g.started <- struct{}{}
+	<-g.gate
+	return data, ct, err
+}
+
+func TestGetVariantDroppedMidRead(t *testing.T) {
+	disk, err := storage.NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := testImageID(1)
+	ctx := context.Background()
+	if err := disk.SaveVariant(ctx, id, "thumbnail", "image/jpeg", []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	st := &gatedStore{Store: disk, started: make(chan struct{}, 2), gate: make(chan struct{})}
+	s := newTestServer(t, st)
+	s.VariantCacheBytes = 1 << 20
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.getVariant(ctx, id, "thumbnail")
+	}()
+	<-st.started
+	// The variant is replaced while the read of the old one is in flight.
+	if err := disk.SaveVariant(ctx, id, "thumbnail", "image/jpeg", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	s.dropCachedVariants(id)
+	// The in-flight read now returns the old bytes.
+	close(st.gate)
+	<-done
+
+	data, _, err := s.getVariant(ctx, id, "thumbnail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new" {
+		t.Errorf("getVariant = %q after the drop, want %q", data, "new")
+	}
+}
+
+func TestServeVariantRetriesStoreRead(t *testing.T) {
+	want, err := os.ReadFile(writeJPEG(t, 8, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := testImageID(1)
+	for _, tt := range []struct {
+		name          string
+		retries, fail int
+		op            string
+		status, reads int
+	}{
+		{"fails once, retried", 2, 1, "thumbnail", http.StatusOK, 2},
+		// with no local copy the disk fallback has nothing to serve
+		{"fails once, no retries", 0, 1, "thumbnail", http.StatusNotFound, 1},
+		{"fails past the retries", 1, 5, "thumbnail", http.StatusNotFound, 2},
+		{"not found isn't retried", 2, 0, "blur", http.StatusNotFound, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			disk, err := storage.NewDiskStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := disk.SaveVariant(context.Background(), id, "thumbnail", "image/jpeg", want); err != nil {
+				t.Fatal(err)
+			}
+			st := &flakyStore{Store: disk, fails: tt.fail}
+			s := newTestServer(t, st)
+			s.StoreReadRetries, s.StoreReadBackoff = tt.retries, time.Millisecond
+
+			rec := get(t, s, "/images/"+id+"/"+tt.op, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status == http.StatusOK && !bytes.Equal(rec.Body.Bytes(), want) {
+				t.Error("served bytes differ from the stored variant")
+			}
+			if st.reads != tt.reads {
+				t.Errorf("store read %d times, want %d", st.reads, tt.reads)
+			}
+		})
+	}
+}
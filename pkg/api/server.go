@@ -1,36 +1,175 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
-	_ "example.com/image-factory/pkg/messages" // ensure message type registration when API used standalone
+	"example.com/image-factory/pkg/imageops"
+	"example.com/image-factory/pkg/messages"
+	"example.com/image-factory/pkg/ops"
 	"example.com/image-factory/pkg/storage"
+	"example.com/image-factory/pkg/tracing"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/lytics/grid/v3"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	etcdv3 "go.etcd.io/etcd/client/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 	"google.golang.org/protobuf/types/known/structpb"
 )
 
+// defaultMaxUploadBytes applies when MaxUploadBytes is unset.
+const defaultMaxUploadBytes = 20 << 20
+
 // Server implements HTTP API.
 
 type Server struct {
 	Etcd      *etcdv3.Client
 	Namespace string
 	GridSrv   *grid.Server
-	Store     *storage.SpannerStore // optional
+	Store     storage.Store // optional
+
+	// WaitForOp makes /upload block until this op's variant exists (or
+	// WaitTimeout passes) and return its URL; "" disables. Clients can
+	// override it per request with the wait_for field.
+	WaitForOp   string
+	WaitTimeout time.Duration
+
+	// SrcsetBaseWidth, when set, adds a density srcset (1x, 2x, ...) built
+	// from responsive variants whose width is a multiple of it.
+	SrcsetBaseWidth int
+
+	// APIKeys are the bearer keys accepted for uploads, deletes and admin
+	// endpoints; none leaves them open. ReadKeys, when set, are
+	// additionally required (or an APIKey) for every other endpoint but
+	// /healthz.
+	APIKeys  []string
+	ReadKeys []string
+
+	// CORSOrigins are the browser origins allowed to call the API ("*"
+	// for any); none sends no CORS headers. CORSMethods and CORSHeaders
+	// are what preflights allow, defaulting to GET, POST, DELETE and
+	// OPTIONS, and Authorization and Content-Type.
+	CORSOrigins []string
+	CORSMethods []string
+	CORSHeaders []string
+
+	// UploadRate limits each client (API key, or IP without one) to this
+	// many uploads per second, with bursts of UploadBurst (default the
+	// rate rounded up); 0 disables limiting.
+	UploadRate  float64
+	UploadBurst int
+
+	// MaxUploadBytes caps the /upload request body; larger uploads get
+	// 413. Defaults to 20 MiB.
+	MaxUploadBytes int64
+
+	// IngestMaxEdge, when positive, downsizes originals whose long edge is
+	// longer before they are stored, bounding storage for huge camera
+	// files. Off by default so the true original is kept.
+	IngestMaxEdge int
+
+	// Dedup makes /upload answer a repeat of an earlier upload (same bytes,
+	// same options) with the earlier image id instead of a new one.
+	Dedup bool
+
+	// IdempotencyKeyTTL is how long an /upload Idempotency-Key is held:
+	// a repeat of the key within it gets the first upload's image id
+	// instead of a new image; 0 means 24h.
+	IdempotencyKeyTTL time.Duration
+
+	// ThumbnailSizes mirrors the coordinator's, so /status expects the
+	// thumbnail_<size> variants it will produce.
+	ThumbnailSizes []int
+
+	// TransformSizes are the w and h values GET /transform accepts. Each
+	// size it renders is stored for good, so the list bounds what anonymous
+	// requests can add; with none, w and h are refused.
+	TransformSizes []int
+
+	// StoreReadRetries bounds extra attempts at a variant read that failed
+	// with a transient store error, StoreReadBackoff apart (doubling).
+	StoreReadRetries int
+	StoreReadBackoff time.Duration
+
+	// RenderConcurrency caps on-the-fly renders running at once (default
+	// GOMAXPROCS) with up to RenderQueue more waiting (default 16, negative
+	// for none) before requests get 429; RenderCacheBytes sizes the cache
+	// of render outputs (default 64 MiB).
+	RenderConcurrency int
+	RenderQueue       int
+	RenderCacheBytes  int
+
+	// PersistWorkers goroutines (default 4) index and store finished
+	// variants from a queue of up to PersistQueue (default 256), so the
+	// transform-updates loop doesn't wait on disk or the store.
+	PersistWorkers int
+	PersistQueue   int
+
+	// StoreBreakerThreshold consecutive store write failures stop writes
+	// for StoreBreakerCooldown (default 30s), after which one write is
+	// tried to test recovery; 0 disables the breaker.
+	StoreBreakerThreshold int
+	StoreBreakerCooldown  time.Duration
+
+	// VariantMaxAge, when set, replaces the ops' registered Cache-Control
+	// policies with public caching for this long.
+	VariantMaxAge time.Duration
+
+	// VariantCacheBytes sizes an LRU of variants read from the store, so
+	// hot images are served from memory; 0 disables it.
+	VariantCacheBytes int
+
+	// PrefetchDepth is how many originals bulk reprocess reads from the
+	// store ahead of dispatch; PrefetchMaxBytes caps the buffered bytes.
+	PrefetchDepth    int
+	PrefetchMaxBytes int64
+
+	// DispatchRetries bounds extra attempts at handing an upload to the
+	// coordinator, DispatchBackoff apart (doubling); an upload that still
+	// can't be handed over is undone and gets 503. One whose attempts
+	// timed out may have been handed over after all: it gets 202 and waits
+	// up to UnconfirmedTimeout (default 10m) for a result before it's
+	// undone.
+	DispatchRetries    int
+	DispatchBackoff    time.Duration
+	UnconfirmedTimeout time.Duration
+
+	// PathTemplate lays out image directories under the data dir, e.g.
+	// "{yyyy}/{mm}/{dd}/{id}" to shard them by upload date (UTC); empty
+	// means "{id}". It must end in {id}; check it with
+	// ValidatePathTemplate.
+	PathTemplate string
 
 	imgsDir string
+	build   BuildInfo
+	// image directories as laid out by PathTemplate
+	layoutOnce sync.Once
+	paths      pathLayout
+	dirsMu     sync.Mutex
+	dirs       map[string]string // image_id -> directory
+	dirsScan   sync.Once
 
 	mu       sync.RWMutex
 	variants map[string]map[string]string // image_id -> op -> path
@@ -38,43 +177,167 @@ type Server struct {
 	totalUploads   int
 	totalVariants  int
 	failedVariants int
+	// failures that used up every retry, a subset of failedVariants
+	exhaustedVariants int
 
 	activeWorkers      int
 	startedWorkers     int
 	activeWorkersPerOp map[string]int
 	successPerOp       map[string]int
 	failedPerOp        map[string]int
+	exhaustedPerOp     map[string]int
+	failures           map[string]map[string]string // image_id -> op -> last error
+	expected           map[string][]string          // image_id -> ops dispatched at upload
+	// uploads whose dispatch timed out, each with the timer that abandons
+	// it unless a result comes first
+	unconfirmed map[string]*time.Timer
+	// images deleted within deletedRetention, so late results for them
+	// are dropped instead of bringing their state back
+	deleted map[string]time.Time
+	// dedup index, guarded by mu
+	uploads    map[string]string // upload key -> image_id
+	uploadKeys map[string]string // image_id -> upload key
+	// Idempotency-Key index, guarded by mu
+	idempotencyKeys  map[string]idempotentUpload
+	idempotencySwept time.Time
+	// in-flight tasks as last reported by each coordinator
+	pendingBy map[string]map[string]int32 // coordinator -> op -> pending
+
+	reconcile reconcileStats
+	reprocess reprocessStats
+
+	// content-addressed index, guarded by mu
+	hashes map[string]map[string]string // image_id -> op -> content hash
+	cas    map[string]casEntry          // content hash -> local copy
+	sizes  map[string]map[string]variantSize
+
+	// on-the-fly render protection, built on first use
+	renderOnce  sync.Once
+	renderLimit *renderLimiter
+	renderCache *byteCache
+	renderGroup singleflight.Group
+
+	// variant persistence queue, started on first use
+	persistOnce sync.Once
+	persist     *persister
+
+	// store write breaker, built on first use
+	breakerOnce sync.Once
+	breaker     *breaker
+
+	// store variant read cache, built on first use
+	variantOnce  sync.Once
+	variantCache *byteCache
+
+	// store writes in flight, waited on by Flush
+	writes writeTracker
+	// per-image lifecycle locks
+	locks imageLocks
+	// dispatch sends an upload event to the coordinator: dispatchUpload,
+	// unless a test stands in for the grid
+	dispatch func(ctx context.Context, ev *messages.UploadEvent) error
+	// uploads long-polling for a variant
+	waiters variantWaiters
+	// tasks that exhausted their retries
+	deadletters deadLetters
+	// per-client upload token buckets
+	uploadLimits clientLimiters
+	// /events streams following uploads by upload_id
+	progress progressHub
 
 	// SSE subscribers
 	eventsMu  sync.Mutex
-	eventSubs map[chan []byte]struct{}
+	eventSubs map[*eventSub]struct{}
+
+	// the HTTP server, and a channel closed on Shutdown so long-lived
+	// streams end instead of holding it open
+	httpMu    sync.Mutex
+	httpSrv   *http.Server
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	log *slog.Logger
 }
 
-func New(etcd *etcdv3.Client, ns string, gs *grid.Server, dir string, st *storage.SpannerStore) *Server {
+func New(etcd *etcdv3.Client, ns string, gs *grid.Server, dir string, st storage.Store, build BuildInfo) *Server {
+	s := newServer(etcd, ns, gs, dir, st, build)
+	go s.subscribeUpdates()
+	go s.subscribeSystemEvents()
+	go s.subscribeDeadLetters()
+	return s
+}
+
+// newServer builds a Server without subscribing to the grid mailboxes, so
+// tests can drive its handlers alone.
+func newServer(etcd *etcdv3.Client, ns string, gs *grid.Server, dir string, st storage.Store, build BuildInfo) *Server {
 	s := &Server{
 		Etcd:               etcd,
 		Namespace:          ns,
 		GridSrv:            gs,
 		Store:              st,
 		imgsDir:            dir,
+		build:              build,
 		variants:           make(map[string]map[string]string),
+		hashes:             make(map[string]map[string]string),
+		cas:                make(map[string]casEntry),
+		sizes:              make(map[string]map[string]variantSize),
 		activeWorkersPerOp: make(map[string]int),
 		successPerOp:       make(map[string]int),
 		failedPerOp:        make(map[string]int),
-		eventSubs:          make(map[chan []byte]struct{}),
+		exhaustedPerOp:     make(map[string]int),
+		failures:           make(map[string]map[string]string),
+		expected:           make(map[string][]string),
+		unconfirmed:        make(map[string]*time.Timer),
+		deleted:            make(map[string]time.Time),
+		pendingBy:          make(map[string]map[string]int32),
+		uploads:            make(map[string]string),
+		uploadKeys:         make(map[string]string),
+		idempotencyKeys:    make(map[string]idempotentUpload),
+		dirs:               make(map[string]string),
+		eventSubs:          make(map[*eventSub]struct{}),
+		closing:            make(chan struct{}),
+		log:                slog.With("component", "api"),
 	}
-	go s.subscribeUpdates()
-	go s.subscribeSystemEvents()
+	s.dispatch = s.dispatchUpload
 	return s
 }
 
 func (s *Server) Listen(addr string) {
+	s.httpMu.Lock()
+	s.httpSrv = &http.Server{Addr: addr, Handler: s.handler()}
+	srv := s.httpSrv
+	s.httpMu.Unlock()
+	s.log.Info("HTTP API listening", "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.log.Error("api listen", "err", err)
+		os.Exit(1)
+	}
+}
+
+// handler routes every endpoint behind the CORS and auth middleware.
+func (s *Server) handler() http.Handler {
 	r := mux.NewRouter()
+	r.NotFoundHandler = http.HandlerFunc(handleNotFound)
+	r.MethodNotAllowedHandler = http.HandlerFunc(handleMethodNotAllowed)
 	r.HandleFunc("/upload", s.handleUpload).Methods("POST")
+	r.HandleFunc("/healthz", s.handleHealthz).Methods("GET")
+	r.HandleFunc("/version", s.handleVersion).Methods("GET")
 	r.HandleFunc("/images", s.handleImages).Methods("GET")
+	r.HandleFunc("/ops", s.handleOps).Methods("GET")
 	// Serve from Spanner if available, fallback to disk via PathPrefix
+	r.HandleFunc("/images/{id}/original", s.handleServeOriginal).Methods("GET")
+	r.HandleFunc("/images/{id}/manifest", s.handleManifest).Methods("GET")
+	r.HandleFunc("/images/{id}/variants", s.handleImageVariants).Methods("GET")
+	r.HandleFunc("/images/{id}/meta", s.handleImageMeta).Methods("GET")
+	r.HandleFunc("/images/{id}/archive.zip", s.handleArchive).Methods("GET")
+	r.HandleFunc("/images/{id}/reprocess", s.handleReprocessImage).Methods("POST")
+	r.HandleFunc("/images/{id}/{op}.{ext}", s.handleServeVariant).Methods("GET")
 	r.HandleFunc("/images/{id}/{op}", s.handleServeVariant).Methods("GET")
-	r.PathPrefix("/images/").Handler(http.StripPrefix("/images/", http.FileServer(http.Dir(s.imgsDir))))
+	r.HandleFunc("/images/{id}", s.handleDeleteImage).Methods("DELETE")
+	r.HandleFunc("/cas/{name}", s.handleCAS).Methods("GET")
+	r.HandleFunc("/status/{id}", s.handleStatus).Methods("GET")
+	r.HandleFunc("/transform", s.handleTransform).Methods("GET")
+	r.PathPrefix("/images/").HandlerFunc(s.handleImageNotFound)
 	r.Handle("/metrics", promhttp.Handler())
 	r.HandleFunc("/metrics/ui", s.handleMetricsUI).Methods("GET")
 	r.HandleFunc("/metrics/json", s.handleMetricsJSON).Methods("GET")
@@ -84,102 +347,864 @@ func (s *Server) Listen(addr string) {
 	r.HandleFunc("/events", s.handleEvents)
 	// Admin scale
 	r.HandleFunc("/admin/scale", s.handleScale).Methods("POST")
+	r.HandleFunc("/admin/workers", s.handleWorkers).Methods("GET")
+	r.HandleFunc("/admin/workers/{op}/{name}/drain", s.handleDrainWorker).Methods("POST")
+	r.HandleFunc("/admin/reconcile", s.handleReconcileStats).Methods("GET")
+	r.HandleFunc("/admin/flush", s.handleFlush).Methods("POST")
+	r.HandleFunc("/admin/deadletters", s.handleDeadLetters).Methods("GET")
+	r.HandleFunc("/deadletter", s.handleDeadLetters).Methods("GET")
+	r.HandleFunc("/deadletter/{id}/{op}/retry", s.handleRetryDeadLetterOp).Methods("POST")
+	r.HandleFunc("/admin/deadletters/retry-all", s.handleRetryAllDeadLetters).Methods("POST")
+	r.HandleFunc("/admin/deadletters/{id}/retry", s.handleRetryDeadLetter).Methods("POST")
+	r.HandleFunc("/admin/reprocess", s.handleReprocess).Methods("POST")
+	r.HandleFunc("/admin/reprocess", s.handleReprocessStats).Methods("GET")
+	r.Use(validateVars)
+	return s.cors(s.authorize(r))
+}
 
-	log.Printf("HTTP API listening on %s", addr)
-	if err := http.ListenAndServe(addr, r); err != nil {
-		log.Fatalf("api listen: %v", err)
+// Shutdown stops accepting requests, waits for in-flight ones (uploads
+// included) to finish, then flushes pending store writes.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.httpMu.Lock()
+	srv := s.httpSrv
+	s.httpMu.Unlock()
+	s.closeOnce.Do(func() { close(s.closing) })
+	if srv != nil {
+		if err := srv.Shutdown(ctx); err != nil {
+			return err
+		}
 	}
+	_, err := s.Flush(ctx)
+	return err
 }
 
 // --- handlers ---
 
 func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
-	file, header, err := r.FormFile("file")
-	if err != nil {
-		http.Error(w, "file required", http.StatusBadRequest)
+	if !s.allowUpload(w, r) {
+		return
+	}
+	ctx, span := tracing.Start(r.Context(), "upload")
+	defer span.End()
+	maxBytes := s.MaxUploadBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxUploadBytes
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	finishProgress, ok := s.trackUpload(r)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidID, "invalid upload_id")
+		return
+	}
+	// a failed upload's followers are told it's over, without an image
+	defer finishProgress("")
+
+	// The original comes from a multipart file, or is downloaded from the
+	// url in a JSON body; options then travel in the query string.
+	var src io.Reader
+	var originalExt string
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/json" {
+		var body struct {
+			URL string `json:"url"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.URL == "" {
+			writeJSONError(w, http.StatusBadRequest, codeBadRequest, "url required")
+			return
+		}
+		data, ext, err := fetchRemote(r.Context(), body.URL, maxBytes)
+		switch {
+		case errors.Is(err, errBadURL) || errors.Is(err, errBlockedHost):
+			writeJSONError(w, http.StatusBadRequest, codeInvalidParam, err.Error())
+			return
+		case errors.Is(err, errNotImage):
+			writeJSONError(w, http.StatusUnsupportedMediaType, codeUnsupportedMedia, err.Error())
+			return
+		case errors.Is(err, errRemoteTooBig):
+			writeJSONError(w, http.StatusRequestEntityTooLarge, codeTooLarge, "upload too large")
+			return
+		case err != nil:
+			s.log.Warn("upload fetch", "err", err)
+			writeJSONError(w, http.StatusBadGateway, codeUpstream, "fetch failed")
+			return
+		}
+		src, originalExt = bytes.NewReader(data), ext
+	} else {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeJSONError(w, http.StatusRequestEntityTooLarge, codeTooLarge, "upload too large")
+				return
+			}
+			writeJSONError(w, http.StatusBadRequest, codeBadRequest, "file required")
+			return
+		}
+		defer file.Close()
+		src, originalExt = file, filepath.Ext(header.Filename)
+	}
+
+	// Optional output format; each op falls back to its registered default.
+	format := r.FormValue("format")
+	if format != "" {
+		f, ok := ops.NormalizeFormat(format)
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, codeUnsupportedFormat, "unsupported format")
+			return
+		}
+		format = f
+	}
+
+	// Optional resize target; supplying either dimension adds the resize op.
+	params := map[string]*structpb.Struct{}
+	dims := map[string]any{}
+	for _, k := range []string{"width", "height"} {
+		if v := r.FormValue(k); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > maxRenderDimension {
+				writeJSONError(w, http.StatusBadRequest, codeInvalidParam, fmt.Sprintf("%s must be 1-%d", k, maxRenderDimension))
+				return
+			}
+			dims[k] = n
+		}
+	}
+	if len(dims) > 0 {
+		params["resize"], _ = structpb.NewStruct(dims)
+	}
+
+	// Optional blur radius (sigma) for the blur op.
+	if v := r.FormValue("blur_radius"); v != "" {
+		radius, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(radius) || radius <= 0 || math.IsInf(radius, 0) {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidParam, "invalid blur_radius")
+			return
+		}
+		params["blur"], _ = structpb.NewStruct(map[string]any{"radius": radius})
+	}
+
+	// Optional sharpen sigma; adds the sharpen op.
+	if v := r.FormValue("sharpen"); v != "" {
+		sigma, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(sigma) || sigma < 0 || math.IsInf(sigma, 0) {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidParam, "invalid sharpen")
+			return
+		}
+		params["sharpen"], _ = structpb.NewStruct(map[string]any{"sigma": sigma})
+	}
+
+	// Optional sepia intensity (0-1); adds the sepia op.
+	if v := r.FormValue("sepia"); v != "" {
+		intensity, err := strconv.ParseFloat(v, 64)
+		if err != nil || intensity < 0 || intensity > 1 {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidParam, "invalid sepia")
+			return
+		}
+		params["sepia"], _ = structpb.NewStruct(map[string]any{"intensity": intensity})
+	}
+
+	// Optional brightness/contrast percentages (-100..100); each adds its op.
+	for _, op := range []string{"brightness", "contrast"} {
+		v := r.FormValue(op)
+		if v == "" {
+			continue
+		}
+		pct, err := strconv.ParseFloat(v, 64)
+		if err != nil || pct < -100 || pct > 100 {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidParam, "invalid "+op)
+			return
+		}
+		params[op], _ = structpb.NewStruct(map[string]any{"percentage": pct})
+	}
+
+	// Optional crop rectangle "x,y,width,height"; adds the crop op.
+	if v := r.FormValue("crop"); v != "" {
+		parts := strings.Split(v, ",")
+		if len(parts) != 4 {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidParam, "invalid crop")
+			return
+		}
+		rect := map[string]any{}
+		for i, k := range []string{"x", "y", "width", "height"} {
+			n, err := strconv.Atoi(strings.TrimSpace(parts[i]))
+			if err != nil || n < 0 {
+				writeJSONError(w, http.StatusBadRequest, codeInvalidParam, "invalid crop")
+				return
+			}
+			rect[k] = n
+		}
+		params["crop"], _ = structpb.NewStruct(rect)
+	}
+
+	// Optional watermark text, placed with watermark_position and blended
+	// at watermark_opacity (0-1); adds the watermark op.
+	if v := r.FormValue("watermark"); v != "" {
+		if utf8.RuneCountInString(v) > imageops.MaxWatermarkText {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidParam, fmt.Sprintf("watermark must be at most %d characters", imageops.MaxWatermarkText))
+			return
+		}
+		wm := map[string]any{"text": v}
+		if p := r.FormValue("watermark_position"); p != "" {
+			wm["position"] = p
+		}
+		if o := r.FormValue("watermark_opacity"); o != "" {
+			opacity, err := strconv.ParseFloat(o, 64)
+			if err != nil || opacity < 0 || opacity > 1 {
+				writeJSONError(w, http.StatusBadRequest, codeInvalidParam, "invalid watermark_opacity")
+				return
+			}
+			wm["opacity"] = opacity
+		}
+		params["watermark"], _ = structpb.NewStruct(wm)
+	}
+	if err := validateParams(params); err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidParam, err.Error())
 		return
 	}
-	defer file.Close()
+
+	// Optional encoder quality; unset or out of range leaves the worker
+	// default (90).
+	var quality int32
+	if q, err := strconv.Atoi(r.FormValue("quality")); err == nil && q >= 1 && q <= 100 {
+		quality = int32(q)
+	}
+
+	// Optional encoder effort; unset uses each op's registered default.
+	var effort int32
+	if v := r.FormValue("effort"); v != "" {
+		e, err := strconv.Atoi(v)
+		if err != nil || !ops.ValidEffort(e) {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidParam, fmt.Sprintf("effort must be %d-%d", ops.MinEffort, ops.MaxEffort))
+			return
+		}
+		if format != "" && format != ops.EffortFormat {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidParam, "effort applies to "+ops.EffortFormat+" output only")
+			return
+		}
+		effort = int32(e)
+	}
+
+	// EXIF auto-orientation is on unless auto_orient=false.
+	var autoOrient *bool
+	if v := r.FormValue("auto_orient"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidParam, "invalid auto_orient")
+			return
+		}
+		autoOrient = &b
+	}
+
+	// Optional background for flattening transparency in JPEG output.
+	background := r.FormValue("background")
+	if background != "" {
+		if _, ok := ops.ParseColor(background); !ok {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidParam, "invalid background")
+			return
+		}
+	}
+
+	// Optional metadata to embed in the variants, as a JSON object of strings.
+	var meta map[string]string
+	if v := r.FormValue("meta"); v != "" {
+		if err := json.Unmarshal([]byte(v), &meta); err != nil {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidParam, "invalid meta")
+			return
+		}
+	}
+
+	// Optional comma-separated op list; the coordinator runs its defaults
+	// when it's empty.
+	var selected []string
+	for _, op := range strings.Split(r.FormValue("ops"), ",") {
+		if op = strings.TrimSpace(op); op != "" {
+			selected = append(selected, op)
+		}
+	}
+
+	// Optionally long-poll for one op (typically the thumbnail) so the
+	// response can carry its URL while the other ops continue async.
+	waitFor := s.WaitForOp
+	if v := r.FormValue("wait_for"); v != "" {
+		waitFor = v
+	}
+	if waitFor == "none" {
+		waitFor = ""
+	}
+
+	hasParams := func(op string) bool {
+		_, ok := params[op]
+		return ok
+	}
+	expected, _ := ops.Select(selected, hasParams)
+	names := s.variantNames(expected, hasParams)
+	if waitFor != "" && !slices.Contains(names, waitFor) {
+		// A requested wait must name a variant this upload produces; the
+		// configured default just doesn't apply to uploads without it.
+		if r.FormValue("wait_for") != "" {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidParam, "wait_for names no variant of this upload")
+			return
+		}
+		waitFor = ""
+	}
+
+	payload := &messages.UploadEvent{
+		Format:     format,
+		Params:     params,
+		Ops:        selected,
+		Quality:    quality,
+		Effort:     effort,
+		AutoOrient: autoOrient,
+		Metadata:   meta,
+		Background: background,
+	}
 
 	id := uuid.New().String()
-	dir := filepath.Join(s.imgsDir, id)
+
+	// A client retrying an upload under the same Idempotency-Key gets the
+	// image its first attempt created rather than a second one. The key
+	// is freed again if this upload fails before it's dispatched.
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		existing, ok := s.claimIdempotencyKey(key, id)
+		if !ok {
+			finishProgress(existing)
+			s.writeDuplicate(w, existing, waitFor)
+			return
+		}
+		defer func() {
+			// the event id is set just before the dispatch
+			if payload.EventId == "" {
+				s.releaseIdempotencyKey(key, id)
+			}
+		}()
+	}
+	dir := s.newImageDir(id)
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		http.Error(w, "cannot create dir", 500)
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "cannot create dir")
 		return
 	}
 
 	// save original
-	originalExt := filepath.Ext(header.Filename)
 	originalPath := filepath.Join(dir, "original"+originalExt)
 	out, err := os.Create(originalPath)
 	if err != nil {
-		http.Error(w, "save failed", 500)
+		os.RemoveAll(dir)
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "save failed")
 		return
 	}
-	if _, err := io.Copy(out, file); err != nil {
-		http.Error(w, "copy failed", 500)
+	sum := sha256.New()
+	if n, err := io.Copy(io.MultiWriter(out, sum), io.LimitReader(src, maxBytes+1)); err != nil || n > maxBytes {
+		out.Close()
+		os.RemoveAll(dir)
+		var tooLarge *http.MaxBytesError
+		if n > maxBytes || errors.As(err, &tooLarge) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, codeTooLarge, "upload too large")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "copy failed")
 		return
 	}
 	out.Close()
 
+	// With dedup on, the same bytes uploaded with the same options reuse
+	// the image that's already there instead of recomputing its variants.
+	var dedupKey string
+	if s.Dedup {
+		dedupKey = uploadKey(sum.Sum(nil), payload)
+		if existing, ok := s.lookupUpload(dedupKey); ok {
+			os.RemoveAll(dir)
+			finishProgress(existing)
+			s.writeDuplicate(w, existing, waitFor)
+			return
+		}
+	}
+
+	// Optionally cap the stored original; every variant derives from it
+	if s.IngestMaxEdge > 0 {
+		if capped, err := capOriginal(originalPath, s.IngestMaxEdge); err != nil {
+			s.log.Warn("ingest cap", "image_id", id, "err", err)
+		} else if capped {
+			s.log.Info("ingest: capped original", "image_id", id, "max_edge", s.IngestMaxEdge)
+		}
+	}
+
 	// Save original to Spanner if configured
 	if s.Store != nil {
+		write := s.writes.begin()
 		data, rerr := os.ReadFile(originalPath)
 		if rerr != nil {
-			log.Printf("spanner read original: %v", rerr)
-		} else if err := s.Store.SaveOriginal(r.Context(), id, originalExt, data); err != nil {
-			log.Printf("spanner save original: %v", err)
+			s.log.Error("read original", "image_id", id, "err", rerr)
+		} else if ok, err := s.storeWrite(r.Context(), func(ctx context.Context) error {
+			return s.Store.SaveOriginal(ctx, id, originalExt, data)
+		}); err != nil {
+			s.log.Error("store save original", "image_id", id, "err", err)
+		} else if !ok {
+			s.log.Warn("store breaker open, original kept on disk only", "image_id", id)
 		}
+		s.writes.end(write)
 	}
 
 	// send upload event to coordinator via mailbox
-	payload, _ := structpb.NewStruct(map[string]any{
-		"image_id": id,
-		"path":     originalPath,
-	})
+	payload.ImageId = id
+	payload.Path = originalPath
+	span.SetAttributes(attribute.String("image_id", id))
+	payload.TraceId, payload.SpanId = tracing.IDs(ctx)
 
-	client, err := grid.NewClient(s.Etcd, grid.ClientCfg{Namespace: s.Namespace})
-	if err != nil {
-		log.Printf("api grid client: %v", err)
-		http.Error(w, "internal", 500)
-		return
+	// record what the coordinator will run, for GET /status/{id}
+	s.mu.Lock()
+	s.expected[id] = names
+	if dedupKey != "" {
+		s.rememberUpload(dedupKey, id)
 	}
-	defer client.Close()
+	s.mu.Unlock()
 
-	if _, err := client.RequestC(r.Context(), "uploads", payload); err != nil {
-		log.Printf("api upload request: %v", err)
+	var ready chan string
+	if waitFor != "" {
+		ready = s.waiters.add(id, waitFor)
+	}
+	payload.EventId = uuid.New().String()
+	if err := s.dispatch(r.Context(), payload); err != nil {
+		if ready != nil {
+			s.waiters.remove(id, waitFor, ready)
+		}
+		if errors.Is(err, errUnconfirmed) {
+			// The coordinator may be running it: keep the upload, and
+			// its Idempotency-Key, until its results say either way.
+			s.log.Warn("upload request unconfirmed", "image_id", id, "err", err)
+			s.holdUnconfirmed(id, dir, r.Header.Get("Idempotency-Key"))
+			finishProgress(id)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(map[string]any{"image_id": id, "pending": names})
+			return
+		}
+		s.log.Error("upload request", "image_id", id, "err", err)
+		s.abandonUpload(r.Context(), id, dir)
+		// frees the Idempotency-Key for the client's retry
+		payload.EventId = ""
+		w.Header().Set("Retry-After", dispatchRetryAfter)
+		writeJSONError(w, http.StatusServiceUnavailable, codeUnavailable, "coordinator unavailable")
+		return
 	}
 
+	s.mu.Lock()
 	s.totalUploads++
+	s.mu.Unlock()
+	uploadsTotal.Inc()
 	s.broadcastSnapshot()
+	finishProgress(id)
+
+	resp := map[string]any{"image_id": id}
+	if ready != nil {
+		timeout := s.WaitTimeout
+		if timeout <= 0 {
+			timeout = 5 * time.Second
+		}
+		if url := s.awaitVariant(r.Context(), id, waitFor, ready, timeout); url != "" {
+			resp["variants"] = map[string]string{waitFor: url}
+		} else {
+			resp["pending"] = []string{waitFor}
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"image_id": id})
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Page sizes for GET /images.
+const (
+	defaultImagesLimit = 100
+	maxImagesLimit     = 1000
+)
+
+// imageEntry is one image in a GET /images page.
+type imageEntry struct {
+	ID       string            `json:"id"`
+	Variants map[string]string `json:"variants"`
 }
 
+// GET /images?limit=&cursor= returns images sorted by id, starting after
+// cursor; next is the cursor for the following page, empty on the last.
+// Repeatable op= and missing= keep only images that have, or lack, every
+// variant named; with missing=, images none of whose variants succeeded
+// are listed too, so failed ones can be found for reprocessing. Pages come
+// from the store when it can list images, so the listing survives
+// restarts.
 func (s *Server) handleImages(w http.ResponseWriter, r *http.Request) {
+	limit := defaultImagesLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidParam, "invalid limit")
+			return
+		}
+		limit = min(n, maxImagesLimit)
+	}
+	cursor := r.URL.Query().Get("cursor")
+	has := r.URL.Query()["op"]
+	missing := r.URL.Query()["missing"]
+	if lister, ok := s.Store.(storage.ImageLister); ok {
+		s.listStoredImages(w, r, lister, limit, cursor, storage.ImageFilter{Has: has, Missing: missing})
+		return
+	}
+
 	s.mu.RLock()
-	defer s.mu.RUnlock()
-	json.NewEncoder(w).Encode(s.variants)
+	known := map[string]struct{}{}
+	for id := range s.variants {
+		known[id] = struct{}{}
+	}
+	if len(missing) > 0 {
+		for id := range s.expected {
+			known[id] = struct{}{}
+		}
+	}
+	ids := make([]string, 0, len(known))
+	for id := range known {
+		if matchesVariants(s.variants[id], has, missing) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	start := sort.SearchStrings(ids, cursor)
+	if start < len(ids) && ids[start] == cursor {
+		start++
+	}
+	end := min(start+limit, len(ids))
+	page := make([]imageEntry, 0, end-start)
+	for _, id := range ids[start:end] {
+		vs := make(map[string]string, len(s.variants[id]))
+		for op, url := range s.variants[id] {
+			vs[op] = url
+		}
+		page = append(page, imageEntry{ID: id, Variants: vs})
+	}
+	s.mu.RUnlock()
+
+	next := ""
+	if end < len(ids) {
+		next = ids[end-1]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"images": page,
+		"total":  len(ids),
+		"next":   next,
+	})
+}
+
+// listStoredImages serves a GET /images page from the store, which
+// outlives restarts, rather than the in-memory index.
+func (s *Server) listStoredImages(w http.ResponseWriter, r *http.Request, lister storage.ImageLister, limit int, cursor string, filter storage.ImageFilter) {
+	images, next, total, err := lister.ListImages(r.Context(), limit, cursor, filter)
+	if err != nil {
+		s.log.Error("store list images", "err", err)
+		writeJSONError(w, http.StatusServiceUnavailable, codeUnavailable, "store unavailable")
+		return
+	}
+	page := make([]imageEntry, 0, len(images))
+	for _, img := range images {
+		vs := make(map[string]string, len(img.Ops))
+		for _, op := range img.Ops {
+			vs[op] = fmt.Sprintf("/images/%s/%s", img.ImageID, op)
+		}
+		page = append(page, imageEntry{ID: img.ImageID, Variants: vs})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"images": page,
+		"total":  total,
+		"next":   next,
+	})
+}
+
+// matchesVariants reports whether an image with variants has every name in
+// has and none in missing.
+func matchesVariants(variants map[string]string, has, missing []string) bool {
+	for _, name := range has {
+		if _, ok := variants[name]; !ok {
+			return false
+		}
+	}
+	for _, name := range missing {
+		if _, ok := variants[name]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// GET /images/{id}/variants lists one image's variants as op -> URL, from
+// Spanner when configured, otherwise from the in-memory index.
+func (s *Server) handleImageVariants(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	variants := map[string]string{}
+	if s.Store != nil {
+		names, err := s.Store.ListOps(r.Context(), id)
+		if err != nil {
+			s.log.Error("store list ops", "image_id", id, "err", err)
+			writeJSONError(w, http.StatusServiceUnavailable, codeUnavailable, "store unavailable")
+			return
+		}
+		for _, op := range names {
+			variants[op] = fmt.Sprintf("/images/%s/%s", id, op)
+		}
+	} else {
+		s.mu.RLock()
+		for op, url := range s.variants[id] {
+			variants[op] = url
+		}
+		s.mu.RUnlock()
+	}
+	if len(variants) == 0 {
+		writeNotFound(w, "image not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"image_id": id, "variants": variants})
+}
+
+func (s *Server) handleOps(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ops.All())
 }
 
 func (s *Server) handleServeVariant(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	id := vars["id"]
-	op := vars["op"]
-	if s.Store != nil {
-		data, ct, err := s.Store.GetVariant(r.Context(), id, op)
+	id, op, ext := vars["id"], vars["op"], vars["ext"]
+	notFound := func() {
+		w.Header().Del("Cache-Control")
+		writeNotFound(w, "variant not found")
+	}
+	if ext == "" {
+		w.Header().Add("Vary", "Accept")
+	}
+	w.Header().Set("Cache-Control", s.variantCacheControl(op))
+	// while the store is failing writes, go straight to disk
+	if s.Store != nil && !s.storeBreaker().open() {
+		data, ct, err := s.getVariant(r.Context(), id, op)
 		if err == nil {
 			if ct == "" {
 				ct = "image/jpeg"
 			}
-			w.Header().Set("Content-Type", ct)
+			format, ok := servedFormat(r, ext, storedFormat(ct))
+			if !ok {
+				notFound()
+				return
+			}
+			s.serveNegotiated(w, r, id, op, ct, format, data)
+			return
+		}
+	}
+	// fallback to file path; the extension on disk depends on the output format
+	path, ok := variantFile(s.imageDir(id), op)
+	if !ok {
+		notFound()
+		return
+	}
+	ct := variantContentType(path)
+	format, ok := servedFormat(r, ext, storedFormat(ct))
+	if !ok {
+		notFound()
+		return
+	}
+	if format != storedFormat(ct) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			notFound()
+			return
+		}
+		s.serveNegotiated(w, r, id, op, ct, format, data)
+		return
+	}
+	// ServeFile adds Last-Modified and honours the ETag when it is indexed
+	s.mu.RLock()
+	hash, ok := s.hashes[id][op]
+	s.mu.RUnlock()
+	if ok {
+		w.Header().Set("ETag", `"`+hash+`"`)
+	}
+	http.ServeFile(w, r, path)
+}
+
+// handleServeOriginal serves the pristine upload, from the store when it has
+// one and otherwise from the image's directory.
+func (s *Server) handleServeOriginal(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	w.Header().Set("Cache-Control", ops.OriginalCacheControl)
+	if s.Store != nil {
+		data, ext, err := s.Store.GetOriginal(r.Context(), id)
+		if err == nil {
+			w.Header().Set("Content-Type", variantContentType(ext))
 			w.WriteHeader(http.StatusOK)
 			_, _ = w.Write(data)
 			return
 		}
 	}
-	// fallback to file path
-	http.ServeFile(w, r, filepath.Join(s.imgsDir, id, op))
+	path, ok := variantFile(s.imageDir(id), "original")
+	if !ok {
+		w.Header().Del("Cache-Control")
+		writeNotFound(w, "image not found")
+		return
+	}
+	http.ServeFile(w, r, path)
+}
+
+// handleDeleteImage removes an image's directory, index entry, and stored
+// rows, and backs its variants out of the totals.
+func (s *Server) handleDeleteImage(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	unlock := s.locks.lock(id)
+	defer unlock()
+
+	dir := s.imageDir(id)
+	_, statErr := os.Stat(dir)
+	s.mu.RLock()
+	_, indexed := s.variants[id]
+	s.mu.RUnlock()
+	known := indexed || statErr == nil
+	if !known && s.Store != nil {
+		// metadata answers whether the image is stored without reading it
+		_, err := s.Store.GetImageMetadata(r.Context(), id)
+		switch {
+		case err == nil:
+			known = true
+		case !storage.IsNotFound(err):
+			s.log.Error("delete: store lookup", "image_id", id, "err", err)
+			writeJSONError(w, http.StatusServiceUnavailable, codeUnavailable, "store unavailable")
+			return
+		}
+	}
+	if !known {
+		writeNotFound(w, "image not found")
+		return
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		s.log.Error("delete: remove dir", "image_id", id, "err", err)
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "delete failed")
+		return
+	}
+	if s.Store != nil {
+		if err := s.Store.DeleteImage(r.Context(), id); err != nil {
+			s.log.Error("store delete image", "image_id", id, "err", err)
+			writeJSONError(w, http.StatusInternalServerError, codeInternal, "delete failed")
+			return
+		}
+	}
+
+	s.mu.Lock()
+	// Back out the variants that stand as successes; one whose latest
+	// attempt failed isn't counted among them.
+	for name := range s.variants[id] {
+		if _, failed := s.failures[id][name]; failed {
+			continue
+		}
+		if s.totalVariants > 0 {
+			s.totalVariants--
+		}
+		if op := ops.BaseOp(name); s.successPerOp[op] > 0 {
+			s.successPerOp[op]--
+		}
+	}
+	delete(s.variants, id)
+	delete(s.failures, id)
+	delete(s.expected, id)
+	delete(s.sizes, id)
+	s.dropCachedVariants(id)
+	s.forgetContent(id)
+	s.forgetUpload(id)
+	s.forgetImageDir(id)
+	s.markDeleted(id, time.Now())
+	if s.totalUploads > 0 {
+		s.totalUploads--
+	}
+	s.mu.Unlock()
+	s.broadcastSnapshot()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deletedRetention is how long a deleted image's id is remembered: longer
+// than a task can spend in retries before its last result arrives.
+const deletedRetention = time.Hour
+
+// markDeleted records id as deleted at now and forgets ids deleted over
+// deletedRetention ago. The caller holds s.mu.
+func (s *Server) markDeleted(id string, now time.Time) {
+	for k, at := range s.deleted {
+		if now.Sub(at) >= deletedRetention {
+			delete(s.deleted, k)
+		}
+	}
+	s.deleted[id] = now
+}
+
+// wasDeleted reports whether id's image has been deleted. The caller holds
+// s.mu.
+func (s *Server) wasDeleted(id string) bool {
+	_, ok := s.deleted[id]
+	return ok
+}
+
+// getVariant reads a variant from the variant cache, or else the store.
+func (s *Server) getVariant(ctx context.Context, id, op string) ([]byte, string, error) {
+	cache := s.variantReads()
+	if e, ok := cache.get(id + "/" + op); ok {
+		return e.data, e.contentType, nil
+	}
+	gen := cache.generation()
+	data, ct, err := s.readVariant(ctx, id, op)
+	if err == nil {
+		cache.put(cacheEntry{key: id + "/" + op, data: data, contentType: ct}, gen)
+	}
+	return data, ct, err
+}
+
+// variantReads lazily builds the variant cache from VariantCacheBytes; it
+// is nil, caching nothing, when that is unset.
+func (s *Server) variantReads() *byteCache {
+	s.variantOnce.Do(func() {
+		if s.VariantCacheBytes > 0 {
+			s.variantCache = newByteCache(s.VariantCacheBytes, variantCacheResults)
+		}
+	})
+	return s.variantCache
+}
+
+// dropCachedVariants evicts an image's variants from the variant and
+// render caches.
+func (s *Server) dropCachedVariants(id string) {
+	_, render := s.renderer()
+	s.variantReads().drop(id+"/", true)
+	render.drop("transform:"+id+"/", true)
+	render.drop("transcode:"+id+"/", true)
+}
+
+// readVariant reads a variant from the store, retrying transient errors so
+// a blip doesn't turn into a disk fallback (and likely 404). Not-found and
+// other permanent errors return at once.
+func (s *Server) readVariant(ctx context.Context, id, op string) ([]byte, string, error) {
+	backoff := s.StoreReadBackoff
+	if backoff <= 0 {
+		backoff = 50 * time.Millisecond
+	}
+	for attempt := 0; ; attempt++ {
+		data, ct, err := s.Store.GetVariant(ctx, id, op)
+		if err == nil || attempt >= s.StoreReadRetries || !storage.IsRetryable(err) {
+			return data, ct, err
+		}
+		s.log.Warn("store read failed, retrying", "image_id", id, "op", op, "backoff", backoff, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// variantContentType derives the stored content type from a variant's file extension.
+func variantContentType(path string) string {
+	if ct := mime.TypeByExtension(filepath.Ext(path)); ct != "" {
+		return ct
+	}
+	return "image/jpeg"
 }
 
 // --- subscription to transform results ---
@@ -187,12 +1212,12 @@ func (s *Server) handleServeVariant(w http.ResponseWriter, r *http.Request) {
 func (s *Server) subscribeUpdates() {
 	// wait for grid server running
 	if err := s.GridSrv.WaitUntilStarted(context.Background()); err != nil {
-		log.Printf("api updates wait: %v", err)
+		s.log.Error("updates wait", "err", err)
 		return
 	}
 	mb, err := s.GridSrv.NewMailbox("transform-updates", 200)
 	if err != nil {
-		log.Printf("api updates mailbox: %v", err)
+		s.log.Error("updates mailbox", "err", err)
 		return
 	}
 	defer mb.Close()
@@ -202,53 +1227,129 @@ func (s *Server) subscribeUpdates() {
 		case <-s.GridSrv.Context().Done():
 			return
 		case req := <-mb.C():
-			msg, ok := req.Msg().(*structpb.Struct)
+			msg, ok := messages.AsTransformResult(req.Msg())
 			if !ok {
 				_ = req.Ack()
 				continue
 			}
-			id := msg.GetFields()["image_id"].GetStringValue()
-			op := msg.GetFields()["op"].GetStringValue()
-			path := msg.GetFields()["path"].GetStringValue()
-			s.mu.Lock()
-			if _, ok := s.variants[id]; !ok {
-				s.variants[id] = make(map[string]string)
-			}
-			s.variants[id][op] = fmt.Sprintf("/images/%s/%s", id, filepath.Base(path))
-			s.mu.Unlock()
+			s.recordResult(msg)
+			_ = req.Ack()
+		}
+	}
+}
 
-			// Save variant to Spanner if configured
-			if s.Store != nil {
-				data, rerr := os.ReadFile(path)
-				if rerr != nil {
-					log.Printf("spanner read variant: %v", rerr)
-				} else if err := s.Store.SaveVariant(context.Background(), id, op+filepath.Ext(path), "image/jpeg", data); err != nil {
-					log.Printf("spanner save variant: %v", err)
-				}
+// recordResult applies a transform result to the image's state, queueing a
+// variant for the store. A result for an image deleted meanwhile is dropped
+// rather than bringing its state back.
+func (s *Server) recordResult(msg *messages.TransformResult) {
+	id := msg.GetImageId()
+	op := msg.GetOp()
+	s.confirmUpload(id)
+	// on-the-fly renders are stored under their own variant name;
+	// per-op metrics still count them under op
+	name := op
+	if v := msg.GetVariant(); v != "" {
+		name = v
+	}
+	s.mu.RLock()
+	gone := s.wasDeleted(id)
+	s.mu.RUnlock()
+	if gone {
+		s.log.Debug("result for deleted image dropped", "image_id", id, "variant", name)
+		return
+	}
+	path := msg.GetPath()
+	url := fmt.Sprintf("/images/%s/%s", id, name)
+	_, span := tracing.Start(tracing.Remote(context.Background(), msg.GetTraceId(), msg.GetSpanId()), "record_variant",
+		trace.WithAttributes(attribute.String("image_id", id), attribute.String("op", op), attribute.String("variant", name)))
+	if msg.GetSuccess() {
+		// Index the content hash and save to the store off this
+		// loop; until then the variant is served from disk
+		s.persistVariant(id, name, path)
+		unlock := s.locks.lock(id)
+		s.mu.Lock()
+		if s.wasDeleted(id) {
+			// deleted since the check above; persistAttempt drops it too
+			s.mu.Unlock()
+			unlock()
+			span.End()
+			return
+		}
+		if _, ok := s.variants[id]; !ok {
+			s.variants[id] = make(map[string]string)
+		}
+		s.variants[id][name] = url
+		if msg.GetWidth() > 0 {
+			if s.sizes[id] == nil {
+				s.sizes[id] = make(map[string]variantSize)
 			}
+			s.sizes[id][name] = variantSize{Width: int(msg.GetWidth()), Height: int(msg.GetHeight())}
+		}
+		delete(s.failures[id], name)
+		s.totalVariants++
+		s.successPerOp[op]++
+		s.mu.Unlock()
+		variantsTotal.WithLabelValues(op).Inc()
+		unlock()
+		s.waiters.notify(id, name, url)
+		s.broadcastDelta(variantDone{ImageID: id, Op: op, Variant: name, Success: true, URL: url})
+	} else {
+		s.log.Warn("variant failed", "image_id", id, "variant", name, "err", msg.GetError())
+		s.mu.Lock()
+		if s.wasDeleted(id) {
+			s.mu.Unlock()
+			span.End()
+			return
+		}
+		if s.failures[id] == nil {
+			s.failures[id] = make(map[string]string)
+		}
+		s.failures[id][name] = msg.GetError()
+		s.failedVariants++
+		s.failedPerOp[op]++
+		reason := msg.GetReason()
+		if reason == "" {
+			reason = "error"
+		}
+		transformFailures.WithLabelValues(op, reason).Inc()
+		if msg.GetExhausted() {
+			s.exhaustedVariants++
+			s.exhaustedPerOp[op]++
+			variantsExhausted.WithLabelValues(op).Inc()
+		}
+		s.mu.Unlock()
+		s.waiters.notify(id, name, "")
+		s.broadcastDelta(variantDone{ImageID: id, Op: op, Variant: name, Error: msg.GetError()})
+	}
+	if msg.GetDurationMs() > 0 {
+		transformDuration.WithLabelValues(op).Observe(float64(msg.GetDurationMs()) / 1000)
+	}
+	if !msg.GetSuccess() {
+		span.SetStatus(codes.Error, msg.GetError())
+	}
+	span.End()
 
-			if msg.GetFields()["success"].GetBoolValue() {
-				s.totalVariants++
-				s.successPerOp[op]++
-			} else {
-				s.failedVariants++
-				s.failedPerOp[op]++
-			}
+	s.broadcastSnapshot()
+}
 
-			s.broadcastSnapshot()
-			_ = req.Ack()
-		}
+// variantNames lists the names the selected ops' variants are stored under.
+func (s *Server) variantNames(selected []string, hasParams func(op string) bool) []string {
+	vs := ops.Variants(selected, s.ThumbnailSizes, hasParams)
+	names := make([]string, len(vs))
+	for i, v := range vs {
+		names[i] = v.Name
 	}
+	return names
 }
 
 func (s *Server) subscribeSystemEvents() {
 	if err := s.GridSrv.WaitUntilStarted(context.Background()); err != nil {
-		log.Printf("api system-events wait: %v", err)
+		s.log.Error("system-events wait", "err", err)
 		return
 	}
 	mb, err := s.GridSrv.NewMailbox("system-events", 100)
 	if err != nil {
-		log.Printf("api system-events mailbox: %v", err)
+		s.log.Error("system-events mailbox", "err", err)
 		return
 	}
 	defer mb.Close()
@@ -257,13 +1358,13 @@ func (s *Server) subscribeSystemEvents() {
 		case <-s.GridSrv.Context().Done():
 			return
 		case req := <-mb.C():
-			msg, ok := req.Msg().(*structpb.Struct)
+			msg, ok := messages.AsSystemEvent(req.Msg())
 			if !ok {
 				_ = req.Ack()
 				continue
 			}
-			evt := msg.GetFields()["event"].GetStringValue()
-			op := msg.GetFields()["op"].GetStringValue()
+			evt := msg.GetEvent()
+			op := msg.GetOp()
 			s.mu.Lock()
 			switch evt {
 			case "worker_start":
@@ -277,6 +1378,8 @@ func (s *Server) subscribeSystemEvents() {
 				if s.activeWorkersPerOp[op] > 0 {
 					s.activeWorkersPerOp[op]--
 				}
+			case "backlog":
+				s.recordBacklogLocked(msg.GetName(), msg.GetPending())
 			}
 			s.mu.Unlock()
 			s.broadcastSnapshot()
@@ -286,24 +1389,42 @@ func (s *Server) subscribeSystemEvents() {
 }
 
 // SSE handlers and helpers
+
+// GET /events streams a snapshot of every variant and the metrics, then a
+// fresh one on each change. With ?mode=delta it sends the snapshot once,
+// then only a variant_done event per finished op, which stays small however
+// many images there are. With ?upload_id= it instead follows that one
+// upload's progress (see streamUploadProgress).
 func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 	flusher, ok := w.(http.Flusher)
 	if !ok {
-		http.Error(w, "stream unsupported", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "stream unsupported")
+		return
+	}
+	if id := r.URL.Query().Get("upload_id"); id != "" {
+		if !uploadIDPattern.MatchString(id) {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidID, "invalid upload_id")
+			return
+		}
+		s.streamUploadProgress(w, r, flusher, id)
+		return
+	}
+	mode := r.URL.Query().Get("mode")
+	if mode != "" && mode != "delta" && mode != "snapshot" {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidParam, "invalid mode")
 		return
 	}
-	ch := make(chan []byte, 16)
+	sub := &eventSub{ch: make(chan []byte, 16), delta: mode == "delta", dropped: make(chan struct{})}
 	s.eventsMu.Lock()
-	s.eventSubs[ch] = struct{}{}
+	s.eventSubs[sub] = struct{}{}
 	s.eventsMu.Unlock()
 	defer func() {
 		s.eventsMu.Lock()
-		delete(s.eventSubs, ch)
+		delete(s.eventSubs, sub)
 		s.eventsMu.Unlock()
-		close(ch)
 	}()
 	// send initial snapshot
 	if b, err := s.snapshotJSON(); err == nil {
@@ -316,50 +1437,153 @@ func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
 		select {
 		case <-r.Context().Done():
 			return
+		case <-s.closing:
+			return
 		case <-keep.C:
 			fmt.Fprintf(w, ": keepalive\n\n")
 			flusher.Flush()
-		case b := <-ch:
+		case b := <-sub.ch:
 			fmt.Fprintf(w, "data: %s\n\n", b)
 			flusher.Flush()
+		case <-sub.dropped:
+			// it missed deltas; reconnecting starts it on a fresh snapshot
+			return
 		}
 	}
 }
 
+// recordBacklogLocked stores a coordinator's backlog report and updates
+// the pending gauge in place: ops no coordinator reports any more drop to
+// 0 rather than being reset, so a scrape never sees the series missing.
+// s.mu must be held.
+func (s *Server) recordBacklogLocked(coordinator string, pending map[string]int32) {
+	_, before := s.pendingLocked()
+	if len(pending) == 0 {
+		delete(s.pendingBy, coordinator)
+	} else {
+		s.pendingBy[coordinator] = pending
+	}
+	_, perOp := s.pendingLocked()
+	for op := range before {
+		if _, ok := perOp[op]; !ok {
+			pendingTasks.WithLabelValues(op).Set(0)
+		}
+	}
+	for op, n := range perOp {
+		pendingTasks.WithLabelValues(op).Set(float64(n))
+	}
+}
+
+// pendingLocked sums the coordinators' backlog reports. s.mu must be held.
+func (s *Server) pendingLocked() (total int, perOp map[string]int) {
+	perOp = make(map[string]int)
+	for _, byOp := range s.pendingBy {
+		for op, n := range byOp {
+			perOp[op] += int(n)
+			total += int(n)
+		}
+	}
+	return total, perOp
+}
+
 func (s *Server) snapshotJSON() ([]byte, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	pending, pendingPerOp := s.pendingLocked()
 	payload := map[string]interface{}{
+		"type":     "snapshot",
 		"variants": s.variants,
 		"metrics": map[string]interface{}{
-			"total_uploads":   s.totalUploads,
-			"total_variants":  s.totalVariants,
-			"failed_variants": s.failedVariants,
-			"worker_active":   s.activeWorkers,
-			"worker_started":  s.startedWorkers,
+			"total_uploads":      s.totalUploads,
+			"total_variants":     s.totalVariants,
+			"failed_variants":    s.failedVariants,
+			"exhausted_variants": s.exhaustedVariants,
+			"worker_active":      s.activeWorkers,
+			"worker_started":     s.startedWorkers,
+			"pending_tasks":      pending,
 			"per_op": map[string]interface{}{
-				"active":  s.activeWorkersPerOp,
-				"success": s.successPerOp,
-				"failed":  s.failedPerOp,
+				"active":    s.activeWorkersPerOp,
+				"pending":   pendingPerOp,
+				"success":   s.successPerOp,
+				"failed":    s.failedPerOp,
+				"exhausted": s.exhaustedPerOp,
 			},
 		},
+		"failures": s.failures,
 	}
 	return json.Marshal(payload)
 }
 
+// variantDone is the delta event for one finished op.
+type variantDone struct {
+	Type    string `json:"type"`
+	ImageID string `json:"image_id"`
+	Op      string `json:"op"`
+	// Variant is the name it's stored under; it differs from Op for sized
+	// thumbnails and on-the-fly renders.
+	Variant string `json:"variant"`
+	Success bool   `json:"success"`
+	URL     string `json:"url,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// eventSub is one /events stream. dropped is closed when a delta
+// subscriber falls too far behind to be kept in sync.
+type eventSub struct {
+	ch      chan []byte
+	delta   bool
+	dropped chan struct{}
+}
+
+// broadcastSnapshot sends the current snapshot to the subscribers that
+// didn't ask for deltas, building it only when there are any.
 func (s *Server) broadcastSnapshot() {
+	s.eventsMu.Lock()
+	want := false
+	for sub := range s.eventSubs {
+		want = want || !sub.delta
+	}
+	s.eventsMu.Unlock()
+	if !want {
+		return
+	}
 	b, err := s.snapshotJSON()
 	if err != nil {
 		return
 	}
+	s.broadcast(b, false)
+}
+
+// broadcastDelta sends ev to the delta subscribers.
+func (s *Server) broadcastDelta(ev variantDone) {
+	ev.Type = "variant_done"
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	s.broadcast(b, true)
+}
+
+// broadcast queues b for each subscriber in the given mode. A snapshot
+// subscriber too far behind just misses this one, as the next supersedes
+// it; a delta subscriber can't recover a lost delta, so it is dropped and
+// its stream ends.
+func (s *Server) broadcast(b []byte, delta bool) {
 	s.eventsMu.Lock()
-	for ch := range s.eventSubs {
+	defer s.eventsMu.Unlock()
+	for sub := range s.eventSubs {
+		if sub.delta != delta {
+			continue
+		}
 		select {
-		case ch <- b:
+		case sub.ch <- b:
 		default:
+			if delta {
+				delete(s.eventSubs, sub)
+				close(sub.dropped)
+			}
 		}
 	}
-	s.eventsMu.Unlock()
 }
 
 // Admin scale: POST {op:"thumbnail", n:2}
@@ -370,37 +1594,42 @@ func (s *Server) handleScale(w http.ResponseWriter, r *http.Request) {
 	}
 	var body reqBody
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, "bad json", 400)
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, "bad json")
 		return
 	}
-	if body.N <= 0 || body.Op == "" {
-		http.Error(w, "invalid params", 400)
+	if body.N == 0 || body.Op == "" {
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, "invalid params")
 		return
 	}
-	actorType := map[string]string{
-		"thumbnail": "worker-thumb",
-		"grayscale": "worker-gray",
-		"blur":      "worker-blur",
-		"rotate90":  "worker-rot",
-	}[body.Op]
-	if actorType == "" {
-		http.Error(w, "unknown op", 400)
+	actorType, ok := ops.WorkerType(body.Op)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, codeUnknownOp, "unknown op")
 		return
 	}
 	client, err := grid.NewClient(s.Etcd, grid.ClientCfg{Namespace: s.Namespace})
 	if err != nil {
-		http.Error(w, "grid client", 500)
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "grid client")
 		return
 	}
 	defer client.Close()
 	if err := client.WaitUntilServing(r.Context(), s.GridSrv.Name()); err != nil {
-		http.Error(w, "peer not serving", 500)
+		writeJSONError(w, http.StatusServiceUnavailable, codeUnavailable, "peer not serving")
+		return
+	}
+	if body.N < 0 {
+		stopped, err := s.scaleDown(r.Context(), client, body.Op, -body.N)
+		if err != nil {
+			s.log.Error("scale down: list workers", "op", body.Op, "err", err)
+			writeJSONError(w, http.StatusServiceUnavailable, codeUnavailable, "etcd unavailable")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]int{"stopped": stopped})
 		return
 	}
 	started := 0
 	for i := 0; i < body.N; i++ {
 		name := fmt.Sprintf("%s-%d", actorType, time.Now().UnixNano()+int64(i))
-		start := grid.NewActorStart(name)
+		start := grid.NewActorStart("%s", name)
 		start.Type = actorType
 		if _, err := client.RequestC(context.Background(), s.GridSrv.Name(), start); err == nil {
 			started++
@@ -409,7 +1638,37 @@ func (s *Server) handleScale(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]int{"started": started})
 }
 
+// scaleDown asks up to n of op's workers registered in etcd, newest first,
+// to drain, and returns how many acknowledged. Workers stop taking tasks
+// at once, hand queued ones to the op's other workers, and exit when their
+// in-flight ones are done.
+func (s *Server) scaleDown(ctx context.Context, client *grid.Client, op string, n int) (int, error) {
+	registered, err := s.registeredWorkers(ctx)
+	if err != nil {
+		return 0, err
+	}
+	workers := registered[op]
+	newestFirst(workers)
+	stopped := 0
+	for _, wk := range workers {
+		if stopped == n {
+			break
+		}
+		msg := &messages.SystemEvent{Event: messages.DrainEvent, Name: wk.Name, Op: op}
+		if _, err := client.RequestC(ctx, wk.Mailbox, msg); err != nil {
+			s.log.Warn("scale down", "op", op, "worker", wk.Name, "err", err)
+			continue
+		}
+		stopped++
+	}
+	return stopped, nil
+}
+
 func (s *Server) handleMetricsUI(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	uploads, variants, failed := s.totalUploads, s.totalVariants, s.failedVariants
+	active, started := s.activeWorkers, s.startedWorkers
+	s.mu.RUnlock()
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	fmt.Fprintf(w, `<!doctype html>
 <html><head><title>Image Factory Metrics</title>
@@ -424,16 +1683,33 @@ func (s *Server) handleMetricsUI(w http.ResponseWriter, r *http.Request) {
   <div>Workers started (lifetime): %d</div>
 </div>
 <p><a href="/metrics" target="_blank">Prometheus metrics</a></p>
-</body></html>`, s.totalUploads, s.totalVariants, s.failedVariants, s.activeWorkers, s.startedWorkers)
+</body></html>`, uploads, variants, failed, active, started)
 }
 
 func (s *Server) handleMetricsJSON(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pending, pendingPerOp := s.pendingLocked()
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]int{
+	json.NewEncoder(w).Encode(map[string]any{
 		"total_uploads":   s.totalUploads,
 		"total_variants":  s.totalVariants,
 		"failed_variants": s.failedVariants,
 		"worker_active":   s.activeWorkers,
 		"worker_started":  s.startedWorkers,
+
+		"exhausted_variants": s.exhaustedVariants,
+
+		"pending_tasks":        pending,
+		"pending_tasks_per_op": pendingPerOp,
+
+		"reconcile_missing_in_store": s.reconcile.MissingInStore,
+		"reconcile_missing_on_disk":  s.reconcile.MissingOnDisk,
+		"reconcile_fixed":            s.reconcile.Fixed,
+
+		"reprocess_dispatched":     s.reprocess.Dispatched,
+		"reprocess_failed":         s.reprocess.Failed,
+		"reprocess_prefetch_hits":  s.reprocess.PrefetchHits,
+		"reprocess_prefetch_waits": s.reprocess.PrefetchWaits,
 	})
 }
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+
+	"example.com/image-factory/pkg/messages"
+	"example.com/image-factory/pkg/ops"
+	"example.com/image-factory/pkg/storage"
+)
+
+// newTestServer returns a Server over a temp data dir and st (nil for no
+// store), with no grid or etcd behind it.
+func newTestServer(t *testing.T, st storage.Store) *Server {
+	t.Helper()
+	return newServer(nil, "test", nil, t.TempDir(), st, BuildInfo{})
+}
+
+// get serves a GET for target through the full router and decodes a JSON
+// response into out, when given.
+func get(t *testing.T, s *Server, target string, out any) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if out != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("GET %s: decode %q: %v", target, rec.Body.String(), err)
+		}
+	}
+	return rec
+}
+
+// postUpload sends the JPEG at jpegPath to /upload as a multipart file with
+// fields as form values. Events the upload dispatches are appended to sent
+// when it's non-nil, and succeed.
+func postUpload(t *testing.T, s *Server, jpegPath string, fields map[string]string, sent *[]*messages.UploadEvent) *httptest.ResponseRecorder {
+	t.Helper()
+	s.dispatch = func(ctx context.Context, ev *messages.UploadEvent) error {
+		if sent != nil {
+			*sent = append(*sent, ev)
+		}
+		return nil
+	}
+	return serveUpload(t, s, jpegPath, fields)
+}
+
+// serveUpload sends the JPEG at jpegPath to /upload as postUpload does,
+// leaving s.dispatch as the test set it.
+func serveUpload(t *testing.T, s *Server, jpegPath string, fields map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	fw, err := mw.CreateFormFile("file", "photo.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(jpegPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, req)
+	return rec
+}
+
+// testImageID returns the i'th of a sorted run of valid image ids.
+func testImageID(i int) string {
+	return fmt.Sprintf("00000000-0000-4000-8000-%012d", i)
+}
+
+type imagesPage struct {
+	Images []imageEntry `json:"images"`
+	Total  int          `json:"total"`
+	Next   string       `json:"next"`
+}
+
+func TestImagesPagination(t *testing.T) {
+	s := newTestServer(t, nil)
+	// inserted out of order; pages come back sorted by id
+	for _, i := range []int{3, 1, 4, 0, 2} {
+		id := testImageID(i)
+		s.variants[id] = map[string]string{"thumbnail": "/images/" + id + "/thumbnail"}
+	}
+
+	var got []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("pagination never ended")
+		}
+		var page imagesPage
+		if rec := get(t, s, "/images?limit=2&cursor="+cursor, &page); rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		if page.Total != 5 {
+			t.Errorf("total = %d, want 5", page.Total)
+		}
+		if len(page.Images) > 2 {
+			t.Errorf("page has %d images, limit 2", len(page.Images))
+		}
+		for _, img := range page.Images {
+			got = append(got, img.ID)
+			if img.Variants["thumbnail"] == "" {
+				t.Errorf("%s: no thumbnail in %v", img.ID, img.Variants)
+			}
+		}
+		if page.Next == "" {
+			break
+		}
+		cursor = page.Next
+	}
+	for i, id := range got {
+		if id != testImageID(i) {
+			t.Fatalf("listing = %v, want ids 0-4 in order", got)
+		}
+	}
+	if len(got) != 5 {
+		t.Fatalf("listed %d images, want 5", len(got))
+	}
+}
+
+// listingStore lists images from a fixed page, recording the filter each
+// listing asked for.
+type listingStore struct {
+	storage.Store
+	filters []storage.ImageFilter
+}
+
+func (l *listingStore) ListImages(ctx context.Context, limit int, cursor string, filter storage.ImageFilter) ([]storage.ImageSummary, string, int, error) {
+	l.filters = append(l.filters, filter)
+	return []storage.ImageSummary{{ImageID: testImageID(1), Ops: []string{"thumbnail"}}}, testImageID(1), 7, nil
+}
+
+func TestImagesFromStore(t *testing.T) {
+	st := &listingStore{}
+	s := newTestServer(t, st)
+	// the in-memory index, lost on restart, isn't consulted
+	s.variants[testImageID(2)] = map[string]string{"blur": "/images/" + testImageID(2) + "/blur"}
+	for _, tt := range []struct {
+		query string
+		want  storage.ImageFilter
+	}{
+		{"", storage.ImageFilter{}},
+		{"?op=thumbnail&missing=blur&missing=sepia", storage.ImageFilter{Has: []string{"thumbnail"}, Missing: []string{"blur", "sepia"}}},
+	} {
+		var page imagesPage
+		if rec := get(t, s, "/images"+tt.query, &page); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tt.query, rec.Code, rec.Body)
+		}
+		if page.Total != 7 || page.Next != testImageID(1) || len(page.Images) != 1 || page.Images[0].ID != testImageID(1) {
+			t.Errorf("%s: page = %+v, want the store's one image of 7", tt.query, page)
+		}
+		got := st.filters[len(st.filters)-1]
+		if !slices.Equal(got.Has, tt.want.Has) || !slices.Equal(got.Missing, tt.want.Missing) {
+			t.Errorf("%s: store filter = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestImagesInvalidLimit(t *testing.T) {
+	s := newTestServer(t, nil)
+	for _, limit := range []string{"0", "-1", "x"} {
+		if rec := get(t, s, "/images?limit="+limit, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("limit=%s: status %d, want 400", limit, rec.Code)
+		}
+	}
+}
+
+func TestOpsListsDefaultFormats(t *testing.T) {
+	s := newTestServer(t, nil)
+	var got []ops.Spec
+	if rec := get(t, s, "/ops", &got); rec.Code != http.StatusOK {
+		t.Fatalf("GET /ops: %d %s", rec.Code, rec.Body)
+	}
+	if len(got) != len(ops.All()) {
+		t.Fatalf("listed %d ops, registry has %d", len(got), len(ops.All()))
+	}
+	for _, spec := range got {
+		if spec.DefaultFormat == "" || spec.DefaultFormat != ops.OutputFormat(spec.Name, "") {
+			t.Errorf("%s: listed default %q, worker would write %q", spec.Name, spec.DefaultFormat, ops.OutputFormat(spec.Name, ""))
+		}
+	}
+}
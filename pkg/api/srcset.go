@@ -0,0 +1,52 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"example.com/image-factory/pkg/ops"
+)
+
+// variantSize is a produced variant's pixel dimensions.
+type variantSize struct {
+	Width  int
+	Height int
+}
+
+// srcsetLocked builds srcset strings from an image's responsive variants:
+// a width list ("url 200w, url 800w") and, with SrcsetBaseWidth set, a
+// density list ("url 1x, url 2x"). Callers hold s.mu.
+func (s *Server) srcsetLocked(id string) (widths, densities string) {
+	type candidate struct {
+		url   string
+		width int
+	}
+	var cands []candidate
+	for op, url := range s.variants[id] {
+		spec, ok := ops.Lookup(op)
+		if !ok || !spec.Responsive {
+			continue
+		}
+		if sz, ok := s.sizes[id][op]; ok && sz.Width > 0 {
+			cands = append(cands, candidate{url, sz.Width})
+		}
+	}
+	sort.Slice(cands, func(i, j int) bool { return cands[i].width < cands[j].width })
+
+	var w, d []string
+	seenW, seenD := map[int]bool{}, map[int]bool{}
+	for _, c := range cands {
+		if !seenW[c.width] {
+			seenW[c.width] = true
+			w = append(w, fmt.Sprintf("%s %dw", c.url, c.width))
+		}
+		if base := s.SrcsetBaseWidth; base > 0 && c.width%base == 0 {
+			if k := c.width / base; !seenD[k] {
+				seenD[k] = true
+				d = append(d, fmt.Sprintf("%s %dx", c.url, k))
+			}
+		}
+	}
+	return strings.Join(w, ", "), strings.Join(d, ", ")
+}
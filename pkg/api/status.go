@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+// jobStatus is the GET /status/{id} response.
+type jobStatus struct {
+	ImageID    string            `json:"image_id"`
+	Dispatched int               `json:"dispatched"`
+	Succeeded  []string          `json:"succeeded"`
+	Failed     map[string]string `json:"failed"`
+	Pending    []string          `json:"pending"`
+	Done       bool              `json:"done"`
+}
+
+// GET /status/{id} reports an upload's progress: the ops dispatched for it,
+// which have succeeded or failed, and which are still pending. Images
+// uploaded before a restart have no recorded op set, so their status covers
+// the variants and failures seen since.
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	s.mu.RLock()
+	expected, ok := s.expected[id]
+	variants := s.variants[id]
+	failures := s.failures[id]
+	if !ok {
+		for op := range variants {
+			expected = append(expected, op)
+		}
+		for op := range failures {
+			if _, done := variants[op]; !done {
+				expected = append(expected, op)
+			}
+		}
+		sort.Strings(expected)
+	}
+	st := jobStatus{
+		ImageID:    id,
+		Dispatched: len(expected),
+		Succeeded:  []string{},
+		Failed:     map[string]string{},
+		Pending:    []string{},
+	}
+	for _, op := range expected {
+		if _, done := variants[op]; done {
+			st.Succeeded = append(st.Succeeded, op)
+		} else if reason, failed := failures[op]; failed {
+			st.Failed[op] = reason
+		} else {
+			st.Pending = append(st.Pending, op)
+		}
+	}
+	s.mu.RUnlock()
+	if len(expected) == 0 {
+		writeNotFound(w, "image not found")
+		return
+	}
+	st.Done = len(st.Pending) == 0
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
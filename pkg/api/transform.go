@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+
+	"example.com/image-factory/pkg/actors"
+	"example.com/image-factory/pkg/imageops"
+	"example.com/image-factory/pkg/messages"
+	"example.com/image-factory/pkg/ops"
+	"example.com/image-factory/pkg/storage"
+	"github.com/lytics/grid/v3"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// maxRenderDimension caps w and h on GET /transform, and width and height
+// on /upload, at what the ops will render.
+const maxRenderDimension = imageops.MaxDimension
+
+// errRenderFailed wraps a worker's reason for failing an on-the-fly render.
+type errRenderFailed struct{ reason string }
+
+func (e *errRenderFailed) Error() string { return e.reason }
+
+// GET /transform?id=&op=&w=&h=&format= returns the variant, rendering it
+// synchronously on a worker when it doesn't exist yet. w and h size a
+// thumbnail or resize and must be among TransformSizes. The output is stored like an async variant under a
+// name derived from the request, e.g. thumbnail_w300, so later requests
+// (and GET /images/{id}/{name}) are served without rendering.
+func (s *Server) handleTransform(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	id, op := q.Get("id"), q.Get("op")
+	if id == "" || op == "" {
+		writeJSONError(w, http.StatusBadRequest, codeBadRequest, "id and op required")
+		return
+	}
+	if !validImageID(id) {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidID, "invalid image id")
+		return
+	}
+	if _, ok := ops.Lookup(op); !ok {
+		writeJSONError(w, http.StatusBadRequest, codeUnknownOp, "unknown op")
+		return
+	}
+	name := op
+	params := map[string]any{}
+	for _, d := range []struct{ arg, param string }{{"w", "width"}, {"h", "height"}} {
+		v := q.Get(d.arg)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxRenderDimension {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidParam, fmt.Sprintf("%s must be 1-%d", d.arg, maxRenderDimension))
+			return
+		}
+		if !slices.Contains(s.TransformSizes, n) {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidParam, fmt.Sprintf("%s must be one of TRANSFORM_SIZES %v", d.arg, s.TransformSizes))
+			return
+		}
+		params[d.param] = n
+		name += fmt.Sprintf("_%s%d", d.arg, n)
+	}
+	if len(params) > 0 && op != "thumbnail" && op != "resize" {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidParam, "w and h apply to thumbnail and resize")
+		return
+	}
+	if op == "resize" && len(params) == 0 {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidParam, "resize requires w and/or h")
+		return
+	}
+	format := ""
+	if v := q.Get("format"); v != "" {
+		f, ok := ops.NormalizeFormat(v)
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, codeUnsupportedFormat, "unsupported format")
+			return
+		}
+		format = f
+		name += "_" + f
+	}
+
+	w.Header().Set("Cache-Control", s.variantCacheControl(op))
+	if data, ct, ok := s.existingVariant(r.Context(), id, name); ok {
+		s.serveVariantBytes(w, r, id, name, ct, data)
+		return
+	}
+	data, ct, err := s.render(r.Context(), "transform:"+id+"/"+name, func(ctx context.Context) ([]byte, string, error) {
+		return s.renderVariant(ctx, id, op, name, format, params)
+	})
+	if err != nil {
+		w.Header().Del("Cache-Control")
+		var failed *errRenderFailed
+		switch {
+		case errors.Is(err, os.ErrNotExist) || storage.IsNotFound(err):
+			writeNotFound(w, "image not found")
+		case errors.Is(err, actors.ErrNoWorkers):
+			writeJSONError(w, http.StatusServiceUnavailable, codeUnavailable, err.Error())
+		case errors.As(err, &failed):
+			writeJSONError(w, http.StatusUnprocessableEntity, codeRenderFailed, err.Error())
+		default:
+			writeRenderError(w, err)
+		}
+		return
+	}
+	s.serveVariantBytes(w, r, id, name, ct, data)
+}
+
+// existingVariant returns a stored variant by name, from the store or the
+// image's directory on disk.
+func (s *Server) existingVariant(ctx context.Context, id, name string) ([]byte, string, bool) {
+	if s.Store != nil {
+		if data, ct, err := s.getVariant(ctx, id, name); err == nil {
+			if ct == "" {
+				ct = "image/jpeg"
+			}
+			return data, ct, true
+		}
+	}
+	path, ok := variantFile(s.imageDir(id), name)
+	if !ok {
+		return nil, "", false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", false
+	}
+	return data, variantContentType(path), true
+}
+
+// renderVariant runs one task on a worker of op and waits for the output.
+// The worker reports the result on transform-updates as usual, which stores
+// and indexes it.
+func (s *Server) renderVariant(ctx context.Context, id, op, name, format string, params map[string]any) ([]byte, string, error) {
+	src := s.fetchOriginal(ctx, id)
+	if src.err != nil {
+		return nil, "", src.err
+	}
+	task := &messages.TransformTask{
+		ImageId:     id,
+		Op:          op,
+		Path:        src.path,
+		Format:      format,
+		Original:    src.data,
+		Attempt:     1,
+		MaxAttempts: 1,
+	}
+	if name != op {
+		task.Variant = name
+	}
+	if len(params) > 0 {
+		task.Params, _ = structpb.NewStruct(params)
+	}
+	client, err := grid.NewClient(s.Etcd, grid.ClientCfg{Namespace: s.Namespace})
+	if err != nil {
+		return nil, "", err
+	}
+	defer client.Close()
+	res, err := actors.SendTask(ctx, s.Etcd, s.Namespace, client, task)
+	if err != nil {
+		return nil, "", err
+	}
+	if !res.GetSuccess() {
+		return nil, "", &errRenderFailed{reason: res.GetError()}
+	}
+	data, err := os.ReadFile(res.GetPath())
+	if err != nil {
+		return nil, "", fmt.Errorf("read rendered variant: %v", err)
+	}
+	return data, variantContentType(res.GetPath()), nil
+}
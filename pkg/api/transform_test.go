@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestTransformSizes(t *testing.T) {
+	s := newTestServer(t, nil)
+	id := testImageID(1)
+	writeVariants(t, s, id, "thumbnail_w300", "thumbnail_w300_h300", "thumbnail_w301")
+	for _, tt := range []struct {
+		sizes  []int
+		query  string
+		status int
+	}{
+		{[]int{150, 300}, "&w=300", http.StatusOK},
+		{[]int{150, 300}, "&w=300&h=300", http.StatusOK},
+		{[]int{150, 300}, "&w=301", http.StatusBadRequest},
+		{[]int{150, 300}, "&w=300&h=301", http.StatusBadRequest},
+		{nil, "&w=300", http.StatusBadRequest},
+	} {
+		s.TransformSizes = tt.sizes
+		if rec := get(t, s, "/transform?id="+id+"&op=thumbnail"+tt.query, nil); rec.Code != tt.status {
+			t.Errorf("sizes %v, %s: status %d, want %d: %s", tt.sizes, tt.query, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/image-factory/pkg/imageops"
+	"example.com/image-factory/pkg/messages"
+)
+
+func TestUploadValidation(t *testing.T) {
+	jpg := writeJPEG(t, 32, 32)
+	for _, tt := range []struct {
+		name   string
+		fields map[string]string
+		status int
+	}{
+		{"largest width", map[string]string{"width": "4096"}, http.StatusOK},
+		{"width too large", map[string]string{"width": "4097"}, http.StatusBadRequest},
+		{"height too large", map[string]string{"height": "100000"}, http.StatusBadRequest},
+		{"zero width", map[string]string{"width": "0"}, http.StatusBadRequest},
+		{"watermark", map[string]string{"watermark": "© 2026"}, http.StatusOK},
+		{"watermark too long", map[string]string{"watermark": strings.Repeat("x", imageops.MaxWatermarkText+1)}, http.StatusBadRequest},
+		{"blur_radius", map[string]string{"blur_radius": "2"}, http.StatusOK},
+		{"blur_radius NaN", map[string]string{"blur_radius": "NaN"}, http.StatusBadRequest},
+		{"sharpen", map[string]string{"sharpen": "1.5"}, http.StatusOK},
+		{"sharpen NaN", map[string]string{"sharpen": "NaN"}, http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, nil)
+			if rec := postUpload(t, s, jpg, tt.fields, nil); rec.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+func TestUploadDispatchFailure(t *testing.T) {
+	jpg := writeJPEG(t, 32, 32)
+	timedOut := fmt.Errorf("%w: %w", errUnconfirmed, context.DeadlineExceeded)
+
+	t.Run("refused", func(t *testing.T) {
+		s := newTestServer(t, nil)
+		s.dispatch = func(context.Context, *messages.UploadEvent) error { return errors.New("grid: unknown mailbox") }
+		rec := serveUpload(t, s, jpg, nil)
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("status %d, want 503: %s", rec.Code, rec.Body)
+		}
+		if ids := s.diskImageIDs(); len(ids) != 0 {
+			t.Errorf("refused upload left %v on disk", ids)
+		}
+	})
+
+	t.Run("unconfirmed then confirmed", func(t *testing.T) {
+		s := newTestServer(t, nil)
+		s.UnconfirmedTimeout = 50 * time.Millisecond
+		s.dispatch = func(context.Context, *messages.UploadEvent) error { return timedOut }
+		rec := serveUpload(t, s, jpg, nil)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("status %d, want 202: %s", rec.Code, rec.Body)
+		}
+		var resp struct {
+			ImageID string   `json:"image_id"`
+			Pending []string `json:"pending"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Pending) == 0 {
+			t.Errorf("response %s lists nothing pending", rec.Body)
+		}
+		s.confirmUpload(resp.ImageID)
+		time.Sleep(100 * time.Millisecond)
+		if _, err := os.Stat(s.imageDir(resp.ImageID)); err != nil {
+			t.Errorf("confirmed upload was abandoned: %v", err)
+		}
+		if s.totalUploads != 1 {
+			t.Errorf("totalUploads = %d, want 1", s.totalUploads)
+		}
+	})
+
+	t.Run("unconfirmed then abandoned", func(t *testing.T) {
+		s := newTestServer(t, nil)
+		s.UnconfirmedTimeout = 10 * time.Millisecond
+		s.dispatch = func(context.Context, *messages.UploadEvent) error { return timedOut }
+		rec := serveUpload(t, s, jpg, nil)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("status %d, want 202: %s", rec.Code, rec.Body)
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for len(s.diskImageIDs()) > 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("unconfirmed upload never abandoned")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		if len(s.expected) != 0 || s.totalUploads != 0 {
+			t.Errorf("abandoned upload still counted: expected %v, total %d", s.expected, s.totalUploads)
+		}
+	})
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+
+	"example.com/image-factory/pkg/imageops"
+	"github.com/gorilla/mux"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var (
+	// imageIDPattern matches the lowercase uuids /upload assigns.
+	imageIDPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	// variantNamePattern matches op and variant names, e.g. thumbnail_300.
+	variantNamePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+)
+
+// validImageID reports whether id is an image id, and so safe to use as a
+// file name and store key.
+func validImageID(id string) bool { return imageIDPattern.MatchString(id) }
+
+// validVariantName reports whether name can be an op or variant name.
+func validVariantName(name string) bool { return variantNamePattern.MatchString(name) }
+
+// validateVars rejects a request whose {id} or {op} route variable isn't a
+// well-formed image id or op name with 400, before a handler joins it into
+// a path or looks it up in the store.
+func validateVars(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if id, ok := vars["id"]; ok && !validImageID(id) {
+			writeJSONError(w, http.StatusBadRequest, codeInvalidID, "invalid image id")
+			return
+		}
+		if op, ok := vars["op"]; ok && !validVariantName(op) {
+			writeJSONError(w, http.StatusBadRequest, codeUnknownOp, "invalid op")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateParams checks each op's params with the op's own parsing, so a
+// value a worker would reject fails the request rather than every task.
+func validateParams(params map[string]*structpb.Struct) error {
+	for _, op := range slices.Sorted(maps.Keys(params)) {
+		if !imageops.Has(op) {
+			// e.g. inspect, which reads no params
+			continue
+		}
+		if err := imageops.Validate(op, params[op].AsMap()); err != nil {
+			return fmt.Errorf("invalid params for %s: %v", op, err)
+		}
+	}
+	return nil
+}
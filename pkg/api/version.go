@@ -0,0 +1,27 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"example.com/image-factory/pkg/storage"
+)
+
+// BuildInfo identifies the running binary, as stamped in at build time.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+}
+
+// GET /version reports which build this peer runs and how it is set up, so
+// a fleet can be checked without shelling in.
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	_, spanner := s.Store.(*storage.SpannerStore)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		BuildInfo
+		Namespace string `json:"namespace"`
+		Spanner   bool   `json:"spanner"`
+	}{s.build, s.Namespace, spanner})
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// variantWaiters lets a request block until a given (image, op) variant
+// has been produced.
+type variantWaiters struct {
+	mu sync.Mutex
+	m  map[string][]chan string
+}
+
+func waiterKey(id, op string) string { return id + "/" + op }
+
+// add registers interest in id/op. Register before dispatching so an early
+// result can't be missed.
+func (v *variantWaiters) add(id, op string) chan string {
+	ch := make(chan string, 1)
+	v.mu.Lock()
+	if v.m == nil {
+		v.m = make(map[string][]chan string)
+	}
+	k := waiterKey(id, op)
+	v.m[k] = append(v.m[k], ch)
+	v.mu.Unlock()
+	return ch
+}
+
+func (v *variantWaiters) remove(id, op string, ch chan string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	k := waiterKey(id, op)
+	list := v.m[k]
+	for i, c := range list {
+		if c == ch {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(v.m, k)
+	} else {
+		v.m[k] = list
+	}
+}
+
+// notify wakes every waiter for id/op with the variant URL ("" on failure).
+func (v *variantWaiters) notify(id, op, url string) {
+	v.mu.Lock()
+	k := waiterKey(id, op)
+	list := v.m[k]
+	delete(v.m, k)
+	v.mu.Unlock()
+	for _, ch := range list {
+		ch <- url
+	}
+}
+
+// awaitVariant waits up to timeout for a waiter registered with add and
+// returns the URL, or "" on failure, timeout, or cancellation.
+func (s *Server) awaitVariant(ctx context.Context, id, op string, ch chan string, timeout time.Duration) string {
+	defer s.waiters.remove(id, op, ch)
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case url := <-ch:
+		return url
+	case <-t.C:
+	case <-ctx.Done():
+	}
+	return ""
+}
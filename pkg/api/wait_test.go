@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUploadWaitFor(t *testing.T) {
+	jpg := writeJPEG(t, 32, 32)
+	for _, tt := range []struct {
+		name       string
+		configured string
+		fields     map[string]string
+		status     int
+		waited     bool
+	}{
+		{"default op", "", map[string]string{"wait_for": "thumbnail"}, http.StatusOK, true},
+		{"op added by its params", "", map[string]string{"wait_for": "resize", "width": "16"}, http.StatusOK, true},
+		{"op not requested", "", map[string]string{"wait_for": "resize"}, http.StatusBadRequest, false},
+		{"op outside the selection", "", map[string]string{"wait_for": "blur", "ops": "thumbnail"}, http.StatusBadRequest, false},
+		{"unknown op", "", map[string]string{"wait_for": "nope"}, http.StatusBadRequest, false},
+		{"configured op", "thumbnail", nil, http.StatusOK, true},
+		{"configured op not requested", "blur", map[string]string{"ops": "thumbnail"}, http.StatusOK, false},
+		{"none overrides configured", "thumbnail", map[string]string{"wait_for": "none"}, http.StatusOK, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, nil)
+			s.WaitForOp = tt.configured
+			s.WaitTimeout = time.Millisecond
+			rec := postUpload(t, s, jpg, tt.fields, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Pending []string `json:"pending"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			// nothing produces the variant, so a wait always times out
+			if waited := len(resp.Pending) > 0; waited != tt.waited {
+				t.Errorf("waited = %v, want %v (%s)", waited, tt.waited, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"example.com/image-factory/pkg/messages"
+	"github.com/gorilla/mux"
+	"github.com/lytics/grid/v3"
+	etcdv3 "go.etcd.io/etcd/client/v3"
+)
+
+// workerInfo is one worker registered for discovery, as listed by
+// /admin/workers.
+type workerInfo struct {
+	Name         string     `json:"name"`
+	Mailbox      string     `json:"mailbox"`
+	RegisteredAt *time.Time `json:"registered_at,omitempty"`
+}
+
+// GET /admin/workers lists, per op, the workers registered under the etcd
+// discovery prefix: exactly the set the coordinator dispatches to.
+func (s *Server) handleWorkers(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	out, err := s.registeredWorkers(ctx)
+	if err != nil {
+		s.log.Error("list workers", "err", err)
+		writeJSONError(w, http.StatusServiceUnavailable, codeUnavailable, "etcd unavailable")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// registeredWorkers reads the etcd discovery registry into each op's
+// workers, sorted by mailbox.
+func (s *Server) registeredWorkers(ctx context.Context) (map[string][]workerInfo, error) {
+	prefix := fmt.Sprintf("/%s/workers/", s.Namespace)
+	resp, err := s.Etcd.Get(ctx, prefix, etcdv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	out := map[string][]workerInfo{}
+	for _, kv := range resp.Kvs {
+		// keys are <prefix><op>/<mailbox>, mailboxes worker-<op>-<name>
+		op, mailbox, ok := strings.Cut(strings.TrimPrefix(string(kv.Key), prefix), "/")
+		if !ok {
+			continue
+		}
+		info := workerInfo{Name: strings.TrimPrefix(mailbox, "worker-"+op+"-"), Mailbox: mailbox}
+		if t, err := time.Parse(time.RFC3339, string(kv.Value)); err == nil {
+			info.RegisteredAt = &t
+		}
+		out[op] = append(out[op], info)
+	}
+	for _, ws := range out {
+		sort.Slice(ws, func(i, j int) bool { return ws[i].Mailbox < ws[j].Mailbox })
+	}
+	return out, nil
+}
+
+// newestFirst orders workers by registration time, latest first. Workers
+// without one go last, by name descending: scaled-up names end in a start
+// timestamp, so that too puts the newest first.
+func newestFirst(ws []workerInfo) {
+	sort.SliceStable(ws, func(i, j int) bool {
+		a, b := ws[i].RegisteredAt, ws[j].RegisteredAt
+		switch {
+		case a != nil && b != nil && !a.Equal(*b):
+			return a.After(*b)
+		case (a == nil) != (b == nil):
+			return a != nil
+		}
+		return ws[i].Name > ws[j].Name
+	})
+}
+
+// POST /admin/workers/{op}/{name}/drain drains one registered worker: it
+// deregisters, finishes its in-flight tasks, hands queued ones to the op's
+// other workers, and exits. The name is as /admin/workers lists it.
+func (s *Server) handleDrainWorker(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	op, name := vars["op"], vars["name"]
+	mailbox := "worker-" + op + "-" + name
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	resp, err := s.Etcd.Get(ctx, fmt.Sprintf("/%s/workers/%s/%s", s.Namespace, op, mailbox))
+	if err != nil {
+		s.log.Error("drain worker", "op", op, "worker", name, "err", err)
+		writeJSONError(w, http.StatusServiceUnavailable, codeUnavailable, "etcd unavailable")
+		return
+	}
+	if len(resp.Kvs) == 0 {
+		writeNotFound(w, "worker not registered")
+		return
+	}
+	client, err := grid.NewClient(s.Etcd, grid.ClientCfg{Namespace: s.Namespace})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, codeInternal, "grid client")
+		return
+	}
+	defer client.Close()
+	msg := &messages.SystemEvent{Event: messages.DrainEvent, Name: name, Op: op}
+	if _, err := client.RequestC(ctx, mailbox, msg); err != nil {
+		s.log.Warn("drain worker", "op", op, "worker", name, "err", err)
+		writeJSONError(w, http.StatusBadGateway, codeUpstream, "worker unreachable")
+		return
+	}
+	s.log.Info("draining worker", "op", op, "worker", name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"draining": mailbox})
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestNewestFirst(t *testing.T) {
+	at := func(min int) *time.Time {
+		t := time.Date(2026, 1, 1, 12, min, 0, 0, time.UTC)
+		return &t
+	}
+	ws := []workerInfo{
+		{Name: "thumbnail-worker-100", RegisteredAt: at(1)},
+		{Name: "thumbnail-worker-300"},
+		{Name: "thumbnail-worker-200", RegisteredAt: at(5)},
+		{Name: "thumbnail-worker-400"},
+		{Name: "thumbnail-worker-150", RegisteredAt: at(5)},
+	}
+	newestFirst(ws)
+	var got []string
+	for _, w := range ws {
+		got = append(got, w.Name)
+	}
+	want := []string{"thumbnail-worker-200", "thumbnail-worker-150", "thumbnail-worker-100", "thumbnail-worker-400", "thumbnail-worker-300"}
+	if !slices.Equal(got, want) {
+		t.Errorf("order %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,302 @@
+// Package imageops holds the image operations the factory runs, keyed by op
+// name, so workers, synchronous endpoints and tests all apply them the same
+// way.
+package imageops
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sync"
+
+	"github.com/disintegration/imaging"
+)
+
+// MaxDimension is the largest width or height an op will size its output
+// to; bigger requests are rejected rather than allocated.
+const MaxDimension = 4096
+
+// Handler applies one op to img. params holds the op's options as decoded
+// from JSON: numbers as float64 (any Go integer or float is accepted too)
+// and strings as string.
+type Handler func(img image.Image, params map[string]any) (*image.NRGBA, error)
+
+var (
+	mu       sync.RWMutex
+	handlers = map[string]Handler{
+		"thumbnail":  thumbnail,
+		"grayscale":  func(img image.Image, _ map[string]any) (*image.NRGBA, error) { return imaging.Grayscale(img), nil },
+		"blur":       blur,
+		"rotate90":   func(img image.Image, _ map[string]any) (*image.NRGBA, error) { return imaging.Rotate90(img), nil },
+		"sharpen":    sharpen,
+		"flip_h":     func(img image.Image, _ map[string]any) (*image.NRGBA, error) { return imaging.FlipH(img), nil },
+		"flip_v":     func(img image.Image, _ map[string]any) (*image.NRGBA, error) { return imaging.FlipV(img), nil },
+		"sepia":      sepia,
+		"brightness": tone(imaging.AdjustBrightness),
+		"contrast":   tone(imaging.AdjustContrast),
+		"resize":     resize,
+		"crop":       crop,
+		"watermark":  watermark,
+	}
+)
+
+// Register adds or replaces the handler for op.
+func Register(op string, h Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+	handlers[op] = h
+}
+
+// Has reports whether op has a handler.
+func Has(op string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	_, ok := handlers[op]
+	return ok
+}
+
+// validators check an op's params the way its handler parses them, for ops
+// that take any.
+var validators = map[string]func(params map[string]any) error{
+	"thumbnail":  func(p map[string]any) error { _, _, err := thumbnailSize(p); return err },
+	"blur":       func(p map[string]any) error { _, err := blurRadius(p); return err },
+	"sharpen":    func(p map[string]any) error { _, err := sharpenSigma(p); return err },
+	"sepia":      func(p map[string]any) error { _, err := sepiaIntensity(p); return err },
+	"brightness": func(p map[string]any) error { _, err := floatParam(p, "percentage", 0); return err },
+	"contrast":   func(p map[string]any) error { _, err := floatParam(p, "percentage", 0); return err },
+	"resize":     func(p map[string]any) error { _, _, err := resizeSize(p); return err },
+	"crop":       func(p map[string]any) error { _, err := cropParams(p); return err },
+	"watermark":  func(p map[string]any) error { _, err := watermarkParams(p); return err },
+}
+
+// Validate checks params for op without an image, so a value its handler
+// would reject fails where it's submitted rather than on every task. Ops
+// added with Register are only checked to exist.
+func Validate(op string, params map[string]any) error {
+	if !Has(op) {
+		return fmt.Errorf("unknown op %s", op)
+	}
+	if v, ok := validators[op]; ok {
+		return v(params)
+	}
+	return nil
+}
+
+// Apply runs op's handler on img.
+func Apply(img image.Image, op string, params map[string]any) (*image.NRGBA, error) {
+	mu.RLock()
+	h, ok := handlers[op]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown op %s", op)
+	}
+	return h(img, params)
+}
+
+// thumbnail fits img into a width x height box, 200x200 unless the params
+// (a sized variant or on-the-fly render) ask for another; one dimension
+// alone makes a square.
+func thumbnail(img image.Image, params map[string]any) (*image.NRGBA, error) {
+	width, height, err := thumbnailSize(params)
+	if err != nil {
+		return nil, err
+	}
+	return imaging.Thumbnail(img, width, height, imaging.Lanczos), nil
+}
+
+func thumbnailSize(params map[string]any) (width, height int, err error) {
+	if width, err = dimensionParam(params, "width"); err != nil {
+		return 0, 0, err
+	}
+	if height, err = dimensionParam(params, "height"); err != nil {
+		return 0, 0, err
+	}
+	if width == 0 && height == 0 {
+		width, height = 200, 200
+	} else if width == 0 {
+		width = height
+	} else if height == 0 {
+		height = width
+	}
+	return width, height, nil
+}
+
+func blur(img image.Image, params map[string]any) (*image.NRGBA, error) {
+	radius, err := blurRadius(params)
+	if err != nil {
+		return nil, err
+	}
+	return imaging.Blur(img, radius), nil
+}
+
+func blurRadius(params map[string]any) (float64, error) {
+	radius, err := floatParam(params, "radius", 3.0)
+	if err != nil {
+		return 0, err
+	}
+	if radius <= 0 {
+		return 0, fmt.Errorf("radius must be positive, got %v", radius)
+	}
+	return radius, nil
+}
+
+func sharpen(img image.Image, params map[string]any) (*image.NRGBA, error) {
+	sigma, err := sharpenSigma(params)
+	if err != nil {
+		return nil, err
+	}
+	return imaging.Sharpen(img, sigma), nil
+}
+
+func sharpenSigma(params map[string]any) (float64, error) {
+	sigma, err := floatParam(params, "sigma", 1.0)
+	if err != nil {
+		return 0, err
+	}
+	if sigma < 0 {
+		return 0, fmt.Errorf("sigma must be non-negative, got %v", sigma)
+	}
+	return sigma, nil
+}
+
+// sepia tones img with the usual sepia matrix, blended with the original
+// by the intensity param (0 leaves it untouched, 1, the default, is full
+// sepia).
+func sepia(img image.Image, params map[string]any) (*image.NRGBA, error) {
+	intensity, err := sepiaIntensity(params)
+	if err != nil {
+		return nil, err
+	}
+	return imaging.AdjustFunc(img, func(c color.NRGBA) color.NRGBA {
+		r, g, b := float64(c.R), float64(c.G), float64(c.B)
+		tone := func(v, tr, tg, tb float64) uint8 {
+			t := math.Min(255, r*tr+g*tg+b*tb)
+			return uint8(v + (t-v)*intensity + 0.5)
+		}
+		return color.NRGBA{
+			R: tone(r, 0.393, 0.769, 0.189),
+			G: tone(g, 0.349, 0.686, 0.168),
+			B: tone(b, 0.272, 0.534, 0.131),
+			A: c.A,
+		}
+	}), nil
+}
+
+func sepiaIntensity(params map[string]any) (float64, error) {
+	intensity, err := floatParam(params, "intensity", 1.0)
+	if err != nil {
+		return 0, err
+	}
+	if intensity < 0 || intensity > 1 {
+		return 0, fmt.Errorf("intensity must be between 0 and 1, got %v", intensity)
+	}
+	return intensity, nil
+}
+
+// tone adapts a brightness/contrast style adjustment taking a signed
+// percentage param, clamped to -100..100.
+func tone(adjust func(image.Image, float64) *image.NRGBA) Handler {
+	return func(img image.Image, params map[string]any) (*image.NRGBA, error) {
+		pct, err := floatParam(params, "percentage", 0)
+		if err != nil {
+			return nil, err
+		}
+		return adjust(img, math.Max(-100, math.Min(100, pct))), nil
+	}
+}
+
+func resize(img image.Image, params map[string]any) (*image.NRGBA, error) {
+	width, height, err := resizeSize(params)
+	if err != nil {
+		return nil, err
+	}
+	// a zero dimension preserves the aspect ratio
+	if width, height, err = fitAspect(img.Bounds().Size(), width, height); err != nil {
+		return nil, err
+	}
+	return imaging.Resize(img, width, height, imaging.Lanczos), nil
+}
+
+// fitAspect fills in the zero one of width and height from src's aspect
+// ratio, rounding as imaging.Resize does, and rejects a derived side over
+// MaxDimension: a 1x4096 original resized to width 4096 would otherwise be
+// 16 million pixels tall.
+func fitAspect(src image.Point, width, height int) (int, int, error) {
+	if src.X <= 0 || src.Y <= 0 || (width > 0 && height > 0) {
+		return width, height, nil
+	}
+	if width == 0 {
+		w := math.Max(1, math.Floor(float64(height)*float64(src.X)/float64(src.Y)+0.5))
+		if w > MaxDimension {
+			return 0, 0, fmt.Errorf("resize to height %d would make the %dx%d image %.0f wide, over %d", height, src.X, src.Y, w, MaxDimension)
+		}
+		return int(w), height, nil
+	}
+	h := math.Max(1, math.Floor(float64(width)*float64(src.Y)/float64(src.X)+0.5))
+	if h > MaxDimension {
+		return 0, 0, fmt.Errorf("resize to width %d would make the %dx%d image %.0f tall, over %d", width, src.X, src.Y, h, MaxDimension)
+	}
+	return width, int(h), nil
+}
+
+func resizeSize(params map[string]any) (width, height int, err error) {
+	if width, err = dimensionParam(params, "width"); err != nil {
+		return 0, 0, err
+	}
+	if height, err = dimensionParam(params, "height"); err != nil {
+		return 0, 0, err
+	}
+	if width == 0 && height == 0 {
+		return 0, 0, errors.New("resize requires width and/or height")
+	}
+	return width, height, nil
+}
+
+func crop(img image.Image, params map[string]any) (*image.NRGBA, error) {
+	rect, err := cropRect(params, img.Bounds())
+	if err != nil {
+		return nil, err
+	}
+	return imaging.Crop(img, rect), nil
+}
+
+// cropRect reads x/y/width/height relative to the image's top-left corner
+// and clamps the rectangle to the bounds. It fails on a zero-area result.
+func cropRect(params map[string]any, bounds image.Rectangle) (image.Rectangle, error) {
+	r, err := cropParams(params)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	rect := r.Add(bounds.Min).Intersect(bounds)
+	if rect.Empty() {
+		return image.Rectangle{}, fmt.Errorf("crop rectangle %dx%d at (%d,%d) is outside the %dx%d image", r.Dx(), r.Dy(), r.Min.X, r.Min.Y, bounds.Dx(), bounds.Dy())
+	}
+	return rect, nil
+}
+
+// cropParams reads the crop rectangle, before it meets an image.
+func cropParams(params map[string]any) (image.Rectangle, error) {
+	x, err := offsetParam(params, "x")
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	y, err := offsetParam(params, "y")
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	// The rectangle is clamped to the image, so it needs no MaxDimension cap.
+	width, err := offsetParam(params, "width")
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	height, err := offsetParam(params, "height")
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	if width == 0 || height == 0 {
+		return image.Rectangle{}, errors.New("crop requires width and height")
+	}
+	return image.Rect(x, y, x+width, y+height), nil
+}
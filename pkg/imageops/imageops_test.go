@@ -0,0 +1,202 @@
+package imageops
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// fixture returns a w x h image with a gradient, so ops that move pixels
+// around have something to move.
+func fixture(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 255 / w), uint8(y * 255 / h), 128, 255})
+		}
+	}
+	return img
+}
+
+func TestApplyDimensions(t *testing.T) {
+	tests := []struct {
+		op     string
+		params map[string]any
+		want   image.Point
+	}{
+		{"thumbnail", nil, image.Pt(200, 200)},
+		{"thumbnail", map[string]any{"width": 100.0}, image.Pt(100, 100)},
+		{"thumbnail", map[string]any{"width": 120, "height": 60}, image.Pt(120, 60)},
+		{"grayscale", nil, image.Pt(400, 300)},
+		{"blur", map[string]any{"radius": 1.5}, image.Pt(400, 300)},
+		{"rotate90", nil, image.Pt(300, 400)},
+		{"sharpen", nil, image.Pt(400, 300)},
+		{"flip_h", nil, image.Pt(400, 300)},
+		{"flip_v", nil, image.Pt(400, 300)},
+		{"sepia", map[string]any{"intensity": 0.5}, image.Pt(400, 300)},
+		{"brightness", map[string]any{"percentage": -20.0}, image.Pt(400, 300)},
+		{"contrast", map[string]any{"percentage": 250.0}, image.Pt(400, 300)},
+		{"resize", map[string]any{"width": 200.0}, image.Pt(200, 150)},
+		{"resize", map[string]any{"width": 50, "height": 50}, image.Pt(50, 50)},
+		{"crop", map[string]any{"x": 10, "y": 20, "width": 100, "height": 50}, image.Pt(100, 50)},
+		{"crop", map[string]any{"x": 350, "y": 0, "width": 100, "height": 50}, image.Pt(50, 50)},
+		{"crop", map[string]any{"x": 0, "y": 0, "width": 2 * MaxDimension, "height": 50}, image.Pt(400, 50)},
+		{"watermark", map[string]any{"text": "sample"}, image.Pt(400, 300)},
+		{"watermark", map[string]any{LogoParam: image.Image(fixture(40, 20)), "position": "center"}, image.Pt(400, 300)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.op, func(t *testing.T) {
+			out, err := Apply(fixture(400, 300), tt.op, tt.params)
+			if err != nil {
+				t.Fatalf("Apply(%s, %v): %v", tt.op, tt.params, err)
+			}
+			if got := out.Bounds().Size(); got != tt.want {
+				t.Errorf("Apply(%s, %v) size = %v, want %v", tt.op, tt.params, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		op     string
+		params map[string]any
+	}{
+		{"unknown op", "posterize", nil},
+		{"negative blur radius", "blur", map[string]any{"radius": -1.0}},
+		{"fractional width", "thumbnail", map[string]any{"width": 10.5}},
+		{"string width", "resize", map[string]any{"width": "100"}},
+		{"oversized thumbnail", "thumbnail", map[string]any{"width": MaxDimension + 1}},
+		{"oversized resize", "resize", map[string]any{"width": 100, "height": 1e9}},
+		{"resize without size", "resize", nil},
+		{"crop without size", "crop", map[string]any{"x": 0, "y": 0}},
+		{"crop outside image", "crop", map[string]any{"x": 500, "y": 0, "width": 10, "height": 10}},
+		{"sepia intensity above 1", "sepia", map[string]any{"intensity": 2.0}},
+		{"watermark without text or logo", "watermark", nil},
+		{"watermark bad position", "watermark", map[string]any{"text": "x", "position": "middle"}},
+		{"watermark bad opacity", "watermark", map[string]any{"text": "x", "opacity": 1.5}},
+		{"watermark text too long", "watermark", map[string]any{"text": strings.Repeat("x", MaxWatermarkText+1)}},
+		{"watermark size too large", "watermark", map[string]any{"text": "x", "size": MaxWatermarkSize + 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Apply(fixture(400, 300), tt.op, tt.params); err == nil {
+				t.Errorf("Apply(%s, %v) succeeded, want an error", tt.op, tt.params)
+			}
+		})
+	}
+}
+
+func TestResizeExtremeAspect(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		src    image.Point
+		params map[string]any
+		want   image.Point // zero when the resize must be refused
+	}{
+		{"tall sliver widened", image.Pt(1, MaxDimension), map[string]any{"width": MaxDimension}, image.Point{}},
+		{"wide sliver heightened", image.Pt(MaxDimension, 1), map[string]any{"height": MaxDimension}, image.Point{}},
+		{"tall sliver, both sides given", image.Pt(1, MaxDimension), map[string]any{"width": 64, "height": 64}, image.Pt(64, 64)},
+		{"tall sliver shrunk", image.Pt(2, 1000), map[string]any{"height": 500}, image.Pt(1, 500)},
+		{"derived side at the limit", image.Pt(10, 20), map[string]any{"width": MaxDimension / 2}, image.Pt(MaxDimension/2, MaxDimension)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Apply(fixture(tt.src.X, tt.src.Y), "resize", tt.params)
+			if tt.want == (image.Point{}) {
+				if err == nil {
+					t.Fatalf("resize to %v gave %v, want an error", tt.params, out.Bounds().Size())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := out.Bounds().Size(); got != tt.want {
+				t.Errorf("size = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		op     string
+		params map[string]any
+		ok     bool
+	}{
+		{"grayscale", nil, true},
+		{"thumbnail", map[string]any{"width": 300.0}, true},
+		{"thumbnail", map[string]any{"width": -5.0}, false},
+		{"resize", map[string]any{"height": MaxDimension + 1}, false},
+		{"resize", nil, false},
+		{"blur", map[string]any{"radius": 0.0}, false},
+		{"sharpen", map[string]any{"sigma": "soft"}, false},
+		{"sepia", map[string]any{"intensity": 0.5}, true},
+		{"brightness", map[string]any{"percentage": 250.0}, true},
+		// the rectangle is only checked against the image when it's cropped
+		{"crop", map[string]any{"x": 5000.0, "y": 0.0, "width": 10.0, "height": 10.0}, true},
+		{"crop", map[string]any{"x": 0.0, "y": 0.0, "width": 10.0}, false},
+		// the logo comes from the worker, so no text is fine here
+		{"watermark", nil, true},
+		{"watermark", map[string]any{"text": "x", "position": "middle"}, false},
+		{"watermark", map[string]any{"text": "x", "color": "teal-ish"}, false},
+		{"watermark", map[string]any{"text": "x", "size": MaxWatermarkSize + 1}, false},
+		{"posterize", nil, false},
+	} {
+		if err := Validate(tt.op, tt.params); (err == nil) != tt.ok {
+			t.Errorf("Validate(%s, %v) = %v, want ok %v", tt.op, tt.params, err, tt.ok)
+		}
+	}
+}
+
+func TestTextMarkClipsToImage(t *testing.T) {
+	bounds := image.Rect(0, 0, 60, 20)
+	opts, err := watermarkParams(map[string]any{"text": strings.Repeat("W", MaxWatermarkText), "size": MaxWatermarkSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mark, err := textMark(opts, bounds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mark.Bounds().Size(); got.X > bounds.Dx() || got.Y > bounds.Dy() {
+		t.Errorf("mark is %v, larger than the %v image", got, bounds.Size())
+	}
+}
+
+func TestSepiaIntensityZeroIsIdentity(t *testing.T) {
+	img := fixture(20, 10)
+	out, err := Apply(img, "sepia", map[string]any{"intensity": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range img.Pix {
+		if out.Pix[i] != img.Pix[i] {
+			t.Fatalf("pixel byte %d = %d, want %d", i, out.Pix[i], img.Pix[i])
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	const op = "test_identity"
+	if Has(op) {
+		t.Fatalf("%s registered before Register", op)
+	}
+	Register(op, func(img image.Image, _ map[string]any) (*image.NRGBA, error) {
+		return fixture(img.Bounds().Dx(), img.Bounds().Dy()), nil
+	})
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(handlers, op)
+		mu.Unlock()
+	})
+	out, err := Apply(fixture(8, 4), op, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.Bounds().Size(); got != image.Pt(8, 4) {
+		t.Errorf("size = %v, want 8x4", got)
+	}
+}
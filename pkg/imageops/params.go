@@ -0,0 +1,86 @@
+package imageops
+
+import (
+	"fmt"
+	"math"
+)
+
+// number returns v as a float64 when it is any Go integer or float.
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// floatParam reads an optional finite number param, or returns def.
+func floatParam(params map[string]any, name string, def float64) (float64, error) {
+	v, ok := params[name]
+	if !ok {
+		return def, nil
+	}
+	n, isNum := number(v)
+	if !isNum || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("%s must be a number, got %v", name, v)
+	}
+	return n, nil
+}
+
+// offsetParam reads an optional non-negative integer param.
+func offsetParam(params map[string]any, name string) (int, error) {
+	v, ok := params[name]
+	if !ok {
+		return 0, nil
+	}
+	n, isNum := number(v)
+	if !isNum || n < 0 || n != math.Trunc(n) {
+		return 0, fmt.Errorf("%s must be a non-negative integer, got %v", name, v)
+	}
+	return int(n), nil
+}
+
+// dimensionParam reads an optional pixel dimension. It returns 0 when the
+// field is absent and an error unless the value is an integer from 1 to
+// MaxDimension.
+func dimensionParam(params map[string]any, name string) (int, error) {
+	v, ok := params[name]
+	if !ok {
+		return 0, nil
+	}
+	n, isNum := number(v)
+	if !isNum || n <= 0 || n != math.Trunc(n) {
+		return 0, fmt.Errorf("%s must be a positive integer, got %v", name, v)
+	}
+	if n > MaxDimension {
+		return 0, fmt.Errorf("%s must be at most %d, got %v", name, MaxDimension, v)
+	}
+	return int(n), nil
+}
+
+// stringParam reads an optional string param, or returns def.
+func stringParam(params map[string]any, name, def string) (string, error) {
+	v, ok := params[name]
+	if !ok {
+		return def, nil
+	}
+	s, isStr := v.(string)
+	if !isStr {
+		return "", fmt.Errorf("%s must be a string, got %v", name, v)
+	}
+	return s, nil
+}
@@ -0,0 +1,207 @@
+package imageops
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"sync"
+	"unicode/utf8"
+
+	"example.com/image-factory/pkg/ops"
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// LogoParam is the watermark param carrying the logo, an image.Image the
+// caller supplies (it can't come from a request's JSON).
+const LogoParam = "logo"
+
+// Watermark text limits: longer text or larger sizes are rejected rather
+// than rendered.
+const (
+	MaxWatermarkText = 200 // runes
+	MaxWatermarkSize = 512 // pixels
+)
+
+// Watermark defaults, used when the task's params don't say otherwise.
+const (
+	defaultWatermarkPosition = "bottom-right"
+	defaultWatermarkOpacity  = 0.5
+	defaultWatermarkScale    = 0.2
+	defaultWatermarkMargin   = 10
+)
+
+// LoadWatermark reads the PNG logo used by watermark tasks that don't carry
+// their own text.
+func LoadWatermark(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return imaging.Decode(f)
+}
+
+// watermarkOptions are a watermark task's parsed params.
+type watermarkOptions struct {
+	position string
+	opacity  float64
+	margin   int
+	text     string
+	size     int // 0 sizes the text to the image
+	fill     color.NRGBA
+	scale    float64
+}
+
+// watermark overlays the task's text, or the LogoParam logo when it has no
+// text, onto img. Params: text, color and size (pixels) for text; scale
+// (logo width as a fraction of the image's) for the logo; position
+// (center, top-left, top-right, bottom-left, bottom-right), opacity (0-1)
+// and margin (pixels) for both.
+func watermark(img image.Image, params map[string]any) (*image.NRGBA, error) {
+	opts, err := watermarkParams(params)
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	var mark image.Image
+	switch {
+	case opts.text != "":
+		mark, err = textMark(opts, bounds)
+		if err != nil {
+			return nil, err
+		}
+	case params[LogoParam] != nil:
+		logo, ok := params[LogoParam].(image.Image)
+		if !ok {
+			return nil, fmt.Errorf("%s must be an image", LogoParam)
+		}
+		width, height, err := fitAspect(logo.Bounds().Size(), max(1, int(float64(bounds.Dx())*opts.scale)), 0)
+		if err != nil {
+			return nil, err
+		}
+		mark = imaging.Resize(logo, width, height, imaging.Lanczos)
+	default:
+		return nil, errors.New("watermark requires text or a configured logo")
+	}
+
+	pt, err := watermarkPoint(opts.position, bounds.Size(), mark.Bounds().Size(), opts.margin)
+	if err != nil {
+		return nil, err
+	}
+	return imaging.Overlay(img, mark, pt, opts.opacity), nil
+}
+
+// watermarkParams reads and checks every watermark param but the logo.
+func watermarkParams(params map[string]any) (watermarkOptions, error) {
+	opts := watermarkOptions{margin: defaultWatermarkMargin, fill: color.NRGBA{255, 255, 255, 255}}
+	var err error
+	if opts.position, err = stringParam(params, "position", defaultWatermarkPosition); err != nil {
+		return opts, err
+	}
+	if _, err := watermarkPoint(opts.position, image.Point{}, image.Point{}, 0); err != nil {
+		return opts, err
+	}
+	if opts.opacity, err = floatParam(params, "opacity", defaultWatermarkOpacity); err != nil {
+		return opts, err
+	}
+	if opts.opacity < 0 || opts.opacity > 1 {
+		return opts, fmt.Errorf("opacity must be between 0 and 1, got %v", opts.opacity)
+	}
+	if _, ok := params["margin"]; ok {
+		if opts.margin, err = offsetParam(params, "margin"); err != nil {
+			return opts, err
+		}
+	}
+	if opts.text, err = stringParam(params, "text", ""); err != nil {
+		return opts, err
+	}
+	if n := utf8.RuneCountInString(opts.text); n > MaxWatermarkText {
+		return opts, fmt.Errorf("text must be at most %d characters, got %d", MaxWatermarkText, n)
+	}
+	if opts.size, err = dimensionParam(params, "size"); err != nil {
+		return opts, err
+	}
+	if opts.size > MaxWatermarkSize {
+		return opts, fmt.Errorf("size must be at most %d, got %d", MaxWatermarkSize, opts.size)
+	}
+	if c, err := stringParam(params, "color", ""); err != nil {
+		return opts, err
+	} else if c != "" {
+		var ok bool
+		if opts.fill, ok = ops.ParseColor(c); !ok {
+			return opts, fmt.Errorf("invalid color %q", c)
+		}
+	}
+	if opts.scale, err = floatParam(params, "scale", defaultWatermarkScale); err != nil {
+		return opts, err
+	}
+	if opts.scale <= 0 || opts.scale > 1 {
+		return opts, fmt.Errorf("scale must be in (0, 1], got %v", opts.scale)
+	}
+	return opts, nil
+}
+
+// watermarkPoint returns where a mark of size m goes inside an image of size
+// b, margin pixels in from the named corner.
+func watermarkPoint(position string, b, m image.Point, margin int) (image.Point, error) {
+	switch position {
+	case "center":
+		return image.Pt((b.X-m.X)/2, (b.Y-m.Y)/2), nil
+	case "top-left":
+		return image.Pt(margin, margin), nil
+	case "top-right":
+		return image.Pt(b.X-m.X-margin, margin), nil
+	case "bottom-left":
+		return image.Pt(margin, b.Y-m.Y-margin), nil
+	case "bottom-right":
+		return image.Pt(b.X-m.X-margin, b.Y-m.Y-margin), nil
+	}
+	return image.Point{}, fmt.Errorf("unknown position %q", position)
+}
+
+var (
+	watermarkFontOnce sync.Once
+	watermarkFont     *opentype.Font
+	watermarkFontErr  error
+)
+
+// textMark renders opts.text on a transparent background, sized to a
+// twentieth of the image height unless opts set a size. The mark is
+// clipped to the image's size, which is all of it that can show.
+func textMark(opts watermarkOptions, bounds image.Rectangle) (image.Image, error) {
+	size := opts.size
+	if size == 0 {
+		size = max(12, bounds.Dy()/20)
+	}
+
+	watermarkFontOnce.Do(func() {
+		watermarkFont, watermarkFontErr = opentype.Parse(goregular.TTF)
+	})
+	if watermarkFontErr != nil {
+		return nil, watermarkFontErr
+	}
+	face, err := opentype.NewFace(watermarkFont, &opentype.FaceOptions{Size: float64(size), DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	m := face.Metrics()
+	width := min(font.MeasureString(face, opts.text).Ceil(), bounds.Dx())
+	height := min((m.Ascent + m.Descent).Ceil(), bounds.Dy())
+	dst := image.NewNRGBA(image.Rect(0, 0, max(1, width), max(1, height)))
+	d := &font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(opts.fill),
+		Face: face,
+		Dot:  fixed.Point26_6{Y: m.Ascent},
+	}
+	d.DrawString(opts.text)
+	return dst, nil
+}
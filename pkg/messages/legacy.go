@@ -0,0 +1,46 @@
+package messages
+
+import (
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Peers from before the typed messages send a structpb.Struct keyed by the
+// same snake_case field names. Receivers go through the As* helpers so
+// either form is accepted while a deploy rolls; drop them, and the Struct
+// registration, once no old peers remain.
+
+// AsUploadEvent returns msg as an UploadEvent, converting a legacy Struct.
+func AsUploadEvent(msg any) (*UploadEvent, bool) { return as[UploadEvent](msg) }
+
+// AsTransformTask returns msg as a TransformTask, converting a legacy Struct.
+func AsTransformTask(msg any) (*TransformTask, bool) { return as[TransformTask](msg) }
+
+// AsTransformResult returns msg as a TransformResult, converting a legacy
+// Struct.
+func AsTransformResult(msg any) (*TransformResult, bool) { return as[TransformResult](msg) }
+
+// AsSystemEvent returns msg as a SystemEvent, converting a legacy Struct.
+func AsSystemEvent(msg any) (*SystemEvent, bool) { return as[SystemEvent](msg) }
+
+func as[T any, PT interface {
+	*T
+	proto.Message
+}](msg any) (PT, bool) {
+	switch m := msg.(type) {
+	case PT:
+		return m, true
+	case *structpb.Struct:
+		b, err := protojson.Marshal(m)
+		if err != nil {
+			return nil, false
+		}
+		out := PT(new(T))
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(b, out); err != nil {
+			return nil, false
+		}
+		return out, true
+	}
+	return nil, false
+}
@@ -1,12 +1,28 @@
 package messages
 
+//go:generate protoc --go_out=. --go_opt=paths=source_relative messages.proto
+
 import (
 	"github.com/lytics/grid/v3"
 	"google.golang.org/protobuf/types/known/structpb"
 )
 
-// Register common protobuf Struct so all messages can be generic JSON-like payloads.
+// Register the typed messages exchanged between the API and actors, plus the
+// generic protobuf Struct that older peers still send (see legacy.go).
 func init() {
 	// IMPORTANT: register value type, not pointer, per grid codec expectations
+	_ = grid.Register(UploadEvent{})
+	_ = grid.Register(TransformTask{})
+	_ = grid.Register(TransformResult{})
+	_ = grid.Register(SystemEvent{})
+	_ = grid.Register(DeadLetter{})
 	_ = grid.Register(structpb.Struct{})
 }
+
+// StopEvent is the SystemEvent that, sent to a worker's mailbox, makes it
+// deregister and exit. DrainEvent does the same, but first hands the tasks
+// still queued in its mailbox to the op's other workers, so none are lost.
+const (
+	StopEvent  = "stop"
+	DrainEvent = "drain"
+)
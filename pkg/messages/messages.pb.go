@@ -0,0 +1,825 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.7
+// 	protoc        (unknown)
+// source: messages.proto
+
+package messages
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// UploadEvent is sent by the API to the coordinator's uploads mailbox.
+type UploadEvent struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	ImageId string                 `protobuf:"bytes,1,opt,name=image_id,json=imageId,proto3" json:"image_id,omitempty"`
+	// Path of the original on disk.
+	Path string `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+	// Output format override; empty means each op's registered default.
+	Format string `protobuf:"bytes,3,opt,name=format,proto3" json:"format,omitempty"`
+	// Per-op parameters keyed by op name, e.g. {"resize": {"width": 800}}.
+	Params map[string]*structpb.Struct `protobuf:"bytes,4,rep,name=params,proto3" json:"params,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Ops to run; empty means the coordinator's defaults.
+	Ops []string `protobuf:"bytes,5,rep,name=ops,proto3" json:"ops,omitempty"`
+	// Encoder quality 1-100; 0 means the worker default.
+	Quality int32 `protobuf:"varint,6,opt,name=quality,proto3" json:"quality,omitempty"`
+	// Metadata embedded (as XMP) in every variant, e.g. {"copyright": "..."}.
+	Metadata map[string]string `protobuf:"bytes,7,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Color ("#rrggbb") transparent areas are flattened onto for formats
+	// without alpha; empty means the worker default.
+	Background string `protobuf:"bytes,8,opt,name=background,proto3" json:"background,omitempty"`
+	// Encoder effort 1 (fastest) to 9 (smallest output); 0 means each op's
+	// registered default.
+	Effort int32 `protobuf:"varint,9,opt,name=effort,proto3" json:"effort,omitempty"`
+	// Rotate/flip per the original's EXIF orientation before each op; unset
+	// means true.
+	AutoOrient *bool `protobuf:"varint,10,opt,name=auto_orient,json=autoOrient,proto3,oneof" json:"auto_orient,omitempty"`
+	// Hex W3C trace and span ids of the upload's span, so the coordinator's
+	// spans join its trace. Empty when tracing is off.
+	TraceId string `protobuf:"bytes,11,opt,name=trace_id,json=traceId,proto3" json:"trace_id,omitempty"`
+	SpanId  string `protobuf:"bytes,12,opt,name=span_id,json=spanId,proto3" json:"span_id,omitempty"`
+	// Identifies one send of the event: a redelivery or a timed-out send
+	// tried again repeats it, so the coordinator can drop the copy, while
+	// reprocess and dead-letter retries mint a new one. Empty skips the
+	// check.
+	EventId       string `protobuf:"bytes,13,opt,name=event_id,json=eventId,proto3" json:"event_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadEvent) Reset() {
+	*x = UploadEvent{}
+	mi := &file_messages_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadEvent) ProtoMessage() {}
+
+func (x *UploadEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_messages_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadEvent.ProtoReflect.Descriptor instead.
+func (*UploadEvent) Descriptor() ([]byte, []int) {
+	return file_messages_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *UploadEvent) GetImageId() string {
+	if x != nil {
+		return x.ImageId
+	}
+	return ""
+}
+
+func (x *UploadEvent) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *UploadEvent) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *UploadEvent) GetParams() map[string]*structpb.Struct {
+	if x != nil {
+		return x.Params
+	}
+	return nil
+}
+
+func (x *UploadEvent) GetOps() []string {
+	if x != nil {
+		return x.Ops
+	}
+	return nil
+}
+
+func (x *UploadEvent) GetQuality() int32 {
+	if x != nil {
+		return x.Quality
+	}
+	return 0
+}
+
+func (x *UploadEvent) GetMetadata() map[string]string {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+func (x *UploadEvent) GetBackground() string {
+	if x != nil {
+		return x.Background
+	}
+	return ""
+}
+
+func (x *UploadEvent) GetEffort() int32 {
+	if x != nil {
+		return x.Effort
+	}
+	return 0
+}
+
+func (x *UploadEvent) GetAutoOrient() bool {
+	if x != nil && x.AutoOrient != nil {
+		return *x.AutoOrient
+	}
+	return false
+}
+
+func (x *UploadEvent) GetTraceId() string {
+	if x != nil {
+		return x.TraceId
+	}
+	return ""
+}
+
+func (x *UploadEvent) GetSpanId() string {
+	if x != nil {
+		return x.SpanId
+	}
+	return ""
+}
+
+func (x *UploadEvent) GetEventId() string {
+	if x != nil {
+		return x.EventId
+	}
+	return ""
+}
+
+// TransformTask is dispatched by the coordinator to one op's workers.
+type TransformTask struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	ImageId string                 `protobuf:"bytes,1,opt,name=image_id,json=imageId,proto3" json:"image_id,omitempty"`
+	Op      string                 `protobuf:"bytes,2,opt,name=op,proto3" json:"op,omitempty"`
+	Path    string                 `protobuf:"bytes,3,opt,name=path,proto3" json:"path,omitempty"`
+	Format  string                 `protobuf:"bytes,4,opt,name=format,proto3" json:"format,omitempty"`
+	Params  *structpb.Struct       `protobuf:"bytes,5,opt,name=params,proto3" json:"params,omitempty"`
+	// Inline copy of the original when it fits the coordinator's inline
+	// threshold; otherwise workers read path, or fetch image_id from the store.
+	Original []byte `protobuf:"bytes,6,opt,name=original,proto3" json:"original,omitempty"`
+	// 1-based attempt number and the coordinator's attempt budget; a worker
+	// only reports a failure to the API on the last attempt.
+	Attempt     int32 `protobuf:"varint,7,opt,name=attempt,proto3" json:"attempt,omitempty"`
+	MaxAttempts int32 `protobuf:"varint,8,opt,name=max_attempts,json=maxAttempts,proto3" json:"max_attempts,omitempty"`
+	// Encoder quality 1-100; 0 means the worker default.
+	Quality int32 `protobuf:"varint,9,opt,name=quality,proto3" json:"quality,omitempty"`
+	// Per-upload metadata to embed; merged over the worker's static fields.
+	Metadata map[string]string `protobuf:"bytes,10,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Background for flattening transparency; empty means the worker default.
+	Background string `protobuf:"bytes,11,opt,name=background,proto3" json:"background,omitempty"`
+	// Encoder effort 1-9; 0 means the op's registered default.
+	Effort int32 `protobuf:"varint,12,opt,name=effort,proto3" json:"effort,omitempty"`
+	// Apply the original's EXIF orientation first; unset means true.
+	AutoOrient *bool `protobuf:"varint,13,opt,name=auto_orient,json=autoOrient,proto3,oneof" json:"auto_orient,omitempty"`
+	// Name the output is stored under, e.g. "thumbnail_w300" for an
+	// on-the-fly render; empty means op.
+	Variant string `protobuf:"bytes,14,opt,name=variant,proto3" json:"variant,omitempty"`
+	// Hex trace and span ids of the coordinator's dispatch span.
+	TraceId       string `protobuf:"bytes,15,opt,name=trace_id,json=traceId,proto3" json:"trace_id,omitempty"`
+	SpanId        string `protobuf:"bytes,16,opt,name=span_id,json=spanId,proto3" json:"span_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TransformTask) Reset() {
+	*x = TransformTask{}
+	mi := &file_messages_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransformTask) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransformTask) ProtoMessage() {}
+
+func (x *TransformTask) ProtoReflect() protoreflect.Message {
+	mi := &file_messages_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransformTask.ProtoReflect.Descriptor instead.
+func (*TransformTask) Descriptor() ([]byte, []int) {
+	return file_messages_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *TransformTask) GetImageId() string {
+	if x != nil {
+		return x.ImageId
+	}
+	return ""
+}
+
+func (x *TransformTask) GetOp() string {
+	if x != nil {
+		return x.Op
+	}
+	return ""
+}
+
+func (x *TransformTask) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *TransformTask) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *TransformTask) GetParams() *structpb.Struct {
+	if x != nil {
+		return x.Params
+	}
+	return nil
+}
+
+func (x *TransformTask) GetOriginal() []byte {
+	if x != nil {
+		return x.Original
+	}
+	return nil
+}
+
+func (x *TransformTask) GetAttempt() int32 {
+	if x != nil {
+		return x.Attempt
+	}
+	return 0
+}
+
+func (x *TransformTask) GetMaxAttempts() int32 {
+	if x != nil {
+		return x.MaxAttempts
+	}
+	return 0
+}
+
+func (x *TransformTask) GetQuality() int32 {
+	if x != nil {
+		return x.Quality
+	}
+	return 0
+}
+
+func (x *TransformTask) GetMetadata() map[string]string {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+func (x *TransformTask) GetBackground() string {
+	if x != nil {
+		return x.Background
+	}
+	return ""
+}
+
+func (x *TransformTask) GetEffort() int32 {
+	if x != nil {
+		return x.Effort
+	}
+	return 0
+}
+
+func (x *TransformTask) GetAutoOrient() bool {
+	if x != nil && x.AutoOrient != nil {
+		return *x.AutoOrient
+	}
+	return false
+}
+
+func (x *TransformTask) GetVariant() string {
+	if x != nil {
+		return x.Variant
+	}
+	return ""
+}
+
+func (x *TransformTask) GetTraceId() string {
+	if x != nil {
+		return x.TraceId
+	}
+	return ""
+}
+
+func (x *TransformTask) GetSpanId() string {
+	if x != nil {
+		return x.SpanId
+	}
+	return ""
+}
+
+// TransformResult is the worker's reply, also pushed to transform-updates.
+type TransformResult struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	ImageId string                 `protobuf:"bytes,1,opt,name=image_id,json=imageId,proto3" json:"image_id,omitempty"`
+	Op      string                 `protobuf:"bytes,2,opt,name=op,proto3" json:"op,omitempty"`
+	Success bool                   `protobuf:"varint,3,opt,name=success,proto3" json:"success,omitempty"`
+	// Path of the produced variant.
+	Path string `protobuf:"bytes,4,opt,name=path,proto3" json:"path,omitempty"`
+	// Failure reason when success is false.
+	Error string `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+	// Wall time the worker spent in the transform itself.
+	DurationMs int64 `protobuf:"varint,6,opt,name=duration_ms,json=durationMs,proto3" json:"duration_ms,omitempty"`
+	// Set on a failure that used up every retry.
+	Exhausted bool `protobuf:"varint,7,opt,name=exhausted,proto3" json:"exhausted,omitempty"`
+	// Attempts made, when exhausted.
+	Attempts int32 `protobuf:"varint,8,opt,name=attempts,proto3" json:"attempts,omitempty"`
+	// Dimensions of the produced variant.
+	Width  int32 `protobuf:"varint,9,opt,name=width,proto3" json:"width,omitempty"`
+	Height int32 `protobuf:"varint,10,opt,name=height,proto3" json:"height,omitempty"`
+	// Failure category for metrics, e.g. "too_large"; empty for errors that
+	// weren't classified.
+	Reason string `protobuf:"bytes,11,opt,name=reason,proto3" json:"reason,omitempty"`
+	// The task's variant name, when it had one.
+	Variant string `protobuf:"bytes,12,opt,name=variant,proto3" json:"variant,omitempty"`
+	// Hex trace and span ids of the worker's transform span.
+	TraceId       string `protobuf:"bytes,13,opt,name=trace_id,json=traceId,proto3" json:"trace_id,omitempty"`
+	SpanId        string `protobuf:"bytes,14,opt,name=span_id,json=spanId,proto3" json:"span_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TransformResult) Reset() {
+	*x = TransformResult{}
+	mi := &file_messages_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransformResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransformResult) ProtoMessage() {}
+
+func (x *TransformResult) ProtoReflect() protoreflect.Message {
+	mi := &file_messages_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransformResult.ProtoReflect.Descriptor instead.
+func (*TransformResult) Descriptor() ([]byte, []int) {
+	return file_messages_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *TransformResult) GetImageId() string {
+	if x != nil {
+		return x.ImageId
+	}
+	return ""
+}
+
+func (x *TransformResult) GetOp() string {
+	if x != nil {
+		return x.Op
+	}
+	return ""
+}
+
+func (x *TransformResult) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *TransformResult) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *TransformResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *TransformResult) GetDurationMs() int64 {
+	if x != nil {
+		return x.DurationMs
+	}
+	return 0
+}
+
+func (x *TransformResult) GetExhausted() bool {
+	if x != nil {
+		return x.Exhausted
+	}
+	return false
+}
+
+func (x *TransformResult) GetAttempts() int32 {
+	if x != nil {
+		return x.Attempts
+	}
+	return 0
+}
+
+func (x *TransformResult) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *TransformResult) GetHeight() int32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *TransformResult) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *TransformResult) GetVariant() string {
+	if x != nil {
+		return x.Variant
+	}
+	return ""
+}
+
+func (x *TransformResult) GetTraceId() string {
+	if x != nil {
+		return x.TraceId
+	}
+	return ""
+}
+
+func (x *TransformResult) GetSpanId() string {
+	if x != nil {
+		return x.SpanId
+	}
+	return ""
+}
+
+// SystemEvent reports worker lifecycle changes and coordinator backlog on
+// system-events.
+type SystemEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// "worker_start", "worker_stop" or "backlog".
+	Event   string `protobuf:"bytes,1,opt,name=event,proto3" json:"event,omitempty"`
+	Name    string `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Op      string `protobuf:"bytes,3,opt,name=op,proto3" json:"op,omitempty"`
+	Mailbox string `protobuf:"bytes,4,opt,name=mailbox,proto3" json:"mailbox,omitempty"`
+	// For "backlog": tasks the coordinator has dispatched but not yet seen
+	// resolved, by op. Ops with nothing pending are omitted.
+	Pending       map[string]int32 `protobuf:"bytes,5,rep,name=pending,proto3" json:"pending,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SystemEvent) Reset() {
+	*x = SystemEvent{}
+	mi := &file_messages_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SystemEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SystemEvent) ProtoMessage() {}
+
+func (x *SystemEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_messages_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SystemEvent.ProtoReflect.Descriptor instead.
+func (*SystemEvent) Descriptor() ([]byte, []int) {
+	return file_messages_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SystemEvent) GetEvent() string {
+	if x != nil {
+		return x.Event
+	}
+	return ""
+}
+
+func (x *SystemEvent) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *SystemEvent) GetOp() string {
+	if x != nil {
+		return x.Op
+	}
+	return ""
+}
+
+func (x *SystemEvent) GetMailbox() string {
+	if x != nil {
+		return x.Mailbox
+	}
+	return ""
+}
+
+func (x *SystemEvent) GetPending() map[string]int32 {
+	if x != nil {
+		return x.Pending
+	}
+	return nil
+}
+
+// DeadLetter carries a task that exhausted its retries to the dead-letter
+// mailbox.
+type DeadLetter struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The task as last dispatched, without its inline original.
+	Task *TransformTask `protobuf:"bytes,1,opt,name=task,proto3" json:"task,omitempty"`
+	// Last failure reason.
+	Error          string `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	Attempts       int32  `protobuf:"varint,3,opt,name=attempts,proto3" json:"attempts,omitempty"`
+	FailedAtUnixMs int64  `protobuf:"varint,4,opt,name=failed_at_unix_ms,json=failedAtUnixMs,proto3" json:"failed_at_unix_ms,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *DeadLetter) Reset() {
+	*x = DeadLetter{}
+	mi := &file_messages_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeadLetter) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeadLetter) ProtoMessage() {}
+
+func (x *DeadLetter) ProtoReflect() protoreflect.Message {
+	mi := &file_messages_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeadLetter.ProtoReflect.Descriptor instead.
+func (*DeadLetter) Descriptor() ([]byte, []int) {
+	return file_messages_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *DeadLetter) GetTask() *TransformTask {
+	if x != nil {
+		return x.Task
+	}
+	return nil
+}
+
+func (x *DeadLetter) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *DeadLetter) GetAttempts() int32 {
+	if x != nil {
+		return x.Attempts
+	}
+	return 0
+}
+
+func (x *DeadLetter) GetFailedAtUnixMs() int64 {
+	if x != nil {
+		return x.FailedAtUnixMs
+	}
+	return 0
+}
+
+var File_messages_proto protoreflect.FileDescriptor
+
+const file_messages_proto_rawDesc = "" +
+	"\n" +
+	"\x0emessages.proto\x12\x15imagefactory.messages\x1a\x1cgoogle/protobuf/struct.proto\"\xe4\x04\n" +
+	"\vUploadEvent\x12\x19\n" +
+	"\bimage_id\x18\x01 \x01(\tR\aimageId\x12\x12\n" +
+	"\x04path\x18\x02 \x01(\tR\x04path\x12\x16\n" +
+	"\x06format\x18\x03 \x01(\tR\x06format\x12F\n" +
+	"\x06params\x18\x04 \x03(\v2..imagefactory.messages.UploadEvent.ParamsEntryR\x06params\x12\x10\n" +
+	"\x03ops\x18\x05 \x03(\tR\x03ops\x12\x18\n" +
+	"\aquality\x18\x06 \x01(\x05R\aquality\x12L\n" +
+	"\bmetadata\x18\a \x03(\v20.imagefactory.messages.UploadEvent.MetadataEntryR\bmetadata\x12\x1e\n" +
+	"\n" +
+	"background\x18\b \x01(\tR\n" +
+	"background\x12\x16\n" +
+	"\x06effort\x18\t \x01(\x05R\x06effort\x12$\n" +
+	"\vauto_orient\x18\n" +
+	" \x01(\bH\x00R\n" +
+	"autoOrient\x88\x01\x01\x12\x19\n" +
+	"\btrace_id\x18\v \x01(\tR\atraceId\x12\x17\n" +
+	"\aspan_id\x18\f \x01(\tR\x06spanId\x12\x19\n" +
+	"\bevent_id\x18\r \x01(\tR\aeventId\x1aR\n" +
+	"\vParamsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12-\n" +
+	"\x05value\x18\x02 \x01(\v2\x17.google.protobuf.StructR\x05value:\x028\x01\x1a;\n" +
+	"\rMetadataEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01B\x0e\n" +
+	"\f_auto_orient\"\xd3\x04\n" +
+	"\rTransformTask\x12\x19\n" +
+	"\bimage_id\x18\x01 \x01(\tR\aimageId\x12\x0e\n" +
+	"\x02op\x18\x02 \x01(\tR\x02op\x12\x12\n" +
+	"\x04path\x18\x03 \x01(\tR\x04path\x12\x16\n" +
+	"\x06format\x18\x04 \x01(\tR\x06format\x12/\n" +
+	"\x06params\x18\x05 \x01(\v2\x17.google.protobuf.StructR\x06params\x12\x1a\n" +
+	"\boriginal\x18\x06 \x01(\fR\boriginal\x12\x18\n" +
+	"\aattempt\x18\a \x01(\x05R\aattempt\x12!\n" +
+	"\fmax_attempts\x18\b \x01(\x05R\vmaxAttempts\x12\x18\n" +
+	"\aquality\x18\t \x01(\x05R\aquality\x12N\n" +
+	"\bmetadata\x18\n" +
+	" \x03(\v22.imagefactory.messages.TransformTask.MetadataEntryR\bmetadata\x12\x1e\n" +
+	"\n" +
+	"background\x18\v \x01(\tR\n" +
+	"background\x12\x16\n" +
+	"\x06effort\x18\f \x01(\x05R\x06effort\x12$\n" +
+	"\vauto_orient\x18\r \x01(\bH\x00R\n" +
+	"autoOrient\x88\x01\x01\x12\x18\n" +
+	"\avariant\x18\x0e \x01(\tR\avariant\x12\x19\n" +
+	"\btrace_id\x18\x0f \x01(\tR\atraceId\x12\x17\n" +
+	"\aspan_id\x18\x10 \x01(\tR\x06spanId\x1a;\n" +
+	"\rMetadataEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01B\x0e\n" +
+	"\f_auto_orient\"\xef\x02\n" +
+	"\x0fTransformResult\x12\x19\n" +
+	"\bimage_id\x18\x01 \x01(\tR\aimageId\x12\x0e\n" +
+	"\x02op\x18\x02 \x01(\tR\x02op\x12\x18\n" +
+	"\asuccess\x18\x03 \x01(\bR\asuccess\x12\x12\n" +
+	"\x04path\x18\x04 \x01(\tR\x04path\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\x12\x1f\n" +
+	"\vduration_ms\x18\x06 \x01(\x03R\n" +
+	"durationMs\x12\x1c\n" +
+	"\texhausted\x18\a \x01(\bR\texhausted\x12\x1a\n" +
+	"\battempts\x18\b \x01(\x05R\battempts\x12\x14\n" +
+	"\x05width\x18\t \x01(\x05R\x05width\x12\x16\n" +
+	"\x06height\x18\n" +
+	" \x01(\x05R\x06height\x12\x16\n" +
+	"\x06reason\x18\v \x01(\tR\x06reason\x12\x18\n" +
+	"\avariant\x18\f \x01(\tR\avariant\x12\x19\n" +
+	"\btrace_id\x18\r \x01(\tR\atraceId\x12\x17\n" +
+	"\aspan_id\x18\x0e \x01(\tR\x06spanId\"\xe8\x01\n" +
+	"\vSystemEvent\x12\x14\n" +
+	"\x05event\x18\x01 \x01(\tR\x05event\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x0e\n" +
+	"\x02op\x18\x03 \x01(\tR\x02op\x12\x18\n" +
+	"\amailbox\x18\x04 \x01(\tR\amailbox\x12I\n" +
+	"\apending\x18\x05 \x03(\v2/.imagefactory.messages.SystemEvent.PendingEntryR\apending\x1a:\n" +
+	"\fPendingEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x05R\x05value:\x028\x01\"\xa3\x01\n" +
+	"\n" +
+	"DeadLetter\x128\n" +
+	"\x04task\x18\x01 \x01(\v2$.imagefactory.messages.TransformTaskR\x04task\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\x12\x1a\n" +
+	"\battempts\x18\x03 \x01(\x05R\battempts\x12)\n" +
+	"\x11failed_at_unix_ms\x18\x04 \x01(\x03R\x0efailedAtUnixMsB(Z&example.com/image-factory/pkg/messagesb\x06proto3"
+
+var (
+	file_messages_proto_rawDescOnce sync.Once
+	file_messages_proto_rawDescData []byte
+)
+
+func file_messages_proto_rawDescGZIP() []byte {
+	file_messages_proto_rawDescOnce.Do(func() {
+		file_messages_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_messages_proto_rawDesc), len(file_messages_proto_rawDesc)))
+	})
+	return file_messages_proto_rawDescData
+}
+
+var file_messages_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_messages_proto_goTypes = []any{
+	(*UploadEvent)(nil),     // 0: imagefactory.messages.UploadEvent
+	(*TransformTask)(nil),   // 1: imagefactory.messages.TransformTask
+	(*TransformResult)(nil), // 2: imagefactory.messages.TransformResult
+	(*SystemEvent)(nil),     // 3: imagefactory.messages.SystemEvent
+	(*DeadLetter)(nil),      // 4: imagefactory.messages.DeadLetter
+	nil,                     // 5: imagefactory.messages.UploadEvent.ParamsEntry
+	nil,                     // 6: imagefactory.messages.UploadEvent.MetadataEntry
+	nil,                     // 7: imagefactory.messages.TransformTask.MetadataEntry
+	nil,                     // 8: imagefactory.messages.SystemEvent.PendingEntry
+	(*structpb.Struct)(nil), // 9: google.protobuf.Struct
+}
+var file_messages_proto_depIdxs = []int32{
+	5, // 0: imagefactory.messages.UploadEvent.params:type_name -> imagefactory.messages.UploadEvent.ParamsEntry
+	6, // 1: imagefactory.messages.UploadEvent.metadata:type_name -> imagefactory.messages.UploadEvent.MetadataEntry
+	9, // 2: imagefactory.messages.TransformTask.params:type_name -> google.protobuf.Struct
+	7, // 3: imagefactory.messages.TransformTask.metadata:type_name -> imagefactory.messages.TransformTask.MetadataEntry
+	8, // 4: imagefactory.messages.SystemEvent.pending:type_name -> imagefactory.messages.SystemEvent.PendingEntry
+	1, // 5: imagefactory.messages.DeadLetter.task:type_name -> imagefactory.messages.TransformTask
+	9, // 6: imagefactory.messages.UploadEvent.ParamsEntry.value:type_name -> google.protobuf.Struct
+	7, // [7:7] is the sub-list for method output_type
+	7, // [7:7] is the sub-list for method input_type
+	7, // [7:7] is the sub-list for extension type_name
+	7, // [7:7] is the sub-list for extension extendee
+	0, // [0:7] is the sub-list for field type_name
+}
+
+func init() { file_messages_proto_init() }
+func file_messages_proto_init() {
+	if File_messages_proto != nil {
+		return
+	}
+	file_messages_proto_msgTypes[0].OneofWrappers = []any{}
+	file_messages_proto_msgTypes[1].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_messages_proto_rawDesc), len(file_messages_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_messages_proto_goTypes,
+		DependencyIndexes: file_messages_proto_depIdxs,
+		MessageInfos:      file_messages_proto_msgTypes,
+	}.Build()
+	File_messages_proto = out.File
+	file_messages_proto_goTypes = nil
+	file_messages_proto_depIdxs = nil
+}
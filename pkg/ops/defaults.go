@@ -0,0 +1,81 @@
+package ops
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"google.golang.org/protobuf/types/known/structpb"
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults holds default parameters per op, applied to a task for each key
+// its upload doesn't set.
+type Defaults map[string]map[string]any
+
+// defaultsFile is the layout of a defaults file:
+//
+//	ops:
+//	  thumbnail: {width: 300, height: 300}
+//	  blur: {radius: 5}
+//
+// JSON, being YAML, works too.
+type defaultsFile struct {
+	Ops map[string]map[string]any `yaml:"ops"`
+}
+
+// LoadDefaults reads op defaults from the YAML or JSON file at path. Ops
+// the registry doesn't know, unknown top-level keys, values a task's params
+// can't carry, and params validate rejects are errors, so a typo or a bad
+// value fails at startup rather than being ignored or failing every task.
+// validate is the ops' own param parsing (imageops.Validate, which this
+// package can't import); nil skips that check.
+func LoadDefaults(path string, validate func(op string, params map[string]any) error) (Defaults, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f defaultsFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for op, params := range f.Ops {
+		if _, ok := Lookup(op); !ok {
+			return nil, fmt.Errorf("%s: unknown op %q", path, op)
+		}
+		if _, err := structpb.NewStruct(params); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, op, err)
+		}
+		if validate != nil {
+			if err := validate(op, params); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", path, op, err)
+			}
+		}
+	}
+	return Defaults(f.Ops), nil
+}
+
+// Merge returns op's defaults overlaid with params, or params unchanged
+// when op has none.
+func (d Defaults) Merge(op string, params *structpb.Struct) *structpb.Struct {
+	def := d[op]
+	if len(def) == 0 {
+		return params
+	}
+	m := make(map[string]any, len(def)+len(params.GetFields()))
+	for k, v := range def {
+		m[k] = v
+	}
+	for k, v := range params.AsMap() {
+		m[k] = v
+	}
+	s, err := structpb.NewStruct(m)
+	if err != nil {
+		return params
+	}
+	return s
+}
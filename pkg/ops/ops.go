@@ -0,0 +1,278 @@
+package ops
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image/color"
+	"strings"
+)
+
+// Spec describes an image operation and the defaults applied when a task
+// doesn't say otherwise.
+type Spec struct {
+	Name          string `json:"name"`
+	DefaultFormat string `json:"default_format"`
+	// CacheControl is sent with the op's variants; empty means
+	// DefaultCacheControl.
+	CacheControl string `json:"cache_control"`
+	// Responsive ops produce scaled copies of the original, so their
+	// variants can be listed together in an <img srcset>.
+	Responsive bool `json:"responsive"`
+	// Effort is the encoder effort used when a task doesn't set one; 0
+	// means DefaultEffort. Only PNG output uses it.
+	Effort int `json:"effort,omitempty"`
+	// MaxArea is the largest input, in pixels, the op accepts; 0 means no
+	// limit. Expensive ops set it so a huge original can't pin a worker.
+	MaxArea int64 `json:"max_area,omitempty"`
+	// Default ops run for uploads that don't select their own, and
+	// Parameterized ones join them when an upload supplies their params.
+	Default       bool `json:"default,omitempty"`
+	Parameterized bool `json:"parameterized,omitempty"`
+	// Worker is the grid actor type that runs the op's workers, registered
+	// at startup and started by /admin/scale.
+	Worker string `json:"worker"`
+}
+
+// Encoder effort trades encode time for output size: MinEffort is fastest,
+// MaxEffort produces the smallest files. It sets the zlib level of
+// EffortFormat output; the JPEG and WebP encoders have no such knob, so
+// other formats ignore it.
+const (
+	MinEffort     = 1
+	MaxEffort     = 9
+	DefaultEffort = 5
+	EffortFormat  = "png"
+)
+
+// Cache policies applied when serving images.
+const (
+	DefaultCacheControl   = "public, max-age=86400"
+	ImmutableCacheControl = "public, immutable, max-age=31536000"
+	OriginalCacheControl  = "private, max-age=3600"
+)
+
+// registry lists every op the factory knows about.
+var registry = []Spec{
+	// thumbnails are small and cached forever, so squeeze them harder
+	// when they come out as PNG
+	{Name: "thumbnail", DefaultFormat: "jpeg", CacheControl: ImmutableCacheControl, Responsive: true, Effort: MaxEffort, Default: true, Worker: "worker-thumb"},
+	{Name: "grayscale", DefaultFormat: "jpeg", CacheControl: DefaultCacheControl, Default: true, Worker: "worker-gray"},
+	{Name: "blur", DefaultFormat: "jpeg", CacheControl: DefaultCacheControl, MaxArea: 24_000_000, Default: true, Worker: "worker-blur"},
+	{Name: "rotate90", DefaultFormat: "jpeg", CacheControl: DefaultCacheControl, Default: true, Worker: "worker-rot"},
+	// resize targets vary per upload and may be regenerated with new dimensions
+	{Name: "resize", DefaultFormat: "jpeg", CacheControl: "public, max-age=3600", Responsive: true, MaxArea: 24_000_000, Parameterized: true, Worker: "worker-resize"},
+	{Name: "crop", DefaultFormat: "jpeg", CacheControl: DefaultCacheControl, Parameterized: true, Worker: "worker-crop"},
+	{Name: "sharpen", DefaultFormat: "jpeg", CacheControl: DefaultCacheControl, MaxArea: 24_000_000, Parameterized: true, Worker: "worker-sharp"},
+	{Name: "flip_h", DefaultFormat: "jpeg", CacheControl: DefaultCacheControl, Worker: "worker-fliph"},
+	{Name: "flip_v", DefaultFormat: "jpeg", CacheControl: DefaultCacheControl, Worker: "worker-flipv"},
+	{Name: "watermark", DefaultFormat: "jpeg", CacheControl: DefaultCacheControl, Parameterized: true, Worker: "worker-wm"},
+	{Name: "sepia", DefaultFormat: "jpeg", CacheControl: DefaultCacheControl, Parameterized: true, Worker: "worker-sepia"},
+	{Name: "brightness", DefaultFormat: "jpeg", CacheControl: DefaultCacheControl, Parameterized: true, Worker: "worker-bright"},
+	{Name: "contrast", DefaultFormat: "jpeg", CacheControl: DefaultCacheControl, Parameterized: true, Worker: "worker-contrast"},
+	// the original never changes, so neither does what inspect reads from it
+	{Name: InspectOp, DefaultFormat: "json", CacheControl: ImmutableCacheControl, Worker: "worker-inspect"},
+}
+
+// InspectOp stores the original's dimensions, format and EXIF as JSON
+// instead of rendering an image.
+const InspectOp = "inspect"
+
+// Select returns the ops an upload runs: the requested ones the registry
+// knows, or, when none were requested, the defaults plus each
+// parameterized op hasParams reports true for. Requested names the
+// registry doesn't know come back in unknown.
+func Select(requested []string, hasParams func(op string) bool) (selected, unknown []string) {
+	if len(requested) == 0 {
+		for _, s := range registry {
+			if s.Default {
+				selected = append(selected, s.Name)
+			}
+		}
+		for _, s := range registry {
+			if s.Parameterized && hasParams(s.Name) {
+				selected = append(selected, s.Name)
+			}
+		}
+		return selected, nil
+	}
+	selected = []string{}
+	for _, op := range requested {
+		if _, ok := Lookup(op); !ok {
+			unknown = append(unknown, op)
+			continue
+		}
+		selected = append(selected, op)
+	}
+	return selected, unknown
+}
+
+// Variant is one output of an upload: the op that renders it, the name it
+// is stored under, and, for a sized variant, the box it is rendered into.
+type Variant struct {
+	Name string
+	Op   string
+	Size int
+}
+
+// SizedOp is the op whose variants can come in several sizes.
+const SizedOp = "thumbnail"
+
+// Variants expands selected ops into the variants an upload produces. With
+// sizes set, a thumbnail the upload gave no params of its own becomes one
+// square variant per size, named like "thumbnail_300"; every other op is
+// a single variant named after it.
+func Variants(selected []string, sizes []int, hasParams func(op string) bool) []Variant {
+	out := make([]Variant, 0, len(selected))
+	for _, op := range selected {
+		if op != SizedOp || len(sizes) == 0 || hasParams(op) {
+			out = append(out, Variant{Name: op, Op: op})
+			continue
+		}
+		for _, n := range sizes {
+			out = append(out, Variant{Name: fmt.Sprintf("%s_%d", op, n), Op: op, Size: n})
+		}
+	}
+	return out
+}
+
+// BaseOp returns the op a variant name belongs to: the name itself for an
+// op's plain variant, else the op it extends with "_" suffixes, as in
+// thumbnail_300 or thumbnail_w300_png. Names of no registered op come back
+// unchanged.
+func BaseOp(name string) string {
+	for op := name; ; {
+		if _, ok := Lookup(op); ok {
+			return op
+		}
+		i := strings.LastIndexByte(op, '_')
+		if i <= 0 {
+			return name
+		}
+		op = op[:i]
+	}
+}
+
+// All returns a copy of the registry in declaration order.
+func All() []Spec {
+	out := make([]Spec, len(registry))
+	copy(out, registry)
+	return out
+}
+
+// Lookup returns the spec for op.
+func Lookup(op string) (Spec, bool) {
+	for _, s := range registry {
+		if s.Name == op {
+			return s, true
+		}
+	}
+	return Spec{}, false
+}
+
+// CacheControl returns the Cache-Control policy for the variant name, which
+// is its base op's, so thumbnail_300 gets thumbnail's.
+func CacheControl(name string) string {
+	if s, ok := Lookup(BaseOp(name)); ok && s.CacheControl != "" {
+		return s.CacheControl
+	}
+	return DefaultCacheControl
+}
+
+// Effort returns the encoder effort for op's variants.
+func Effort(op string) int {
+	if s, ok := Lookup(op); ok && s.Effort != 0 {
+		return s.Effort
+	}
+	return DefaultEffort
+}
+
+// WorkerType returns the actor type that runs op's workers.
+func WorkerType(op string) (string, bool) {
+	s, ok := Lookup(op)
+	return s.Worker, ok && s.Worker != ""
+}
+
+// MaxArea returns the largest input area, in pixels, op accepts, or 0 when
+// it has no limit.
+func MaxArea(op string) int64 {
+	s, _ := Lookup(op)
+	return s.MaxArea
+}
+
+// ValidEffort reports whether e is a usable effort level.
+func ValidEffort(e int) bool {
+	return e >= MinEffort && e <= MaxEffort
+}
+
+// formatExt maps a canonical output format to its file extension.
+var formatExt = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"gif":  ".gif",
+	"tiff": ".tiff",
+	"bmp":  ".bmp",
+	"webp": ".webp",
+}
+
+// dataExt maps the formats of ops that don't output an image, which uploads
+// can't ask for, to their file extension.
+var dataExt = map[string]string{
+	"json": ".json",
+}
+
+// NormalizeFormat canonicalizes a user-supplied format name ("JPG" -> "jpeg")
+// and reports whether the worker can encode it.
+func NormalizeFormat(f string) (string, bool) {
+	f = strings.ToLower(strings.TrimPrefix(f, "."))
+	switch f {
+	case "jpg":
+		f = "jpeg"
+	case "tif":
+		f = "tiff"
+	}
+	_, ok := formatExt[f]
+	return f, ok
+}
+
+// Extension returns the file extension (with dot) for a canonical format.
+func Extension(format string) string {
+	if ext, ok := formatExt[format]; ok {
+		return ext
+	}
+	return dataExt[format]
+}
+
+// OutputFormat resolves the format for a task: the requested one when it is
+// valid, otherwise the op's registered default, otherwise jpeg.
+func OutputFormat(op, requested string) string {
+	s, known := Lookup(op)
+	if _, data := dataExt[s.DefaultFormat]; known && data {
+		// an op that doesn't output an image has only the one format
+		return s.DefaultFormat
+	}
+	if requested != "" {
+		if f, ok := NormalizeFormat(requested); ok {
+			return f
+		}
+	}
+	if known && s.DefaultFormat != "" {
+		return s.DefaultFormat
+	}
+	return "jpeg"
+}
+
+// ParseColor parses an opaque "#rrggbb" (or "rrggbb") color, or the names
+// "white" and "black".
+func ParseColor(s string) (color.NRGBA, bool) {
+	switch strings.ToLower(s) {
+	case "white":
+		return color.NRGBA{255, 255, 255, 255}, true
+	case "black":
+		return color.NRGBA{0, 0, 0, 255}, true
+	}
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "#"))
+	if err != nil || len(b) != 3 {
+		return color.NRGBA{}, false
+	}
+	return color.NRGBA{b[0], b[1], b[2], 255}, true
+}
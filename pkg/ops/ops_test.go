@@ -0,0 +1,90 @@
+package ops
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutputFormat(t *testing.T) {
+	for _, tt := range []struct {
+		op, requested, want string
+	}{
+		{"thumbnail", "", "jpeg"},
+		{"thumbnail", "png", "png"},
+		{"thumbnail", "JPG", "jpeg"},
+		{"thumbnail", "bogus", "jpeg"},
+		{"inspect", "", "json"},
+		// inspect only ever writes JSON
+		{"inspect", "png", "json"},
+		{"no_such_op", "", "jpeg"},
+	} {
+		if got := OutputFormat(tt.op, tt.requested); got != tt.want {
+			t.Errorf("OutputFormat(%q, %q) = %q, want %q", tt.op, tt.requested, got, tt.want)
+		}
+	}
+}
+
+func TestOutputFormatFollowsRegistry(t *testing.T) {
+	for _, s := range All() {
+		if got := OutputFormat(s.Name, ""); got != s.DefaultFormat {
+			t.Errorf("%s: default output %q, registered %q", s.Name, got, s.DefaultFormat)
+		}
+	}
+}
+
+func TestBaseOp(t *testing.T) {
+	for name, want := range map[string]string{
+		"thumbnail":               "thumbnail",
+		"thumbnail_300":           "thumbnail",
+		"thumbnail_w300_h200_png": "thumbnail",
+		"flip_h":                  "flip_h",
+		"flip_h_webp":             "flip_h",
+		"resize_w800":             "resize",
+		"mystery_300":             "mystery_300",
+	} {
+		if got := BaseOp(name); got != want {
+			t.Errorf("BaseOp(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	validate := func(op string, params map[string]any) error {
+		if w, ok := params["width"].(int); ok && w <= 0 {
+			return errors.New("width must be positive")
+		}
+		return nil
+	}
+	for _, tt := range []struct {
+		name, file string
+		wantErr    string
+	}{
+		{"valid", "ops:\n  thumbnail: {width: 300, height: 300}\n", ""},
+		{"unknown op", "ops:\n  no_such_op: {width: 3}\n", `unknown op "no_such_op"`},
+		{"unknown key", "opz:\n  thumbnail: {width: 3}\n", "opz"},
+		{"invalid value", "ops:\n  thumbnail: {width: -5}\n", "thumbnail: width must be positive"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "defaults.yaml")
+			if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			d, err := LoadDefaults(path, validate)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("LoadDefaults: %v", err)
+				}
+				if d["thumbnail"]["width"] != 300 {
+					t.Errorf("thumbnail defaults = %v", d["thumbnail"])
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadDefaults error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"example.com/image-factory/pkg/ops"
+)
+
+// DiskStore persists images under a local directory using the same layout
+// the workers write:
+//
+//	{root}/{image_id}/original{ext}
+//	{root}/{image_id}/{op}{ext}
+//
+// The variant's extension is derived from its content type. It needs no
+// external services, which suits single-node installs.
+type DiskStore struct {
+	root string
+}
+
+// NewDiskStore stores images under root, creating it if needed.
+func NewDiskStore(root string) (*DiskStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &DiskStore{root: root}, nil
+}
+
+func (s *DiskStore) Close() {}
+
+func (s *DiskStore) dir(imageID string) string {
+	return filepath.Join(s.root, filepath.Base(imageID))
+}
+
+// find returns the file in an image's directory named base plus any
+// extension.
+func (s *DiskStore) find(imageID, base string) (string, error) {
+	matches, _ := filepath.Glob(filepath.Join(s.dir(imageID), base+".*"))
+	if p := filepath.Join(s.dir(imageID), base); len(matches) == 0 {
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+		return "", fmt.Errorf("%s/%s: %w", imageID, base, ErrNotFound)
+	}
+	return matches[0], nil
+}
+
+func (s *DiskStore) write(imageID, name string, data []byte) error {
+	if err := os.MkdirAll(s.dir(imageID), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.dir(imageID), name), data, 0644)
+}
+
+func (s *DiskStore) SaveOriginal(ctx context.Context, imageID, ext string, data []byte) error {
+	return s.write(imageID, "original"+ext, data)
+}
+
+func (s *DiskStore) GetOriginal(ctx context.Context, imageID string) ([]byte, string, error) {
+	p, err := s.find(imageID, "original")
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := os.ReadFile(p)
+	return data, filepath.Ext(p), err
+}
+
+func (s *DiskStore) SaveVariant(ctx context.Context, imageID, op, contentType string, data []byte) error {
+	ext := ""
+	if f, ok := ops.NormalizeFormat(strings.TrimPrefix(contentType, "image/")); ok {
+		ext = ops.Extension(f)
+	}
+	// drop any copy under another extension so find stays unambiguous
+	if old, err := s.find(imageID, op); err == nil && filepath.Ext(old) != ext {
+		os.Remove(old)
+	}
+	return s.write(imageID, op+ext, data)
+}
+
+func (s *DiskStore) GetVariant(ctx context.Context, imageID, op string) ([]byte, string, error) {
+	p, err := s.find(imageID, op)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := os.ReadFile(p)
+	return data, mime.TypeByExtension(filepath.Ext(p)), err
+}
+
+func (s *DiskStore) ListOps(ctx context.Context, imageID string) ([]string, error) {
+	entries, err := os.ReadDir(s.dir(imageID))
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+		if e.IsDir() || name == "original" || strings.HasPrefix(name, ".") {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetImageMetadata reports file modification times and sizes.
+func (s *DiskStore) GetImageMetadata(ctx context.Context, imageID string) (*ImageMetadata, error) {
+	p, err := s.find(imageID, "original")
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	meta := &ImageMetadata{
+		ImageID:       imageID,
+		CreatedAt:     fi.ModTime().UTC(),
+		OriginalBytes: fi.Size(),
+		Variants:      map[string]VariantMetadata{},
+	}
+	names, err := s.ListOps(ctx, imageID)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range names {
+		vp, err := s.find(imageID, op)
+		if err != nil {
+			continue
+		}
+		if vi, err := os.Stat(vp); err == nil {
+			meta.Variants[op] = VariantMetadata{CreatedAt: vi.ModTime().UTC(), Bytes: vi.Size()}
+		}
+	}
+	return meta, nil
+}
+
+func (s *DiskStore) DeleteImage(ctx context.Context, imageID string) error {
+	return os.RemoveAll(s.dir(imageID))
+}
+
+// HealthCheck verifies the root is still a writable directory.
+func (s *DiskStore) HealthCheck(ctx context.Context) error {
+	f, err := os.CreateTemp(s.root, ".healthcheck-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// GCSStore persists images as objects in a Cloud Storage bucket:
+//
+//	{prefix}{image_id}/original{ext}
+//	{prefix}{image_id}/variants/{op}
+//
+// Variant objects carry their content type; the original's extension is
+// kept in the object name.
+type GCSStore struct {
+	client *gcs.Client
+	bucket *gcs.BucketHandle
+	prefix string
+}
+
+// NewGCSStore opens bucket using application default credentials. prefix,
+// if set, is prepended to every object name.
+func NewGCSStore(ctx context.Context, bucket, prefix string) (*GCSStore, error) {
+	cli, err := gcs.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &GCSStore{client: cli, bucket: cli.Bucket(bucket), prefix: prefix}, nil
+}
+
+func (s *GCSStore) Close() { s.client.Close() }
+
+func (s *GCSStore) imageDir(imageID string) string {
+	return s.prefix + imageID + "/"
+}
+
+func (s *GCSStore) variantName(imageID, op string) string {
+	return s.imageDir(imageID) + "variants/" + op
+}
+
+func (s *GCSStore) write(ctx context.Context, name, contentType string, data []byte) error {
+	w := s.bucket.Object(name).NewWriter(ctx)
+	w.ContentType = contentType
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (s *GCSStore) read(ctx context.Context, name string) ([]byte, string, error) {
+	r, err := s.bucket.Object(name).NewReader(ctx)
+	if errors.Is(err, gcs.ErrObjectNotExist) {
+		return nil, "", fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	return data, r.Attrs.ContentType, err
+}
+
+// objects lists the objects under an image, keyed by name.
+func (s *GCSStore) objects(ctx context.Context, imageID string) (map[string]*gcs.ObjectAttrs, error) {
+	it := s.bucket.Objects(ctx, &gcs.Query{Prefix: s.imageDir(imageID)})
+	out := map[string]*gcs.ObjectAttrs{}
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		out[attrs.Name] = attrs
+	}
+}
+
+func (s *GCSStore) SaveOriginal(ctx context.Context, imageID, ext string, data []byte) error {
+	return s.write(ctx, s.imageDir(imageID)+"original"+ext, "", data)
+}
+
+// GetOriginal finds the original object whatever its extension.
+func (s *GCSStore) GetOriginal(ctx context.Context, imageID string) ([]byte, string, error) {
+	objs, err := s.objects(ctx, imageID)
+	if err != nil {
+		return nil, "", err
+	}
+	for name := range objs {
+		base := path.Base(name)
+		if strings.TrimSuffix(base, path.Ext(base)) == "original" && path.Dir(name)+"/" == s.imageDir(imageID) {
+			data, _, err := s.read(ctx, name)
+			return data, path.Ext(base), err
+		}
+	}
+	return nil, "", fmt.Errorf("original %s: %w", imageID, ErrNotFound)
+}
+
+func (s *GCSStore) SaveVariant(ctx context.Context, imageID, op, contentType string, data []byte) error {
+	return s.write(ctx, s.variantName(imageID, op), contentType, data)
+}
+
+func (s *GCSStore) GetVariant(ctx context.Context, imageID, op string) ([]byte, string, error) {
+	return s.read(ctx, s.variantName(imageID, op))
+}
+
+func (s *GCSStore) ListOps(ctx context.Context, imageID string) ([]string, error) {
+	objs, err := s.objects(ctx, imageID)
+	if err != nil {
+		return nil, err
+	}
+	ops := []string{}
+	for name := range objs {
+		if op, ok := strings.CutPrefix(name, s.imageDir(imageID)+"variants/"); ok {
+			ops = append(ops, op)
+		}
+	}
+	sort.Strings(ops)
+	return ops, nil
+}
+
+// GetImageMetadata reports object creation times and sizes.
+func (s *GCSStore) GetImageMetadata(ctx context.Context, imageID string) (*ImageMetadata, error) {
+	objs, err := s.objects(ctx, imageID)
+	if err != nil {
+		return nil, err
+	}
+	var meta *ImageMetadata
+	variants := map[string]VariantMetadata{}
+	for name, attrs := range objs {
+		rel := strings.TrimPrefix(name, s.imageDir(imageID))
+		if op, ok := strings.CutPrefix(rel, "variants/"); ok {
+			variants[op] = VariantMetadata{CreatedAt: attrs.Created, Bytes: attrs.Size}
+		} else if strings.TrimSuffix(rel, path.Ext(rel)) == "original" {
+			meta = &ImageMetadata{ImageID: imageID, CreatedAt: attrs.Created, OriginalBytes: attrs.Size}
+		}
+	}
+	if meta == nil {
+		return nil, fmt.Errorf("image %s: %w", imageID, ErrNotFound)
+	}
+	meta.Variants = variants
+	return meta, nil
+}
+
+func (s *GCSStore) DeleteImage(ctx context.Context, imageID string) error {
+	objs, err := s.objects(ctx, imageID)
+	if err != nil {
+		return err
+	}
+	for name := range objs {
+		if err := s.bucket.Object(name).Delete(ctx); err != nil && !errors.Is(err, gcs.ErrObjectNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// HealthCheck reads the bucket's attributes.
+func (s *GCSStore) HealthCheck(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err := s.bucket.Attrs(ctx)
+	return err
+}
@@ -2,10 +2,14 @@ package storage
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"time"
 
 	"cloud.google.com/go/spanner"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
 )
 
 // SpannerStore persists images and variants into Cloud Spanner.
@@ -22,14 +26,43 @@ import (
 //   Op STRING(MAX) NOT NULL,
 //   Data BYTES(MAX),
 //   ContentType STRING(64),
+//   ContentHash STRING(64),
 //   CreatedAt TIMESTAMP OPTIONS (allow_commit_timestamp=true)
 // ) PRIMARY KEY (ImageID, Op);
+//
+// Variants.Op holds the logical op name (e.g. "thumbnail"), never a file name;
+// the encoded format is carried by ContentType. ContentHash (hex SHA-256 of
+// Data) is indexed by VariantsByContentHash for content-addressed reads.
 
 type SpannerStore struct {
 	client *spanner.Client
 	dbName string
+
+	// WriteAttempts is how many times a write that fails with a transient
+	// error (see IsRetryable) is tried in all, waiting WriteBackoff, then
+	// twice that, and so on, between tries; 0 means 5 and 100ms.
+	WriteAttempts int
+	WriteBackoff  time.Duration
+
+	// BatchSize, above 1, makes SaveVariant queue its write to commit with
+	// others in one Apply, once BatchSize are queued or BatchInterval (0
+	// means 50ms) after the first. SaveVariant still returns only after
+	// its batch has committed, so a batch holds the saves that arrive
+	// while it fills, and can never hold more than the callers saving
+	// concurrently: size it to them, or every batch waits out the
+	// interval. Flush commits the queue at once.
+	BatchSize     int
+	BatchInterval time.Duration
+
+	batch mutationBatch
 }
 
+const (
+	defaultWriteAttempts = 5
+	defaultWriteBackoff  = 100 * time.Millisecond
+	maxWriteBackoff      = 5 * time.Second
+)
+
 func NewSpannerStore(ctx context.Context, dsn string) (*SpannerStore, error) {
 	cli, err := spanner.NewClient(ctx, dsn)
 	if err != nil {
@@ -38,24 +71,90 @@ func NewSpannerStore(ctx context.Context, dsn string) (*SpannerStore, error) {
 	return &SpannerStore{client: cli, dbName: dsn}, nil
 }
 
-func (s *SpannerStore) Close() { s.client.Close() }
+// Close commits any queued writes and closes the client.
+func (s *SpannerStore) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), batchCommitTimeout)
+	defer cancel()
+	_ = s.Flush(ctx)
+	s.client.Close()
+}
+
+// apply applies ms, retrying transient failures such as lock contention
+// aborts with exponential backoff until WriteAttempts run out or ctx ends.
+// Every write is an InsertOrUpdate or Delete, so a retry after an
+// ambiguous failure can't double-apply.
+func (s *SpannerStore) apply(ctx context.Context, ms []*spanner.Mutation) error {
+	attempts := s.WriteAttempts
+	if attempts <= 0 {
+		attempts = defaultWriteAttempts
+	}
+	backoff := s.WriteBackoff
+	if backoff <= 0 {
+		backoff = defaultWriteBackoff
+	}
+	for attempt := 1; ; attempt++ {
+		_, err := s.client.Apply(ctx, ms)
+		if err == nil || attempt >= attempts || !IsRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+		backoff = min(backoff*2, maxWriteBackoff)
+	}
+}
 
 func (s *SpannerStore) SaveOriginal(ctx context.Context, imageID, ext string, data []byte) error {
 	m := spanner.InsertOrUpdate("Images",
 		[]string{"ImageID", "Original", "OriginalExt", "CreatedAt"},
 		[]interface{}{imageID, data, ext, spanner.CommitTimestamp},
 	)
-	_, err := s.client.Apply(ctx, []*spanner.Mutation{m})
-	return err
+	return s.apply(ctx, []*spanner.Mutation{m})
+}
+
+// GetOriginal returns the stored original bytes and their file extension.
+func (s *SpannerStore) GetOriginal(ctx context.Context, imageID string) ([]byte, string, error) {
+	stmt := spanner.Statement{
+		SQL:    "SELECT Original, OriginalExt FROM Images WHERE ImageID=@id",
+		Params: map[string]interface{}{"id": imageID},
+	}
+	iter := s.client.Single().Query(ctx, stmt)
+	defer iter.Stop()
+	row, err := iter.Next()
+	if err != nil {
+		return nil, "", err
+	}
+	var data []byte
+	var ext spanner.NullString
+	if err := row.Columns(&data, &ext); err != nil {
+		return nil, "", err
+	}
+	return data, ext.StringVal, nil
+}
+
+// DeleteImage removes an image's original and all of its variants in one
+// transaction.
+func (s *SpannerStore) DeleteImage(ctx context.Context, imageID string) error {
+	ms := []*spanner.Mutation{
+		spanner.Delete("Variants", spanner.Key{imageID}.AsPrefix()),
+		spanner.Delete("Images", spanner.Key{imageID}),
+	}
+	return s.apply(ctx, ms)
 }
 
 func (s *SpannerStore) SaveVariant(ctx context.Context, imageID, op, contentType string, data []byte) error {
 	m := spanner.InsertOrUpdate("Variants",
-		[]string{"ImageID", "Op", "Data", "ContentType", "CreatedAt"},
-		[]interface{}{imageID, op, data, contentType, spanner.CommitTimestamp},
+		[]string{"ImageID", "Op", "Data", "ContentType", "ContentHash", "CreatedAt"},
+		[]interface{}{imageID, op, data, contentType, ContentHash(data), spanner.CommitTimestamp},
 	)
-	_, err := s.client.Apply(ctx, []*spanner.Mutation{m})
-	return err
+	if s.BatchSize > 1 {
+		return s.enqueue(ctx, m, len(data))
+	}
+	return s.apply(ctx, []*spanner.Mutation{m})
 }
 
 func (s *SpannerStore) GetVariant(ctx context.Context, imageID, op string) ([]byte, string, error) {
@@ -77,6 +176,94 @@ func (s *SpannerStore) GetVariant(ctx context.Context, imageID, op string) ([]by
 	return data, ct, nil
 }
 
+// GetVariantByHash returns any variant whose content hash matches.
+func (s *SpannerStore) GetVariantByHash(ctx context.Context, hash string) ([]byte, string, error) {
+	stmt := spanner.Statement{
+		SQL:    "SELECT Data, ContentType FROM Variants@{FORCE_INDEX=VariantsByContentHash} WHERE ContentHash=@hash LIMIT 1",
+		Params: map[string]interface{}{"hash": hash},
+	}
+	iter := s.client.Single().Query(ctx, stmt)
+	defer iter.Stop()
+	row, err := iter.Next()
+	if err != nil {
+		return nil, "", err
+	}
+	var data []byte
+	var ct string
+	if err := row.Columns(&data, &ct); err != nil {
+		return nil, "", err
+	}
+	return data, ct, nil
+}
+
+// ContentHash is the hex SHA-256 of data, used for content-addressed URLs.
+func ContentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ImageMetadata describes when an image and its variants were stored.
+type ImageMetadata struct {
+	ImageID       string                     `json:"image_id"`
+	CreatedAt     time.Time                  `json:"created_at"`
+	OriginalBytes int64                      `json:"original_bytes"`
+	Variants      map[string]VariantMetadata `json:"variants"`
+}
+
+// VariantMetadata describes one stored variant.
+type VariantMetadata struct {
+	CreatedAt time.Time `json:"created_at"`
+	Bytes     int64     `json:"bytes"`
+}
+
+// GetImageMetadata returns creation times and sizes for an image's original
+// and variants, read at a single timestamp.
+func (s *SpannerStore) GetImageMetadata(ctx context.Context, imageID string) (*ImageMetadata, error) {
+	txn := s.client.ReadOnlyTransaction()
+	defer txn.Close()
+	iter := txn.Query(ctx, spanner.Statement{
+		SQL:    "SELECT CreatedAt, LENGTH(Original) FROM Images WHERE ImageID=@id",
+		Params: map[string]interface{}{"id": imageID},
+	})
+	row, err := iter.Next()
+	iter.Stop()
+	if err != nil {
+		return nil, err
+	}
+	var created spanner.NullTime
+	var size spanner.NullInt64
+	if err := row.Columns(&created, &size); err != nil {
+		return nil, err
+	}
+	meta := &ImageMetadata{
+		ImageID:       imageID,
+		CreatedAt:     created.Time,
+		OriginalBytes: size.Int64,
+		Variants:      map[string]VariantMetadata{},
+	}
+
+	iter = txn.Query(ctx, spanner.Statement{
+		SQL:    "SELECT Op, CreatedAt, LENGTH(Data) FROM Variants WHERE ImageID=@id",
+		Params: map[string]interface{}{"id": imageID},
+	})
+	defer iter.Stop()
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var op string
+		if err := row.Columns(&op, &created, &size); err != nil {
+			return nil, err
+		}
+		meta.Variants[op] = VariantMetadata{CreatedAt: created.Time, Bytes: size.Int64}
+	}
+	return meta, nil
+}
+
 func (s *SpannerStore) ListOps(ctx context.Context, imageID string) ([]string, error) {
 	stmt := spanner.Statement{
 		SQL:    "SELECT Op FROM Variants WHERE ImageID=@id ORDER BY Op",
@@ -102,6 +289,104 @@ func (s *SpannerStore) ListOps(ctx context.Context, imageID string) ([]string, e
 	return ops, nil
 }
 
+// ImageSummary is one image in a ListImages page.
+type ImageSummary struct {
+	ImageID   string
+	CreatedAt time.Time
+	// Ops are the image's stored variants, sorted.
+	Ops []string
+}
+
+// ListImages returns up to limit images with ids after cursor that match
+// filter, ordered by id, with their variants, and how many images match in
+// all, read at a single timestamp. nextCursor is the cursor for the
+// following page, or "" on the last one.
+func (s *SpannerStore) ListImages(ctx context.Context, limit int, cursor string, filter ImageFilter) ([]ImageSummary, string, int, error) {
+	where := "TRUE"
+	params := map[string]interface{}{}
+	if len(filter.Has) > 0 {
+		where += ` AND (SELECT COUNT(DISTINCT v.Op) FROM Variants v
+			WHERE v.ImageID = i.ImageID AND v.Op IN UNNEST(@has)) = @nhas`
+		params["has"], params["nhas"] = filter.Has, int64(len(distinct(filter.Has)))
+	}
+	if len(filter.Missing) > 0 {
+		where += ` AND NOT EXISTS (SELECT 1 FROM Variants v
+			WHERE v.ImageID = i.ImageID AND v.Op IN UNNEST(@missing))`
+		params["missing"] = filter.Missing
+	}
+
+	txn := s.client.ReadOnlyTransaction()
+	defer txn.Close()
+	iter := txn.Query(ctx, spanner.Statement{SQL: "SELECT COUNT(*) FROM Images i WHERE " + where, Params: params})
+	row, err := iter.Next()
+	iter.Stop()
+	if err != nil {
+		return nil, "", 0, err
+	}
+	var total int64
+	if err := row.Columns(&total); err != nil {
+		return nil, "", 0, err
+	}
+
+	pageParams := map[string]interface{}{
+		"cursor": cursor,
+		// one extra row says whether there is a next page
+		"limit": int64(limit) + 1,
+	}
+	for k, v := range params {
+		pageParams[k] = v
+	}
+	iter = txn.Query(ctx, spanner.Statement{
+		SQL: `SELECT i.ImageID, i.CreatedAt,
+			ARRAY(SELECT v.Op FROM Variants v WHERE v.ImageID = i.ImageID ORDER BY v.Op)
+			FROM Images i WHERE i.ImageID > @cursor AND ` + where + ` ORDER BY i.ImageID LIMIT @limit`,
+		Params: pageParams,
+	})
+	defer iter.Stop()
+	page := []ImageSummary{}
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, "", 0, err
+		}
+		var img ImageSummary
+		var created spanner.NullTime
+		if err := row.Columns(&img.ImageID, &created, &img.Ops); err != nil {
+			return nil, "", 0, err
+		}
+		img.CreatedAt = created.Time
+		page = append(page, img)
+	}
+	next := ""
+	if len(page) > limit {
+		page = page[:limit]
+		next = page[limit-1].ImageID
+	}
+	return page, next, int(total), nil
+}
+
+// distinct returns names without repeats, in first-seen order.
+func distinct(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	out := names[:0:0]
+	for _, n := range names {
+		if !seen[n] {
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// IsNotFound reports whether err means the requested image or variant
+// doesn't exist, in any backend.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, iterator.Done) || spanner.ErrCode(err) == codes.NotFound
+}
+
 // HealthCheck quickly pings the DB.
 func (s *SpannerStore) HealthCheck(ctx context.Context) error {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/spanner"
+)
+
+const (
+	defaultBatchInterval = 50 * time.Millisecond
+	// maxBatchBytes flushes a batch early so one commit stays well under
+	// Spanner's 100MB request limit.
+	maxBatchBytes = 32 << 20
+	// batchCommitTimeout bounds one batch's Apply, retries included.
+	batchCommitTimeout = 30 * time.Second
+)
+
+// queuedWrite is one mutation waiting in a batch, and where its writer
+// waits for the batch's outcome.
+type queuedWrite struct {
+	m    *spanner.Mutation
+	size int
+	done chan error
+}
+
+// mutationBatch collects mutations from concurrent writers so they commit
+// in one Apply.
+type mutationBatch struct {
+	mu     sync.Mutex
+	queued []queuedWrite
+	bytes  int
+	timer  *time.Timer
+	// batches sent and not yet committed, by the channel closed when each
+	// is; kept under mu, unlike a WaitGroup, so Flush can wait for them
+	// while the timer sends another
+	committing map[chan struct{}]struct{}
+}
+
+// enqueue adds m to the open batch, starting the batch's timer if it is
+// the first, and waits for the batch to commit. The batch goes out once
+// it holds BatchSize writes or maxBatchBytes, or BatchInterval after its
+// first write. If ctx ends first, the write may still commit.
+func (s *SpannerStore) enqueue(ctx context.Context, m *spanner.Mutation, size int) error {
+	done := make(chan error, 1)
+	b := &s.batch
+	b.mu.Lock()
+	b.queued = append(b.queued, queuedWrite{m: m, size: size, done: done})
+	b.bytes += size
+	switch {
+	case len(b.queued) >= s.BatchSize || b.bytes >= maxBatchBytes:
+		s.flushLocked()
+	case len(b.queued) == 1:
+		interval := s.BatchInterval
+		if interval <= 0 {
+			interval = defaultBatchInterval
+		}
+		b.timer = time.AfterFunc(interval, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			s.flushLocked()
+		})
+	}
+	b.mu.Unlock()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// flushLocked sends the open batch, if any, off to commit. b.mu is held.
+func (s *SpannerStore) flushLocked() {
+	b := &s.batch
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.queued) == 0 {
+		return
+	}
+	writes := b.queued
+	b.queued, b.bytes = nil, 0
+	committed := make(chan struct{})
+	if b.committing == nil {
+		b.committing = make(map[chan struct{}]struct{})
+	}
+	b.committing[committed] = struct{}{}
+	go func() {
+		defer func() {
+			b.mu.Lock()
+			delete(b.committing, committed)
+			b.mu.Unlock()
+			close(committed)
+		}()
+		ms := make([]*spanner.Mutation, len(writes))
+		for i, w := range writes {
+			ms[i] = w.m
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), batchCommitTimeout)
+		defer cancel()
+		err := s.apply(ctx, ms)
+		for _, w := range writes {
+			w.done <- err
+		}
+	}()
+}
+
+// Flush commits the queued variant writes now, without waiting out
+// BatchInterval, and returns once every batch sent has committed or
+// failed.
+func (s *SpannerStore) Flush(ctx context.Context) error {
+	b := &s.batch
+	b.mu.Lock()
+	s.flushLocked()
+	sent := make([]chan struct{}, 0, len(b.committing))
+	for c := range b.committing {
+		sent = append(sent, c)
+	}
+	b.mu.Unlock()
+	for _, c := range sent {
+		select {
+		case <-c:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"cloud.google.com/go/spanner"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+)
+
+// Store persists originals and their variants. SpannerStore, GCSStore and
+// DiskStore implement it; the API and workers only use this interface.
+type Store interface {
+	SaveOriginal(ctx context.Context, imageID, ext string, data []byte) error
+	// GetOriginal returns the original bytes and their file extension.
+	GetOriginal(ctx context.Context, imageID string) ([]byte, string, error)
+	SaveVariant(ctx context.Context, imageID, op, contentType string, data []byte) error
+	// GetVariant returns a variant's bytes and content type.
+	GetVariant(ctx context.Context, imageID, op string) ([]byte, string, error)
+	// ListOps returns the ops with a stored variant, sorted.
+	ListOps(ctx context.Context, imageID string) ([]string, error)
+	// GetImageMetadata returns creation times and sizes for an image.
+	GetImageMetadata(ctx context.Context, imageID string) (*ImageMetadata, error)
+	// DeleteImage removes an image's original and all of its variants.
+	DeleteImage(ctx context.Context, imageID string) error
+	HealthCheck(ctx context.Context) error
+	Close()
+}
+
+// HashLookup is implemented by stores that index variants by ContentHash.
+type HashLookup interface {
+	GetVariantByHash(ctx context.Context, hash string) ([]byte, string, error)
+}
+
+// ImageLister is implemented by stores that can page through every stored
+// image by id, filtered by the variants each has, with the number of
+// matching images in all.
+type ImageLister interface {
+	ListImages(ctx context.Context, limit int, cursor string, filter ImageFilter) (images []ImageSummary, nextCursor string, total int, err error)
+}
+
+// ImageFilter keeps the images that have every variant in Has and none in
+// Missing; the zero filter keeps every image.
+type ImageFilter struct {
+	Has, Missing []string
+}
+
+// Flusher is implemented by stores that queue writes; Flush commits them
+// without waiting for the queue to fill.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+var (
+	_ Store       = (*SpannerStore)(nil)
+	_ Store       = (*GCSStore)(nil)
+	_ Store       = (*DiskStore)(nil)
+	_ HashLookup  = (*SpannerStore)(nil)
+	_ Flusher     = (*SpannerStore)(nil)
+	_ ImageLister = (*SpannerStore)(nil)
+)
+
+// ErrNotFound is returned (possibly wrapped) by the GCS and disk stores for
+// a missing image or variant; IsNotFound also recognizes Spanner's forms.
+var ErrNotFound = errors.New("not found")
+
+// IsRetryable reports whether err is a transient store error worth
+// retrying, in any backend: a gRPC code for a blip (Spanner, or GCS over
+// gRPC), a GCS HTTP 408, 429 or 5xx, a network timeout or dropped
+// connection, or a disk call that was interrupted or told to try again.
+func IsRetryable(err error) bool {
+	if err == nil || IsNotFound(err) {
+		return false
+	}
+	switch spanner.ErrCode(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted, codes.ResourceExhausted, codes.Internal:
+		return true
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusRequestTimeout || apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= 500
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	for _, transient := range []error{io.ErrUnexpectedEOF, syscall.ECONNRESET, syscall.EPIPE, syscall.EAGAIN, syscall.EINTR, syscall.EBUSY} {
+		if errors.Is(err, transient) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsRetryable(t *testing.T) {
+	timeout := &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	for _, tt := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"not found", fmt.Errorf("variant: %w", ErrNotFound), false},
+		{"grpc unavailable", status.Error(codes.Unavailable, "try later"), true},
+		{"grpc aborted", status.Error(codes.Aborted, "txn aborted"), true},
+		{"grpc not found", status.Error(codes.NotFound, "no row"), false},
+		{"grpc invalid argument", status.Error(codes.InvalidArgument, "bad"), false},
+		{"gcs 503", &googleapi.Error{Code: 503}, true},
+		{"gcs 429", fmt.Errorf("read: %w", &googleapi.Error{Code: 429}), true},
+		{"gcs 408", &googleapi.Error{Code: 408}, true},
+		{"gcs 403", &googleapi.Error{Code: 403}, false},
+		{"network timeout", timeout, true},
+		{"connection reset", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{"truncated body", fmt.Errorf("read object: %w", io.ErrUnexpectedEOF), true},
+		{"disk busy", &os.PathError{Op: "open", Path: "/data/x", Err: syscall.EBUSY}, true},
+		{"disk interrupted", &os.PathError{Op: "read", Path: "/data/x", Err: syscall.EINTR}, true},
+		{"disk permission", &os.PathError{Op: "open", Path: "/data/x", Err: syscall.EACCES}, false},
+		{"canceled", context.Canceled, false},
+		{"plain", errors.New("boom"), false},
+	} {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("%s: IsRetryable(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
@@ -19,6 +19,11 @@ import {
 import CloudUploadIcon from "@mui/icons-material/CloudUpload";
 
 type Variants = Record<string, Record<string, string>>;
+type ImagesPage = {
+  images: { id: string; variants: Record<string, string> }[];
+  total: number;
+  next: string;
+};
 
 type MetricsPayload = {
   total_uploads: number;
@@ -54,8 +59,10 @@ function App() {
   });
 
   const refresh = async () => {
-    const { data } = await axios.get<Variants>("/images");
-    setVariants(data || {});
+    const { data } = await axios.get<ImagesPage>("/images");
+    const page: Variants = {};
+    for (const img of data?.images || []) page[img.id] = img.variants;
+    setVariants(page);
     try {
       const { data: met } = await axios.get<MetricsPayload>("/metrics/json");
       setM((prev) => ({ ...prev, ...(met || {}) }));
@@ -226,17 +233,29 @@ function App() {
                       <Typography variant="caption" sx={{ color: "#666" }}>
                         {op}
                       </Typography>
-                      <Box
-                        component="img"
-                        src={url}
-                        sx={{
-                          width: "100%",
-                          height: 200,
-                          objectFit: "cover",
-                          borderRadius: 1,
-                          border: "1px solid #e5e5e5",
-                        }}
-                      />
+                      {op === "inspect" ? (
+                        <Typography
+                          component="a"
+                          href={url}
+                          target="_blank"
+                          variant="body2"
+                          sx={{ display: "block" }}
+                        >
+                          metadata (JSON)
+                        </Typography>
+                      ) : (
+                        <Box
+                          component="img"
+                          src={url}
+                          sx={{
+                            width: "100%",
+                            height: 200,
+                            objectFit: "cover",
+                            borderRadius: 1,
+                            border: "1px solid #e5e5e5",
+                          }}
+                        />
+                      )}
                     </Box>
                   ))}
                 </Stack>